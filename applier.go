@@ -0,0 +1,559 @@
+package ltx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/superfly/ltx/internal/sqlitepage"
+)
+
+// applyBatchSize is the maximum size of the pages written to the database
+// between each sync of the rollback journal.
+const applyBatchSize = 4 << 20
+
+// Applier applies LTX files in order to a SQLite database file.
+//
+// The original contents of each overwritten page are first written to a
+// rollback journal, see JournalPath(), which is removed once the database has
+// been synced. If a file fails to apply then the journal is rolled back so
+// the database is left at its previous position. A journal left by a crash is
+// rolled back by Recover(). Shard sets share a single journal so the set is
+// applied atomically.
+//
+// If Locking is enabled then SQLite-compatible locks are held while the
+// database is modified so SQLite connections never read a partially applied
+// file. A journal left by a crash is then also rolled back by the next SQLite
+// connection to open the database.
+//
+// If DryRun is enabled then files are verified against the database but the
+// database is not modified. The checksum of each page is tracked in memory so
+// later files are verified as if the earlier files had been applied.
+type Applier struct {
+	f      *os.File
+	locked bool
+
+	// Database lineage that all applied files must belong to. Set from the
+	// first applied file with an ID if zero.
+	DatabaseID DatabaseID
+
+	// Header of the last applied shard if a shard set is partially applied.
+	shard *Header
+
+	// True if the last applied snapshot omitted free pages.
+	freePages bool
+
+	// Rollback journal for the file or shard set being applied.
+	journal *journal
+
+	// Simulated database for dry runs & its state before the file or shard
+	// set being applied.
+	dry, dryPrev *dryRunDB
+
+	// If true, files are verified but not written to the database.
+	DryRun bool
+
+	// If true, SQLite's RESERVED, PENDING & EXCLUSIVE locks are acquired
+	// before modifying the database. The file change counter is incremented
+	// after each commit so SQLite connections discard their page cache.
+	// Applying fails with errors.ErrUnsupported if LockingSupported is false.
+	Locking bool
+
+	// Maximum time to wait for SQLite connections to release their locks.
+	BusyTimeout time.Duration
+
+	// Specifies how a database in WAL mode is handled when Locking is enabled.
+	WALMode WALMode
+}
+
+// NewApplier returns a new instance of Applier which writes to f.
+func NewApplier(f *os.File) *Applier {
+	return &Applier{
+		f:           f,
+		BusyTimeout: DefaultBusyTimeout,
+	}
+}
+
+// PendingShard returns the header of the last applied shard if a shard set is
+// partially applied. Otherwise returns nil.
+func (a *Applier) PendingShard() *Header { return a.shard }
+
+// Recover rolls back the journal left by an interrupted apply, if one exists,
+// so the database returns to its position before that file or shard set.
+// Returns true if a journal was rolled back.
+//
+// This should be called before applying files to a database that may have
+// been written by a process which crashed. Apply() fails while a journal exists.
+func (a *Applier) Recover() (bool, error) {
+	if a.journal != nil {
+		return false, fmt.Errorf("cannot recover while applying")
+	} else if a.DryRun {
+		return false, a.checkNoJournal()
+	}
+
+	if err := a.lock(); err != nil {
+		return false, err
+	}
+	defer func() { _ = a.unlock() }()
+
+	return recoverJournal(a.f)
+}
+
+// Rollback restores the database to its position before a partially applied
+// shard set. This is a no-op if no shard set is pending.
+func (a *Applier) Rollback() error {
+	a.shard = nil
+	if a.dryPrev != nil {
+		a.dry, a.dryPrev = a.dryPrev, nil
+	}
+	if a.journal == nil {
+		return a.unlock()
+	}
+	j := a.journal
+	a.journal = nil
+	if err := j.rollback(); err != nil {
+		return err
+	}
+	return a.unlock()
+}
+
+// Apply applies the LTX file read from r to the database and returns its
+// header & trailer. The database checksum is verified before & after applying
+// unless checksums are disabled for the file. The database is synced once the
+// file, or the last shard of a shard set, has been applied.
+//
+// On error, the database is rolled back to its position before the file or,
+// for shards, before the shard set.
+func (a *Applier) Apply(r io.Reader) (_ Header, _ Trailer, retErr error) {
+	defer func() {
+		if retErr != nil && (a.journal != nil || a.dryPrev != nil) {
+			if err := a.Rollback(); err != nil {
+				retErr = fmt.Errorf("%w (rollback: %s)", retErr, err)
+			}
+		}
+
+		// Locks are held until the file or shard set is committed or rolled back.
+		if a.journal == nil {
+			if err := a.unlock(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}
+	}()
+
+	// Read LTX header and verify initial checksum matches.
+	dec := NewDecoder(r)
+	if err := dec.DecodeHeader(); err != nil {
+		return Header{}, Trailer{}, fmt.Errorf("decode ltx header: %w", err)
+	}
+	hdr := dec.Header()
+
+	// Ensure the file belongs to the same database as previously applied files.
+	if err := CheckDatabaseID(a.DatabaseID, hdr.DatabaseID); err != nil {
+		return hdr, Trailer{}, err
+	} else if a.DatabaseID.IsZero() {
+		a.DatabaseID = hdr.DatabaseID
+	}
+
+	// Shards must be applied as a complete set in page order. The database is
+	// only consistent once the last shard in the set has been applied.
+	if a.shard != nil && !IsNextShard(*a.shard, hdr) {
+		return hdr, Trailer{}, fmt.Errorf("incomplete shard set: expected shard starting at page %d", a.shard.ShardMaxPgno+1)
+	} else if a.shard == nil && !hdr.IsFirstShard() {
+		return hdr, Trailer{}, fmt.Errorf("shard applied out of order: starts at page %d", hdr.ShardMinPgno)
+	}
+
+	if a.DryRun {
+		if err := a.loadDryRunDB(hdr.PageSize); err != nil {
+			return hdr, Trailer{}, err
+		}
+	} else if err := a.lock(); err != nil {
+		return hdr, Trailer{}, err
+	}
+
+	// Read checksum before applying.
+	if !hdr.IsSnapshot() && !hdr.NoChecksum() && hdr.IsFirstShard() {
+		preApplyChecksum, orig, err := a.checksum(hdr.PageSize)
+		if err != nil {
+			return hdr, Trailer{}, fmt.Errorf("compute pre-apply checksum: %w", err)
+		} else if preApplyChecksum != hdr.PreApplyChecksum && orig != hdr.PreApplyChecksum {
+			return hdr, Trailer{}, preApplyChecksumError(preApplyChecksum, hdr.PreApplyChecksum, a.freePages)
+		}
+	}
+
+	// Journal the original pages of the database before modifying it.
+	if a.DryRun {
+		if a.dryPrev == nil {
+			a.dryPrev = a.dry.clone()
+		}
+	} else if a.journal == nil {
+		j, err := createJournal(a.f, hdr.PageSize)
+		if err != nil {
+			return hdr, Trailer{}, err
+		}
+		a.journal = j
+	}
+
+	if err := a.applyPages(dec, hdr.PageSize); err != nil {
+		return hdr, Trailer{}, err
+	}
+
+	// Close & verify file.
+	if err := dec.Close(); err != nil {
+		return hdr, Trailer{}, fmt.Errorf("close ltx file: %w", err)
+	}
+	trailer := dec.Trailer()
+
+	// Wait until all shards are applied before truncating & verifying.
+	if !hdr.IsLastShard() {
+		a.shard = &hdr
+		return hdr, trailer, nil
+	}
+	a.shard = nil
+
+	if a.DryRun {
+		return a.commitDryRun(hdr, trailer)
+	}
+
+	// Journal any pages removed by truncation.
+	for pgno := hdr.Commit + 1; pgno <= a.journal.dbSize; pgno++ {
+		if err := a.journal.add(pgno); err != nil {
+			return hdr, trailer, err
+		}
+	}
+	if err := a.journal.sync(); err != nil {
+		return hdr, trailer, err
+	} else if err := a.f.Truncate(int64(hdr.Commit) * int64(hdr.PageSize)); err != nil {
+		return hdr, trailer, fmt.Errorf("truncate database: %w", err)
+	}
+
+	// Recalculate database checksum and ensure it matches the LTX checksum.
+	if !hdr.NoChecksum() {
+		postApplyChecksum, orig, err := a.checksum(hdr.PageSize)
+		if err != nil {
+			return hdr, trailer, fmt.Errorf("compute post-apply checksum: %w", err)
+		} else if postApplyChecksum != trailer.PostApplyChecksum && orig != trailer.PostApplyChecksum {
+			return hdr, trailer, fmt.Errorf("post-apply checksum mismatch: %s <> %s", postApplyChecksum, trailer.PostApplyChecksum)
+		}
+	}
+
+	if a.Locking {
+		if err := a.incrementChangeCounter(); err != nil {
+			return hdr, trailer, err
+		}
+	}
+
+	// Sync the database & remove the journal to commit the changes.
+	j := a.journal
+	a.journal = nil
+	if err := j.commit(); err != nil {
+		return hdr, trailer, err
+	}
+	if hdr.IsSnapshot() {
+		a.freePages = hdr.HasFreePages()
+	}
+	return hdr, trailer, nil
+}
+
+// applyPages writes the pages decoded from dec to the database in batches.
+// The original pages of each batch are journaled & synced before the batch is
+// written.
+func (a *Applier) applyPages(dec *Decoder, pageSize uint32) error {
+	if a.DryRun {
+		return a.applyDryRunPages(dec, pageSize)
+	}
+
+	n := max(1, applyBatchSize/int(pageSize))
+	pgnos := make([]uint32, 0, n)
+	var buf []byte
+
+	flush := func() error {
+		if err := a.journal.sync(); err != nil {
+			return err
+		}
+
+		fi, err := a.f.Stat()
+		if err != nil {
+			return err
+		}
+		size := fi.Size()
+
+		for i, pgno := range pgnos {
+			data := buf[i*int(pageSize) : (i+1)*int(pageSize)]
+			off := int64(pgno-1) * int64(pageSize)
+			if err := a.writePage(data, off, size); err != nil {
+				return fmt.Errorf("write database page: %w", err)
+			}
+			size = max(size, off+int64(pageSize))
+		}
+		pgnos, buf = pgnos[:0], buf[:0]
+		return nil
+	}
+
+	data := make([]byte, pageSize)
+	for {
+		var pageHeader PageHeader
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode ltx page: %w", err)
+		}
+
+		if err := a.journal.add(pageHeader.Pgno); err != nil {
+			return err
+		}
+		pgnos, buf = append(pgnos, pageHeader.Pgno), append(buf, data...)
+
+		if len(pgnos) == n {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// writePage writes a page to the database at off. Zero pages are written as
+// holes: pages past the end of the file are skipped, as the file is extended
+// with zeros by later writes or truncation, and existing pages are deallocated
+// where supported.
+func (a *Applier) writePage(data []byte, off, size int64) error {
+	if isZeroPage(data) {
+		if off >= size {
+			return nil
+		} else if err := punchHole(a.f, off, int64(len(data))); err == nil {
+			return nil
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	_, err := a.f.WriteAt(data, off)
+	return err
+}
+
+// applyDryRunPages updates the simulated database with the pages decoded
+// from dec.
+func (a *Applier) applyDryRunPages(dec *Decoder, pageSize uint32) error {
+	data := make([]byte, pageSize)
+	for {
+		var pageHeader PageHeader
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decode ltx page: %w", err)
+		}
+		a.dry.write(pageHeader.Pgno, data)
+	}
+}
+
+// checksum computes the checksum of the entire database file. Also returns
+// the checksum of the database before its change counter was incremented by
+// an Applier, which is the same as the checksum unless the SQLite header shows
+// that the counter was incremented, see restoreChangeCounter().
+func (a *Applier) checksum(pageSize uint32) (chksum, orig Checksum, err error) {
+	if a.DryRun {
+		chksum, orig = a.dry.checksum()
+		return chksum, orig, nil
+	}
+
+	if _, err := a.f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	} else if chksum, err = ChecksumReader(a.f, int(pageSize)); err != nil {
+		return 0, 0, err
+	}
+
+	page := make([]byte, pageSize)
+	if _, err := a.f.ReadAt(page, 0); err == io.EOF {
+		return chksum, chksum, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	return chksum, origChecksum(chksum, page), nil
+}
+
+// origChecksum returns the database checksum with page 1 restored to its
+// contents before its change counter was incremented.
+func origChecksum(chksum Checksum, page1 []byte) Checksum {
+	other := restoreChangeCounter(page1)
+	if other == nil {
+		return chksum
+	}
+	return ChecksumFlag | (chksum ^ ChecksumPage(1, page1) ^ ChecksumPage(1, other))
+}
+
+// lock acquires the SQLite locks on the database, if locking is enabled, and
+// handles any WAL. This is a no-op if the locks are already held.
+func (a *Applier) lock() error {
+	if !a.Locking || a.locked {
+		return nil
+	}
+
+	if err := lockDatabase(a.f, a.BusyTimeout); err != nil {
+		return err
+	}
+	a.locked = true
+
+	if err := checkWALMode(a.f.Name(), a.WALMode); err != nil {
+		_ = a.unlock()
+		return err
+	}
+	return nil
+}
+
+// unlock releases the SQLite locks on the database, if held.
+func (a *Applier) unlock() error {
+	if !a.locked {
+		return nil
+	}
+	a.locked = false
+	return unlockDatabase(a.f)
+}
+
+// incrementChangeCounter journals page 1 & increments the file change counter
+// in the SQLite database header. The version-valid-for number is unchanged so
+// the original counter is restored when computing the checksum. This is a
+// no-op if the database does not have a SQLite header.
+func (a *Applier) incrementChangeCounter() error {
+	hdr, err := readSQLiteHeader(a.f)
+	if err != nil || hdr == nil {
+		return err
+	}
+
+	if err := a.journal.add(1); err != nil {
+		return err
+	} else if err := a.journal.sync(); err != nil {
+		return err
+	}
+
+	if _, err := a.f.WriteAt(incrementChangeCounter(hdr), sqlitepage.ChangeCounterOffset); err != nil {
+		return fmt.Errorf("write change counter: %w", err)
+	}
+	return nil
+}
+
+// checkNoJournal returns an error if the database has a hot journal. A dry run
+// cannot verify files against a database which must be rolled back.
+func (a *Applier) checkNoJournal() error {
+	if a.f == nil {
+		return nil
+	} else if _, err := os.Stat(JournalPath(a.f.Name())); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("hot journal exists, database must be recovered before a dry run")
+}
+
+// loadDryRunDB reads the page checksums of the database for a dry run, unless
+// already loaded with the same page size. The database is empty if the
+// applier has no file.
+func (a *Applier) loadDryRunDB(pageSize uint32) error {
+	if a.dry != nil && a.dry.pageSize == pageSize {
+		return nil
+	}
+
+	db := &dryRunDB{pageSize: pageSize}
+	if a.f != nil {
+		r := io.NewSectionReader(a.f, 0, math.MaxInt64)
+		data := make([]byte, pageSize)
+		for pgno := uint32(1); ; pgno++ {
+			if _, err := io.ReadFull(r, data); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("read database page %d: %w", pgno, err)
+			}
+			db.write(pgno, data)
+		}
+	}
+	a.dry = db
+	return nil
+}
+
+// commitDryRun truncates the simulated database & verifies its checksum.
+func (a *Applier) commitDryRun(hdr Header, trailer Trailer) (Header, Trailer, error) {
+	a.dry.truncate(hdr.Commit)
+
+	if !hdr.NoChecksum() {
+		if postApplyChecksum, orig := a.dry.checksum(); postApplyChecksum != trailer.PostApplyChecksum && orig != trailer.PostApplyChecksum {
+			return hdr, trailer, fmt.Errorf("post-apply checksum mismatch: %s <> %s", postApplyChecksum, trailer.PostApplyChecksum)
+		}
+	}
+	a.dryPrev = nil
+	if hdr.IsSnapshot() {
+		a.freePages = hdr.HasFreePages()
+	}
+	return hdr, trailer, nil
+}
+
+// dryRunDB tracks the checksum of each page of a database as if files had
+// been applied to it.
+type dryRunDB struct {
+	pageSize uint32
+	sums     []Checksum // indexed by pgno-1, zero for the lock page
+	page1    []byte
+}
+
+// write sets the contents of pgno. Pages between the end of the database &
+// pgno are zero-filled, as when writing past the end of a file.
+func (db *dryRunDB) write(pgno uint32, data []byte) {
+	db.grow(pgno)
+	if pgno != LockPgno(db.pageSize) {
+		db.sums[pgno-1] = ChecksumPage(pgno, data)
+	}
+	if pgno == 1 {
+		db.page1 = bytes.Clone(data)
+	}
+}
+
+// truncate sets the size of the database to commit pages.
+func (db *dryRunDB) truncate(commit uint32) {
+	db.grow(commit)
+	db.sums = db.sums[:commit]
+	if commit == 0 {
+		db.page1 = nil
+	}
+}
+
+// grow zero-fills the database until it has at least n pages.
+func (db *dryRunDB) grow(n uint32) {
+	if n <= uint32(len(db.sums)) {
+		return
+	}
+
+	zero := make([]byte, db.pageSize)
+	for pgno := uint32(len(db.sums)) + 1; pgno <= n; pgno++ {
+		var chksum Checksum
+		if pgno != LockPgno(db.pageSize) {
+			chksum = ChecksumPage(pgno, zero)
+		}
+		db.sums = append(db.sums, chksum)
+		if pgno == 1 {
+			db.page1 = zero
+		}
+	}
+}
+
+// checksum returns the checksum of the database & the checksum with page 1
+// restored to before its change counter was incremented.
+func (db *dryRunDB) checksum() (chksum, orig Checksum) {
+	for _, sum := range db.sums {
+		if sum != 0 {
+			chksum = ChecksumFlag | (chksum ^ sum)
+		}
+	}
+	return chksum, origChecksum(chksum, db.page1)
+}
+
+func (db *dryRunDB) clone() *dryRunDB {
+	return &dryRunDB{
+		pageSize: db.pageSize,
+		sums:     slices.Clone(db.sums),
+		page1:    bytes.Clone(db.page1),
+	}
+}
@@ -0,0 +1,232 @@
+package ltx_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestApplier_Apply(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		for _, data := range [][]byte{ltxtest.EncodeFile(t, 0, 1, 2), ltxtest.EncodeFile(t, 0, 3, 3)} {
+			if _, _, err := a.Apply(bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assertApplierDB(t, f, bytes.Repeat([]byte{3}, 512))
+	})
+
+	// A file which fails to apply is rolled back using the journal.
+	t.Run("RollbackOnError", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		spec := &ltx.FileSpec{
+			Header: ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 2, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltxtest.FileChecksum(2)},
+			Pages: []ltx.PageSpec{
+				{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{3}, 512)},
+				{Header: ltx.PageHeader{Pgno: 2}, Data: bytes.Repeat([]byte{3}, 512)},
+			},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+		}
+		if _, err := spec.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := a.Apply(&buf); err == nil || !strings.Contains(err.Error(), "post-apply checksum mismatch") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertApplierDB(t, f, bytes.Repeat([]byte{2}, 512))
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); err != nil {
+			t.Fatal(err)
+		}
+
+		// Applying fails while a journal exists until it is recovered.
+		if err := os.WriteFile(ltx.JournalPath(f.Name()), nil, 0o666); err != nil {
+			t.Fatal(err)
+		} else if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 3, 3))); err == nil || !strings.Contains(err.Error(), "hot journal exists") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ok, err := a.Recover(); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected recovery")
+		} else if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 3, 3))); err != nil {
+			t.Fatal(err)
+		}
+		assertApplierDB(t, f, bytes.Repeat([]byte{3}, 512))
+	})
+}
+
+// Zero pages are written as holes, both within & past the end of the file.
+func TestApplier_Apply_ZeroPages(t *testing.T) {
+	a, f := newTestApplier(t)
+
+	zero, data := make([]byte, 512), bytes.Repeat([]byte{1}, 512)
+	for i, pages := range [][][]byte{{data, data, data}, {data, zero, data, zero, zero}} {
+		spec := &ltx.FileSpec{
+			Header: ltx.Header{Version: ltx.Version, PageSize: 512, Commit: uint32(len(pages)), MinTXID: 1, MaxTXID: ltx.TXID(i + 1)},
+		}
+		for j, page := range pages {
+			spec.Pages = append(spec.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: uint32(j + 1)}, Data: page})
+		}
+		want := bytes.Join(pages, nil)
+		spec.Trailer.PostApplyChecksum = mustChecksumReader(t, want)
+
+		var buf bytes.Buffer
+		if _, err := spec.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if _, _, err := a.Apply(&buf); err != nil {
+			t.Fatal(err)
+		}
+		assertApplierDB(t, f, want)
+	}
+}
+
+func TestApplier_Apply_ChangeCounter(t *testing.T) {
+	// The change counter is incremented after each apply when locking but the
+	// database checksum is still verified against the original counter.
+	t.Run("OK", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		a.Locking = true
+
+		page1 := make([]byte, 512)
+		copy(page1, "SQLite format 3\x00")
+		binary.BigEndian.PutUint32(page1[24:], 7)
+		binary.BigEndian.PutUint32(page1[92:], 7)
+
+		for i, data := range [][]byte{bytes.Repeat([]byte{1}, 512), bytes.Repeat([]byte{2}, 512)} {
+			txID := ltx.TXID(i + 1)
+			spec := &ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 2, MinTXID: txID, MaxTXID: txID},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 2}, Data: data}},
+				Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | (ltx.ChecksumPage(1, page1) ^ ltx.ChecksumPage(2, data))},
+			}
+			if txID == 1 {
+				spec.Pages = append([]ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: page1}}, spec.Pages...)
+			} else {
+				spec.Header.PreApplyChecksum = ltx.ChecksumFlag | (ltx.ChecksumPage(1, page1) ^ ltx.ChecksumPage(2, bytes.Repeat([]byte{1}, 512)))
+			}
+
+			var buf bytes.Buffer
+			if _, err := spec.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			} else if _, _, err := a.Apply(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			hdr := make([]byte, 100)
+			if _, err := f.ReadAt(hdr, 0); err != nil {
+				t.Fatal(err)
+			} else if got, want := binary.BigEndian.Uint32(hdr[24:]), uint32(7+txID); got != want {
+				t.Fatalf("change counter=%d, want %d", got, want)
+			} else if got, want := binary.BigEndian.Uint32(hdr[92:]), uint32(7); got != want {
+				t.Fatalf("version-valid-for=%d, want %d", got, want)
+			}
+		}
+	})
+
+	// A change counter which is not ahead of the version-valid-for number was
+	// not incremented by the applier so the checksum must match exactly.
+	t.Run("ErrNotIncremented", func(t *testing.T) {
+		a, _ := newTestApplier(t)
+
+		page1 := make([]byte, 512)
+		copy(page1, "SQLite format 3\x00")
+		binary.BigEndian.PutUint32(page1[24:], 5)
+		binary.BigEndian.PutUint32(page1[92:], 7)
+		restored := bytes.Clone(page1)
+		binary.BigEndian.PutUint32(restored[24:], 7)
+
+		var buf bytes.Buffer
+		if _, err := (&ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 1, MinTXID: 1, MaxTXID: 1},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: page1}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | ltx.ChecksumPage(1, page1)},
+		}).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if _, _, err := a.Apply(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		buf.Reset()
+		if _, err := (&ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 1, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | ltx.ChecksumPage(1, restored)},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: restored}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | ltx.ChecksumPage(1, restored)},
+		}).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		} else if _, _, err := a.Apply(&buf); err == nil || !strings.Contains(err.Error(), "pre-apply checksum mismatch") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestApplier_Apply_WALMode(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		a.Locking = true
+		if err := os.WriteFile(ltx.WALPath(f.Name()), nil, 0o666); err != nil {
+			t.Fatal(err)
+		} else if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); !errors.Is(err, ltx.ErrWALMode) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		a, f := newTestApplier(t)
+		a.Locking, a.WALMode = true, ltx.WALModeRemove
+		for _, filename := range []string{ltx.WALPath(f.Name()), ltx.SHMPath(f.Name())} {
+			if err := os.WriteFile(filename, nil, 0o666); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(ltx.WALPath(f.Name())); !os.IsNotExist(err) {
+			t.Fatalf("expected wal to be removed: %v", err)
+		} else if _, err := os.Stat(ltx.SHMPath(f.Name())); !os.IsNotExist(err) {
+			t.Fatalf("expected shm to be removed: %v", err)
+		}
+		assertApplierDB(t, f, bytes.Repeat([]byte{2}, 512))
+	})
+}
+
+func newTestApplier(tb testing.TB) (*ltx.Applier, *os.File) {
+	tb.Helper()
+	f, err := os.OpenFile(filepath.Join(tb.TempDir(), "db"), os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = f.Close() })
+	return ltx.NewApplier(f), f
+}
+
+// assertApplierDB verifies the database contents and that no journal remains.
+func assertApplierDB(tb testing.TB, f *os.File, want []byte) {
+	tb.Helper()
+	if buf, err := os.ReadFile(f.Name()); err != nil {
+		tb.Fatal(err)
+	} else if !bytes.Equal(buf, want) {
+		tb.Fatalf("database mismatch: len=%d, want %d", len(buf), len(want))
+	} else if _, err := os.Stat(ltx.JournalPath(f.Name())); !os.IsNotExist(err) {
+		tb.Fatalf("expected journal to be removed: %v", err)
+	}
+}
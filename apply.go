@@ -0,0 +1,324 @@
+package ltx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ApplyOptions represents options for Apply().
+type ApplyOptions struct {
+	// Database lineage that all applied files must belong to. If zero, the
+	// lineage persisted with the database position is used, if any.
+	DatabaseID DatabaseID
+
+	// If true, files are verified against the database but not applied.
+	DryRun bool
+
+	// If set, applying stops before the first file which ends after the
+	// given transaction ID or which was created after the given time.
+	UntilTXID TXID
+	UntilTime time.Time
+
+	// SQLite locking options. See Applier.
+	Locking     bool
+	BusyTimeout time.Duration
+	WALMode     WALMode
+}
+
+// ApplyResult represents the outcome of Apply().
+type ApplyResult struct {
+	// Position of the database after applying. Zero if the position is unknown.
+	Pos Pos
+
+	// True if a journal left by an interrupted apply was rolled back.
+	Recovered bool
+
+	// Files which were applied, or verified if DryRun is enabled.
+	Applied []string
+
+	// Files which were skipped as they end at or before the database position.
+	Skipped []string
+
+	// Files which were not applied as they are after UntilTXID or UntilTime.
+	Remaining []string
+}
+
+// Apply applies the LTX files in filenames, in order, to the database at
+// dbPath. The database is created if it does not exist, unless DryRun is
+// enabled.
+//
+// The position & database ID of the database are persisted to a file next to
+// the database, see PosPath(), after each file is applied. Files which end at or before the
+// position are skipped so the same files can be passed again without error.
+// A file which starts at or before the position, but ends after it, cannot be
+// applied and returns an error unless it is a snapshot.
+//
+// A crash after a file is committed but before its position is persisted
+// leaves the database ahead of its position. If the database matches the
+// post-apply checksum of the file which follows the position then the file
+// is skipped & the position is persisted instead.
+//
+// If a file fails to apply then the database is rolled back to the last
+// persisted position. The result is still returned with the error and holds
+// the files which were committed before the failure & the persisted position.
+func Apply(ctx context.Context, dbPath string, filenames []string, opt ApplyOptions) (*ApplyResult, error) {
+	var f *os.File
+	var err error
+	if opt.DryRun {
+		if f, err = os.Open(dbPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if f, err = os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, 0o666); err != nil {
+		return nil, err
+	}
+	if f != nil {
+		defer func() { _ = f.Close() }()
+	}
+
+	a := NewApplier(f)
+	a.DatabaseID = opt.DatabaseID
+	a.DryRun = opt.DryRun
+	a.Locking = opt.Locking
+	a.WALMode = opt.WALMode
+	if opt.BusyTimeout != 0 {
+		a.BusyTimeout = opt.BusyTimeout
+	}
+
+	// Roll back any apply interrupted by a crash before reading the position.
+	var result ApplyResult
+	if result.Recovered, err = a.Recover(); err != nil {
+		return &result, err
+	}
+	var databaseID DatabaseID
+	if result.Pos, databaseID, err = readDatabasePos(f, dbPath); err != nil {
+		return &result, err
+	} else if err := CheckDatabaseID(a.DatabaseID, databaseID); err != nil {
+		return &result, err
+	} else if a.DatabaseID.IsZero() {
+		a.DatabaseID = databaseID
+	}
+
+	var verified bool // true once the database is checked against its position
+	var committed int // number of applied files which are committed
+	for i, filename := range filenames {
+		if err := ctx.Err(); err != nil {
+			_ = a.Rollback()
+			result.Applied = result.Applied[:committed]
+			return &result, err
+		}
+
+		hdr, err := readFileHeader(filename)
+		if err != nil {
+			_ = a.Rollback()
+			result.Applied = result.Applied[:committed]
+			return &result, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		// Shard sets share the same header fields so are skipped or stopped
+		// as a whole, unless the set is already partially applied.
+		if a.PendingShard() == nil {
+			if !result.Pos.IsZero() && hdr.MaxTXID <= result.Pos.TXID {
+				result.Skipped = append(result.Skipped, filename)
+				continue
+			} else if isAfterApplyLimit(hdr, opt) {
+				result.Remaining = filenames[i:]
+				break
+			} else if !result.Pos.IsZero() && !hdr.IsSnapshot() && hdr.MinTXID != result.Pos.TXID+1 {
+				return &result, fmt.Errorf("%s: transaction range %s-%s does not follow database position %s", filename, hdr.MinTXID, hdr.MaxTXID, result.Pos)
+			}
+
+			if !verified && !opt.DryRun && !result.Pos.IsZero() && !hdr.IsSnapshot() && !hdr.NoChecksum() {
+				verified = true
+				if pos, ok, err := committedPos(a, hdr, filenames[i:]); err != nil {
+					return &result, fmt.Errorf("%s: %w", filename, err)
+				} else if ok {
+					result.Pos = pos
+					if err := writePosFile(PosPath(dbPath), result.Pos, a.DatabaseID); err != nil {
+						return &result, err
+					}
+					result.Skipped = append(result.Skipped, filename)
+					continue
+				}
+			}
+		}
+
+		_, trailer, err := applyFile(a, filename)
+		if err != nil {
+			_ = a.Rollback()
+			result.Applied = result.Applied[:committed]
+			return &result, fmt.Errorf("%s: %w", filename, err)
+		}
+		result.Applied = append(result.Applied, filename)
+
+		// Persist the position once the database is consistent.
+		if a.PendingShard() != nil {
+			continue
+		}
+		committed = len(result.Applied)
+		result.Pos = Pos{TXID: hdr.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}
+		if !opt.DryRun {
+			if err := writePosFile(PosPath(dbPath), result.Pos, a.DatabaseID); err != nil {
+				return &result, err
+			}
+		}
+	}
+
+	if shard := a.PendingShard(); shard != nil {
+		result.Applied = result.Applied[:committed]
+		if err := a.Rollback(); err != nil {
+			return &result, err
+		}
+		return &result, fmt.Errorf("incomplete shard set: missing shard starting at page %d", shard.ShardMaxPgno+1)
+	}
+
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return &result, err
+		}
+	}
+	return &result, nil
+}
+
+// applyFile applies a single LTX file with the applier.
+func applyFile(a *Applier, filename string) (Header, Trailer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Header{}, Trailer{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return a.Apply(f)
+}
+
+// committedPos returns the position after the file, or shard set, which
+// starts at filenames[0] with header hdr if the database already matches its
+// post-apply checksum.
+func committedPos(a *Applier, hdr Header, filenames []string) (Pos, bool, error) {
+	chksum, orig, err := a.checksum(hdr.PageSize)
+	if err != nil {
+		return Pos{}, false, fmt.Errorf("compute database checksum: %w", err)
+	}
+
+	// The post-apply checksum of a shard set is held by its last shard.
+	for _, filename := range filenames {
+		info, err := readFileInfoFile(filename)
+		if err != nil {
+			return Pos{}, false, err
+		} else if info.MinTXID != hdr.MinTXID || info.MaxTXID != hdr.MaxTXID {
+			break
+		} else if info.IsShard() && info.ShardMaxPgno != info.Commit {
+			continue
+		}
+		return info.Pos(), chksum == info.PostApplyChecksum || orig == info.PostApplyChecksum, nil
+	}
+	return Pos{}, false, nil
+}
+
+// readFileHeader reads & validates the header of an LTX file.
+func readFileHeader(filename string) (Header, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Header{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return Header{}, fmt.Errorf("read ltx header: %w", err)
+	}
+
+	var hdr Header
+	if err := hdr.UnmarshalBinary(buf); err != nil {
+		return Header{}, fmt.Errorf("unmarshal ltx header: %w", err)
+	} else if err := hdr.Validate(); err != nil {
+		return Header{}, err
+	}
+	return hdr, nil
+}
+
+// isAfterApplyLimit returns true if the file is after the UntilTXID or
+// UntilTime options.
+func isAfterApplyLimit(hdr Header, opt ApplyOptions) bool {
+	if opt.UntilTXID != 0 && hdr.MaxTXID > opt.UntilTXID {
+		return true
+	}
+	return !opt.UntilTime.IsZero() && hdr.Timestamp > opt.UntilTime.UnixMilli()
+}
+
+// PosPath returns the path of the file which holds the position of the
+// database at dbPath.
+func PosPath(dbPath string) string { return dbPath + "-pos" }
+
+// readDatabasePos reads the position file for the database at dbPath. The
+// position & database ID are zero if there is no position file or if the
+// database is empty, in which case a position file is stale.
+func readDatabasePos(f *os.File, dbPath string) (Pos, DatabaseID, error) {
+	if f == nil {
+		return Pos{}, DatabaseID{}, nil
+	} else if fi, err := f.Stat(); err != nil {
+		return Pos{}, DatabaseID{}, err
+	} else if fi.Size() == 0 {
+		return Pos{}, DatabaseID{}, nil
+	}
+	return readPosFile(PosPath(dbPath))
+}
+
+// readPosFile reads a position & database ID from a file. Returns a zero
+// position if the file does not exist. The database ID is stored on an
+// optional second line & is zero if it is not set.
+func readPosFile(filename string) (Pos, DatabaseID, error) {
+	buf, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return Pos{}, DatabaseID{}, nil
+	} else if err != nil {
+		return Pos{}, DatabaseID{}, err
+	}
+
+	line, rest, _ := strings.Cut(strings.TrimSpace(string(buf)), "\n")
+	pos, err := ParsePos(strings.TrimSpace(line))
+	if err != nil {
+		return Pos{}, DatabaseID{}, fmt.Errorf("parse position file: %w", err)
+	}
+
+	var id DatabaseID
+	if rest = strings.TrimSpace(rest); rest != "" {
+		if id, err = ParseDatabaseID(rest); err != nil {
+			return Pos{}, DatabaseID{}, fmt.Errorf("parse position file: %w", err)
+		}
+	}
+	return pos, id, nil
+}
+
+// writePosFile atomically replaces a position file. The database ID is only
+// written if it is set.
+func writePosFile(filename string, pos Pos, id DatabaseID) error {
+	s := pos.String() + "\n"
+	if !id.IsZero() {
+		s += id.String() + "\n"
+	}
+
+	w, err := CreateAtomicFile(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(s)); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// removePosFile durably removes a position file, if it exists.
+func removePosFile(filename string) error {
+	if err := os.Remove(filename); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(filename))
+}
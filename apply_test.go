@@ -0,0 +1,249 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestApply(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Pos, (ltx.Pos{TXID: 3, PostApplyChecksum: ltxtest.FileChecksum(3)}); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		} else if got, want := len(result.Applied), 2; got != want {
+			t.Fatalf("len(Applied)=%d, want %d", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 3)
+	})
+
+	// Files at or before the persisted position are skipped.
+	t.Run("SkipApplied", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3}, [2]ltx.TXID{4, 5})
+
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames[:2], ltx.ApplyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Skipped, filenames[:2]; !slices.Equal(got, want) {
+			t.Fatalf("Skipped=%v, want %v", got, want)
+		} else if got, want := result.Applied, filenames[2:]; !slices.Equal(got, want) {
+			t.Fatalf("Applied=%v, want %v", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 5)
+
+		if buf, err := os.ReadFile(ltx.PosPath(dbPath)); err != nil {
+			t.Fatal(err)
+		} else if got, want := strings.TrimSpace(string(buf)), result.Pos.String(); got != want {
+			t.Fatalf("position file=%s, want %s", got, want)
+		}
+	})
+
+	// A file committed before a crash, but whose position was not persisted,
+	// is skipped as the database already matches it.
+	t.Run("CrashBeforePos", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3}, [2]ltx.TXID{4, 5})
+
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames[:2], ltx.ApplyOptions{}); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(ltx.PosPath(dbPath), []byte(ltx.NewPos(2, ltxtest.FileChecksum(2)).String()+"\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Skipped, filenames[:2]; !slices.Equal(got, want) {
+			t.Fatalf("Skipped=%v, want %v", got, want)
+		} else if got, want := result.Applied, filenames[2:]; !slices.Equal(got, want) {
+			t.Fatalf("Applied=%v, want %v", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 5)
+	})
+
+	t.Run("UntilTXID", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3}, [2]ltx.TXID{4, 5})
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{UntilTXID: 4})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Remaining, filenames[2:]; !slices.Equal(got, want) {
+			t.Fatalf("Remaining=%v, want %v", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 3)
+	})
+
+	t.Run("UntilTime", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+
+		// Files created by EncodeFile have a timestamp of MaxTXID seconds.
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{UntilTime: time.Unix(2, 0)})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Remaining, filenames[1:]; !slices.Equal(got, want) {
+			t.Fatalf("Remaining=%v, want %v", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 2)
+	})
+
+	// A dry run verifies the chain of files without modifying the database.
+	t.Run("DryRun", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3}, [2]ltx.TXID{4, 5})
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames[:1], ltx.ApplyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := result.Pos, (ltx.Pos{TXID: 5, PostApplyChecksum: ltxtest.FileChecksum(5)}); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 2)
+	})
+
+	t.Run("DryRunNoDatabase", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{DryRun: true}); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+			t.Fatalf("expected no database: %v", err)
+		}
+	})
+
+	t.Run("ErrDryRunChecksumMismatch", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+		if err := os.WriteFile(dbPath, bytes.Repeat([]byte{9}, 512), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := ltx.Apply(context.Background(), dbPath, filenames[1:], ltx.ApplyOptions{DryRun: true})
+		if err == nil || !strings.Contains(err.Error(), "pre-apply checksum mismatch") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// The database ID is persisted with the position & checked on later applies.
+	t.Run("DatabaseID", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+
+		id := ltx.DatabaseID{1}
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames[:1], ltx.ApplyOptions{DatabaseID: id}); err != nil {
+			t.Fatal(err)
+		} else if buf, err := os.ReadFile(ltx.PosPath(dbPath)); err != nil {
+			t.Fatal(err)
+		} else if got, want := string(buf), ltx.NewPos(2, ltxtest.FileChecksum(2)).String()+"\n"+id.String()+"\n"; got != want {
+			t.Fatalf("position file=%q, want %q", got, want)
+		}
+
+		if _, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{DatabaseID: ltx.DatabaseID{2}}); !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if _, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		assertApplyTestDB(t, dbPath, 3)
+	})
+
+	t.Run("ErrNotContiguous", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{2, 3})
+
+		// The result holds the files committed before the error.
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{})
+		if err == nil || !strings.Contains(err.Error(), "does not follow database position") {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := result.Applied, filenames[:1]; !slices.Equal(got, want) {
+			t.Fatalf("Applied=%v, want %v", got, want)
+		} else if got, want := result.Pos, ltx.NewPos(2, ltxtest.FileChecksum(2)); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 2)
+	})
+
+	// A file which fails to apply is rolled back & is not in the result.
+	t.Run("ErrApplyPartial", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		filenames := writeApplyTestFiles(t, dir, [2]ltx.TXID{1, 2}, [2]ltx.TXID{3, 3})
+
+		buf, err := os.ReadFile(filenames[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf[len(buf)-1] ^= 0xff // corrupt file checksum
+		if err := os.WriteFile(filenames[1], buf, 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := ltx.Apply(context.Background(), dbPath, filenames, ltx.ApplyOptions{})
+		if err == nil {
+			t.Fatal("expected error")
+		} else if got, want := result.Applied, filenames[:1]; !slices.Equal(got, want) {
+			t.Fatalf("Applied=%v, want %v", got, want)
+		} else if got, want := result.Pos, ltx.NewPos(2, ltxtest.FileChecksum(2)); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+		assertApplyTestDB(t, dbPath, 2)
+	})
+}
+
+// writeApplyTestFiles writes LTX files created by EncodeFile for each range
+// & returns their paths.
+func writeApplyTestFiles(tb testing.TB, dir string, ranges ...[2]ltx.TXID) []string {
+	tb.Helper()
+
+	var filenames []string
+	for _, r := range ranges {
+		filename := filepath.Join(dir, ltx.FormatFilename(r[0], r[1]))
+		if err := os.WriteFile(filename, ltxtest.EncodeFile(tb, 0, r[0], r[1]), 0o666); err != nil {
+			tb.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames
+}
+
+func assertApplyTestDB(tb testing.TB, dbPath string, txID ltx.TXID) {
+	tb.Helper()
+	if buf, err := os.ReadFile(dbPath); err != nil {
+		tb.Fatal(err)
+	} else if !bytes.Equal(buf, bytes.Repeat([]byte{byte(txID)}, 512)) {
+		tb.Fatalf("database mismatch, want TXID %s", txID)
+	}
+}
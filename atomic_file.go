@@ -0,0 +1,102 @@
+package ltx
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// AtomicFile represents a file that is written to a temporary file in the
+// same directory and renamed to its final path on Close(). Readers of the
+// final path never observe a partially written file.
+//
+// If the file is passed to an Encoder, calling Encoder.Abort() removes the
+// temporary file.
+type AtomicFile struct {
+	f    *os.File
+	path string
+	done bool
+}
+
+// CreateAtomicFile creates a temporary file next to path that is renamed to
+// path once it is closed. The temporary file is created with mode 0666, before
+// the umask, which is the same as os.Create(). Use Chmod() to change it.
+func CreateAtomicFile(path string) (*AtomicFile, error) {
+	// os.CreateTemp() always uses mode 0600 so the name is chosen here.
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	for i := 0; ; i++ {
+		f, err := os.OpenFile(prefix+strconv.FormatUint(uint64(rand.Uint32()), 10), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &AtomicFile{f: f, path: path}, nil
+	}
+}
+
+// Name returns the final path of the file.
+func (f *AtomicFile) Name() string { return f.path }
+
+// TempName returns the path of the temporary file being written.
+func (f *AtomicFile) TempName() string { return f.f.Name() }
+
+// Write writes p to the temporary file.
+func (f *AtomicFile) Write(p []byte) (int, error) {
+	if f.done {
+		return 0, os.ErrClosed
+	}
+	return f.f.Write(p)
+}
+
+// Chmod changes the mode of the temporary file. The mode is retained after
+// the file is renamed.
+func (f *AtomicFile) Chmod(mode os.FileMode) error {
+	if f.done {
+		return os.ErrClosed
+	}
+	return f.f.Chmod(mode)
+}
+
+// Close syncs the temporary file and atomically renames it to its final path.
+// If any step fails, the temporary file is removed.
+func (f *AtomicFile) Close() (retErr error) {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(f.f.Name())
+		}
+	}()
+
+	if err := f.f.Sync(); err != nil {
+		_ = f.f.Close()
+		return fmt.Errorf("sync: %w", err)
+	} else if err := f.f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	} else if err := os.Rename(f.f.Name(), f.path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// Abort closes and removes the temporary file. The final path is left
+// untouched. Abort is a no-op if the file has already been closed or aborted.
+func (f *AtomicFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	err := f.f.Close()
+	if e := os.Remove(f.f.Name()); e != nil && !errors.Is(e, os.ErrNotExist) && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package ltx_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestAtomicFile(t *testing.T) {
+	t.Run("Close", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.ltx")
+		if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := ltx.CreateAtomicFile(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Final path is unchanged until close.
+		if buf, err := os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		} else if got, want := string(buf), "old"; got != want {
+			t.Fatalf("data=%q, want %q", got, want)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		} else if buf, err := os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		} else if got, want := string(buf), "new"; got != want {
+			t.Fatalf("data=%q, want %q", got, want)
+		} else if _, err := os.Stat(f.TempName()); !os.IsNotExist(err) {
+			t.Fatalf("expected temporary file removed: %v", err)
+		}
+
+		// Abort after close is a no-op.
+		if err := f.Abort(); err != nil {
+			t.Fatal(err)
+		} else if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		dir := t.TempDir()
+		f, err := ltx.CreateAtomicFile(filepath.Join(dir, "out.ltx"))
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Write([]byte("partial")); err != nil {
+			t.Fatal(err)
+		} else if err := f.Abort(); err != nil {
+			t.Fatal(err)
+		}
+
+		if ents, err := os.ReadDir(dir); err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 0 {
+			t.Fatalf("unexpected files: %v", ents)
+		}
+
+		if _, err := f.Write([]byte("x")); err != os.ErrClosed {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// The file has the same mode as one created by os.Create().
+	t.Run("Mode", func(t *testing.T) {
+		dir := t.TempDir()
+		other, err := os.Create(filepath.Join(dir, "other"))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := other.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "out.ltx")
+		f, err := ltx.CreateAtomicFile(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if otherFi, err := os.Stat(other.Name()); err != nil {
+			t.Fatal(err)
+		} else if got, want := fi.Mode().Perm(), otherFi.Mode().Perm(); got != want {
+			t.Fatalf("mode=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("Chmod", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.ltx")
+		f, err := ltx.CreateAtomicFile(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := f.Chmod(0o640); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if got, want := fi.Mode().Perm(), os.FileMode(0o640); got != want {
+			t.Fatalf("mode=%s, want %s", got, want)
+		}
+	})
+}
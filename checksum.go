@@ -80,7 +80,8 @@ func checksumPagesSerial(dbPath string, firstPage, lastPage uint32, pageSize int
 	}
 	defer func() { _ = f.Close() }()
 
-	_, err = f.Seek(int64(firstPage-1)*pageSize, io.SeekStart)
+	// Holes in sparse files are not read.
+	r, err := NewPageReader(f, uint32(pageSize))
 	if err != nil {
 		return firstPage - 1, err
 	}
@@ -92,7 +93,7 @@ func checksumPagesSerial(dbPath string, firstPage, lastPage uint32, pageSize int
 	for pageNo := firstPage; pageNo <= lastPage; pageNo++ {
 		binary.BigEndian.PutUint32(buf, pageNo)
 
-		if _, err := io.ReadFull(f, buf[4:]); err != nil {
+		if err := r.ReadPage(pageNo, buf[4:]); err != nil {
 			return pageNo - 1, err
 		}
 		if pageNo == lockPgno {
@@ -110,7 +111,10 @@ func checksumPagesSerial(dbPath string, firstPage, lastPage uint32, pageSize int
 
 // ChecksumPage returns a CRC64 checksum that combines the page number & page data.
 func ChecksumPage(pgno uint32, data []byte) Checksum {
-	return ChecksumPageWithHasher(NewHasher(), pgno, data)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], pgno)
+	crc := crc64.Update(0, crc64ISOTable, b[:])
+	return ChecksumFlag | Checksum(crc64.Update(crc, crc64ISOTable, data))
 }
 
 // ChecksumPageWithHasher returns a CRC64 checksum that combines the page number & page data.
@@ -184,5 +188,7 @@ func (c *Checksum) UnmarshalJSON(data []byte) (err error) {
 
 // NewHasher returns a new CRC64-ISO hasher.
 func NewHasher() hash.Hash64 {
-	return crc64.New(crc64.MakeTable(crc64.ISO))
+	return crc64.New(crc64ISOTable)
 }
+
+var crc64ISOTable = crc64.MakeTable(crc64.ISO)
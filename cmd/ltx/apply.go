@@ -4,8 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io"
-	"os"
+	"time"
 
 	"github.com/superfly/ltx"
 )
@@ -22,9 +21,36 @@ func NewApplyCommand() *ApplyCommand {
 func (c *ApplyCommand) Run(ctx context.Context, args []string) (ret error) {
 	fs := flag.NewFlagSet("ltx-apply", flag.ContinueOnError)
 	dbPath := fs.String("db", "", "database path")
+	databaseID := fs.String("database-id", "", "require LTX files to belong to database id")
+	locking := fs.Bool("locking", ltx.LockingSupported, "hold SQLite-compatible locks while applying; unsupported on non-unix platforms")
+	busyTimeout := fs.Duration("busy-timeout", ltx.DefaultBusyTimeout, "time to wait for SQLite connections to release their locks")
+	walMode := fs.String("wal", ltx.WALModeError.String(), "handling of a database in WAL mode: error or remove")
+	dryRun := fs.Bool("dry-run", false, "verify files against the database without applying")
+	untilTXID := fs.String("until-txid", "", "stop before files ending after TXID")
+	untilTime := fs.String("until-time", "", "stop before files created after an RFC 3339 timestamp")
 	fs.Usage = func() {
 		fmt.Println(`
-The apply command applies one or more LTX files to a database file.
+The apply command applies one or more LTX files to a database file. The
+original pages are written to a rollback journal before the database is
+modified so the database is restored if a file fails to apply. Each file, or
+shard set, is applied atomically.
+
+The database position is stored in a "-pos" file next to the database. Files
+which end at or before the position are skipped, so a directory of files can
+be applied repeatedly, and other files must continue from the position. The
+database ID of the applied files is stored with the position & files with
+another database ID are rejected.
+
+SQLite-compatible locks are held while the database is modified so SQLite
+connections with the database open never read a partially applied file. The
+file change counter is incremented after each file so these connections
+discard their page cache. Locks are only supported on unix platforms so
+locking is disabled by default elsewhere, in which case the database must not
+be open while applying.
+
+A database with a -wal file is in WAL mode and may contain transactions which
+are not in the database file. When locking, applying fails unless "-wal
+remove" is passed, which removes the -wal & -shm files before applying.
 
 Usage:
 
@@ -45,90 +71,57 @@ Arguments:
 		return fmt.Errorf("required: -db PATH")
 	}
 
-	// Open database file. Create if it doesn't exist.
-	dbFile, err := os.OpenFile(*dbPath, os.O_RDWR|os.O_CREATE, 0o666)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = dbFile.Close() }()
-
-	// Apply LTX files in order.
-	for _, filename := range fs.Args() {
-		if err := c.applyLTXFile(ctx, dbFile, filename); err != nil {
-			return fmt.Errorf("%s: %s", filename, err)
+	var id ltx.DatabaseID
+	if *databaseID != "" {
+		var err error
+		if id, err = ltx.ParseDatabaseID(*databaseID); err != nil {
+			return err
 		}
 	}
-
-	// Sync and close resulting database file.
-	if err := dbFile.Sync(); err != nil {
-		return err
-	}
-	return dbFile.Close()
-}
-
-func (c *ApplyCommand) applyLTXFile(_ context.Context, dbFile *os.File, filename string) error {
-	ltxFile, err := os.Open(filename)
+	mode, err := ltx.ParseWALMode(*walMode)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = ltxFile.Close() }()
 
-	// Read LTX header and verify initial checksum matches.
-	dec := ltx.NewDecoder(ltxFile)
-	if err := dec.DecodeHeader(); err != nil {
-		return fmt.Errorf("decode ltx header: %w", err)
+	opt := ltx.ApplyOptions{
+		DatabaseID:  id,
+		DryRun:      *dryRun,
+		Locking:     *locking,
+		BusyTimeout: *busyTimeout,
+		WALMode:     mode,
 	}
-	hdr := dec.Header()
-
-	// Read checksum before applying.
-	if !hdr.IsSnapshot() && !hdr.NoChecksum() {
-		if _, err := dbFile.Seek(0, io.SeekStart); err != nil {
+	if *untilTXID != "" {
+		if opt.UntilTXID, err = ltx.ParseTXID(*untilTXID); err != nil {
 			return err
 		}
-		preApplyChecksum, err := ltx.ChecksumReader(dbFile, int(hdr.PageSize))
-		if err != nil {
-			return fmt.Errorf("compute pre-apply checksum: %w", err)
-		} else if preApplyChecksum != hdr.PreApplyChecksum {
-			return fmt.Errorf("pre-apply checksum mismatch: %s <> %s", preApplyChecksum, hdr.PreApplyChecksum)
-		}
 	}
-
-	// Apply each page to the database.
-	data := make([]byte, hdr.PageSize)
-	for {
-		var pageHeader ltx.PageHeader
-		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
-			break
-		} else if err != nil {
-			return fmt.Errorf("decode ltx page: %w", err)
+	if *untilTime != "" {
+		if opt.UntilTime, err = time.Parse(time.RFC3339Nano, *untilTime); err != nil {
+			return fmt.Errorf("invalid -until-time: %w", err)
 		}
+	}
 
-		offset := int64(pageHeader.Pgno-1) * int64(hdr.PageSize)
-		if _, err := dbFile.WriteAt(data, offset); err != nil {
-			return fmt.Errorf("write database page: %w", err)
+	result, err := ltx.Apply(ctx, *dbPath, fs.Args(), opt)
+	if result == nil {
+		return err
+	} else if err != nil {
+		if n := len(result.Applied); n > 0 && !*dryRun {
+			fmt.Printf("applied %d files before failing, database at %s\n", n, result.Pos)
 		}
+		return err
 	}
 
-	// Close & verify file, print trailer.
-	if err := dec.Close(); err != nil {
-		return fmt.Errorf("close ltx file: %w", err)
+	if result.Recovered {
+		fmt.Printf("rolled back interrupted apply from %s\n", ltx.JournalPath(*dbPath))
 	}
-	if err := dbFile.Truncate(int64(hdr.Commit) * int64(hdr.PageSize)); err != nil {
-		return fmt.Errorf("truncate database: %w", err)
+	for _, filename := range result.Skipped {
+		fmt.Printf("skipped %s: already applied\n", filename)
 	}
-
-	// Recalculate database checksum and ensure it matches the LTX checksum.
-	if !hdr.NoChecksum() {
-		if _, err := dbFile.Seek(0, io.SeekStart); err != nil {
-			return err
-		}
-		postApplyChecksum, err := ltx.ChecksumReader(dbFile, int(hdr.PageSize))
-		if err != nil {
-			return fmt.Errorf("compute post-apply checksum: %w", err)
-		} else if postApplyChecksum != dec.Trailer().PostApplyChecksum {
-			return fmt.Errorf("post-apply checksum mismatch: %s <> %s", postApplyChecksum, dec.Trailer().PostApplyChecksum)
-		}
+	if len(result.Remaining) > 0 {
+		fmt.Printf("stopped before %s\n", result.Remaining[0])
+	}
+	if *dryRun {
+		fmt.Printf("dry run: %d files verified, database would be at %s\n", len(result.Applied), result.Pos)
 	}
-
 	return nil
 }
@@ -3,6 +3,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -111,6 +115,34 @@ func TestApplyCommand_SnapshotOverExistingDatabase(t *testing.T) {
 	}
 }
 
+func TestApplyCommand_ErrDatabaseIDMismatch(t *testing.T) {
+	const pageSize = 512
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db")
+	ltxPath := filepath.Join(dir, "snapshot.ltx")
+	data := bytes.Repeat([]byte{0x9a}, pageSize)
+	writeApplyTestLTX(t, ltxPath, &ltx.FileSpec{
+		Header: ltx.Header{
+			Version:    ltx.Version,
+			PageSize:   pageSize,
+			Commit:     1,
+			MinTXID:    1,
+			MaxTXID:    1,
+			DatabaseID: ltx.DatabaseID{1},
+		},
+		Pages: []ltx.PageSpec{
+			{Header: ltx.PageHeader{Pgno: 1}, Data: data},
+		},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumPage(1, data)},
+	})
+
+	err := NewApplyCommand().Run(context.Background(), []string{"-db", dbPath, "-database-id", ltx.DatabaseID{2}.String(), ltxPath})
+	if !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func writeApplyTestLTX(t *testing.T, path string, spec *ltx.FileSpec) {
 	t.Helper()
 
@@ -126,3 +158,110 @@ func writeApplyTestLTX(t *testing.T, path string, spec *ltx.FileSpec) {
 		t.Fatal(err)
 	}
 }
+
+func TestApplyCommand_Shards(t *testing.T) {
+	const pageSize, commit = 512, 6
+
+	dir := t.TempDir()
+
+	// Build a snapshot and split it into three shards using the compactor.
+	var want []byte
+	spec := &ltx.FileSpec{
+		Header: ltx.Header{Version: ltx.Version, PageSize: pageSize, Commit: commit, MinTXID: 1, MaxTXID: 1},
+	}
+	postApplyChecksum := ltx.ChecksumFlag
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		data := make([]byte, pageSize)
+		_, _ = rand.Read(data)
+		want = append(want, data...)
+		spec.Pages = append(spec.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: pgno}, Data: data})
+		postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, data))
+	}
+	spec.Trailer.PostApplyChecksum = postApplyChecksum
+
+	var input bytes.Buffer
+	if _, err := spec.WriteTo(&input); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	nextWriter := func() (io.Writer, error) {
+		path := filepath.Join(dir, fmt.Sprintf("%d.ltx", len(paths)))
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { _ = f.Close() })
+		paths = append(paths, path)
+		return f, nil
+	}
+
+	w, _ := nextWriter()
+	c, err := ltx.NewCompactor(w, []io.Reader{&input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxOutputSize = 1251 // two uncompressed pages
+	c.NextWriter = nextWriter
+	if err := c.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(paths), 3; got != want {
+		t.Fatalf("len(paths)=%d, want %d", got, want)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "db")
+		if err := NewApplyCommand().Run(context.Background(), append([]string{"-db", dbPath}, paths...)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dbPath); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want) {
+			t.Fatal("database mismatch")
+		}
+	})
+
+	t.Run("ErrOutOfOrder", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "db")
+		err := NewApplyCommand().Run(context.Background(), []string{"-db", dbPath, paths[1]})
+		if err == nil || err.Error() != paths[1]+`: shard applied out of order: starts at page 3` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrIncomplete", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "db")
+		err := NewApplyCommand().Run(context.Background(), []string{"-db", dbPath, paths[0], paths[1]})
+		if err == nil || err.Error() != `incomplete shard set: missing shard starting at page 5` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestApplyCommand_DryRun(t *testing.T) {
+	const pageSize = 512
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db")
+	ltxPath := filepath.Join(dir, "snapshot.ltx")
+	data := bytes.Repeat([]byte{0x12}, pageSize)
+	writeApplyTestLTX(t, ltxPath, &ltx.FileSpec{
+		Header: ltx.Header{
+			Version:  ltx.Version,
+			PageSize: pageSize,
+			Commit:   1,
+			MinTXID:  1,
+			MaxTXID:  1,
+		},
+		Pages: []ltx.PageSpec{
+			{Header: ltx.PageHeader{Pgno: 1}, Data: data},
+		},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumPage(1, data)},
+	})
+
+	if err := NewApplyCommand().Run(context.Background(), []string{"-db", dbPath, "-dry-run", ltxPath}); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no database: %v", err)
+	}
+}
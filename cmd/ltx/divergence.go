@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/superfly/ltx"
+)
+
+// DivergenceCommand represents a command to find where two sets of LTX files diverge.
+type DivergenceCommand struct{}
+
+// NewDivergenceCommand returns a new instance of DivergenceCommand.
+func NewDivergenceCommand() *DivergenceCommand {
+	return &DivergenceCommand{}
+}
+
+// Run executes the command.
+func (c *DivergenceCommand) Run(ctx context.Context, args []string) (ret error) {
+	fs := flag.NewFlagSet("ltx-divergence", flag.ContinueOnError)
+	rewindPath := fs.String("rewind", "", "write snapshot at common ancestor to path")
+	fs.Usage = func() {
+		fmt.Println(`
+The divergence command compares the level-zero LTX files in the directories
+of two replicas and reports the first transaction where their positions differ.
+
+Usage:
+
+	ltx divergence [arguments] DIR DIR
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 2 {
+		return fmt.Errorf("two directories required")
+	}
+
+	dirA, dirB := fs.Arg(0), fs.Arg(1)
+	infosA, pathsA, err := c.readDir(dirA)
+	if err != nil {
+		return err
+	}
+	infosB, _, err := c.readDir(dirB)
+	if err != nil {
+		return err
+	}
+
+	d, err := ltx.FindDivergence(ltx.NewFileInfoSliceIterator(infosA), ltx.NewFileInfoSliceIterator(infosB))
+	if err != nil {
+		return err
+	} else if d == nil {
+		fmt.Println("no divergence")
+		return nil
+	}
+
+	fmt.Printf("Ancestor: %s\n", d.Ancestor)
+	fmt.Printf("TXID:     %s\n", d.TXID)
+	c.printFile(dirA, d.A)
+	c.printFile(dirB, d.B)
+
+	if *rewindPath != "" {
+		if err := c.writeRewind(ctx, *rewindPath, d, infosA, pathsA); err != nil {
+			return fmt.Errorf("rewind: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *DivergenceCommand) printFile(dir string, info *ltx.FileInfo) {
+	if info == nil {
+		fmt.Printf("%s: no file\n", dir)
+		return
+	}
+	fmt.Printf("%s: %s node=%016x\n", dir, ltx.FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), info.NodeID)
+}
+
+// writeRewind compacts the files leading up to the ancestor into a snapshot.
+func (c *DivergenceCommand) writeRewind(ctx context.Context, path string, d *ltx.Divergence, infos []*ltx.FileInfo, paths map[*ltx.FileInfo]string) error {
+	// Only use the history of the latest timeline, which FindDivergence()
+	// compared against the other directory.
+	latest := ltx.LatestFileInfo(infos)
+	if latest == nil {
+		return fmt.Errorf("no files")
+	}
+	chain, err := ltx.TimelineChain(infos, latest.Timeline)
+	if err != nil {
+		return err
+	}
+
+	// Start from the latest snapshot before the ancestor.
+	var a []*ltx.FileInfo
+	for _, info := range chain {
+		if info.MaxTXID > d.Ancestor.TXID {
+			continue
+		} else if info.MinTXID == 1 {
+			a = a[:0]
+		}
+		a = append(a, info)
+	}
+
+	rdrs := make([]io.Reader, 0, len(a))
+	files := make([]*os.File, 0, len(a))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, info := range a {
+		f, err := os.Open(paths[info])
+		if err != nil {
+			return err
+		}
+		rdrs, files = append(rdrs, f), append(files, f)
+	}
+
+	// Write to a temporary file so a failed rewind never leaves a partial
+	// snapshot at path.
+	f, err := ltx.CreateAtomicFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Abort() }()
+
+	if err := d.WriteRewind(ctx, f, rdrs); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readDir returns metadata & paths for all level-zero LTX files in dir, sorted by TXID.
+func (c *DivergenceCommand) readDir(dir string) ([]*ltx.FileInfo, map[*ltx.FileInfo]string, error) {
+	itr := ltx.NewDirFileIterator(dir)
+	itr.Level = 0
+	defer func() { _ = itr.Close() }()
+
+	var infos []*ltx.FileInfo
+	paths := make(map[*ltx.FileInfo]string)
+	for itr.Next() {
+		infos = append(infos, itr.Item())
+		paths[itr.Item()] = itr.Path()
+	}
+	if err := itr.Err(); err != nil {
+		return nil, nil, err
+	}
+	return infos, paths, nil
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/superfly/ltx"
 	"github.com/superfly/ltx/internal"
+	"github.com/superfly/ltx/internal/sqlitepage"
 )
 
 // DumpCommand represents a command to print the contents of a single LTX file.
@@ -23,10 +27,16 @@ func NewDumpCommand() *DumpCommand {
 // Run executes the command.
 func (c *DumpCommand) Run(ctx context.Context, args []string) (ret error) {
 	fs := flag.NewFlagSet("ltx-dump", flag.ContinueOnError)
+	pages := fs.Bool("pages", false, "decode the SQLite structure of each page")
+	hexdump := fs.Bool("hexdump", true, "print a hexdump of each page")
 	fs.Usage = func() {
 		fmt.Println(`
 The dump command writes out all data for a single LTX file.
 
+With -pages, each page is decoded as a SQLite database page. The freelist &
+overflow page chains are followed to identify pages which have no header, so
+pages referenced by pages outside of the file are reported as unknown.
+
 Usage:
 
 	ltx dump [arguments] PATH
@@ -50,6 +60,17 @@ Arguments:
 	}
 	defer func() { _ = f.Close() }()
 
+	// Identify page types before printing as pages may be referenced by
+	// pages later in the file.
+	var types map[uint32]sqlitepage.Type
+	var typesErr error
+	if *pages {
+		types, typesErr = c.classifyPages(f)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
 	dec := ltx.NewDecoder(f)
 
 	// Read & print header information.
@@ -67,12 +88,21 @@ Arguments:
 	fmt.Printf("WAL offset: %d\n", hdr.WALOffset)
 	fmt.Printf("WAL size:   %d\n", hdr.WALSize)
 	fmt.Printf("WAL salt:   %08x %08x\n", hdr.WALSalt1, hdr.WALSalt2)
+	fmt.Printf("Database ID: %s\n", hdr.DatabaseID)
+	fmt.Printf("Timeline:    %d\n", hdr.Timeline)
+	if hdr.IsShard() {
+		fmt.Printf("Shard:       pages %d-%d\n", hdr.ShardMinPgno, hdr.ShardMaxPgno)
+	}
 	fmt.Printf("\n")
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("# PAGE DATA\n")
+	if typesErr != nil {
+		fmt.Printf("Cannot identify page types: %s\n\n", typesErr)
+	}
+	usableSize := hdr.PageSize
 	for i := 0; ; i++ {
 		var pageHeader ltx.PageHeader
 		data := make([]byte, hdr.PageSize)
@@ -83,7 +113,19 @@ Arguments:
 		}
 
 		fmt.Printf("Frame #%d: pgno=%d\n", i, pageHeader.Pgno)
-		fmt.Print(internal.Hexdump(data))
+		if *pages {
+			// Page 1 is the first page of a snapshot so the usable size is
+			// known before any other page is decoded.
+			if pageHeader.Pgno == 1 {
+				if dbHdr, err := sqlitepage.ParseDatabaseHeader(data); err == nil {
+					usableSize = dbHdr.UsableSize()
+				}
+			}
+			c.printPage(pageHeader, data, usableSize, types)
+		}
+		if *hexdump {
+			fmt.Print(internal.Hexdump(data))
+		}
 		fmt.Println()
 	}
 	fmt.Printf("\n")
@@ -102,3 +144,126 @@ Arguments:
 
 	return nil
 }
+
+// classifyPages returns the SQLite page type of each page in the file. Pages
+// are read randomly using the page index so the file is decoded in full first.
+func (c *DumpCommand) classifyPages(f *os.File) (map[uint32]sqlitepage.Type, error) {
+	dec := ltx.NewDecoder(f)
+	if err := dec.Verify(); err != nil {
+		return nil, err
+	}
+	index := dec.PageIndex()
+
+	var frame []byte
+	return sqlitepage.Classify(dec.Header().PageSize, slices.Sorted(maps.Keys(index)), func(pgno uint32, data []byte) error {
+		elem := index[pgno]
+		frame = slices.Grow(frame[:0], int(elem.Size))[:elem.Size]
+		if _, err := f.ReadAt(frame, elem.Offset); err != nil {
+			return err
+		}
+
+		hdr, buf, err := ltx.DecodePageDataInto(frame, data)
+		if err != nil {
+			return err
+		} else if !hdr.HasData() {
+			clear(data)
+		} else {
+			copy(data, buf) // no-op unless the old frame format allocated a buffer
+		}
+		return nil
+	})
+}
+
+// printPage prints the SQLite structure of a page. The page type is taken from
+// types, if available, or otherwise from the b-tree page type flag.
+func (c *DumpCommand) printPage(hdr ltx.PageHeader, data []byte, usableSize uint32, types map[uint32]sqlitepage.Type) {
+	typ, ok := types[hdr.Pgno]
+	if hdr.IsFree() {
+		typ = sqlitepage.TypeFreelistLeaf
+	} else if !ok {
+		if page, _ := sqlitepage.ParseBTreePage(hdr.Pgno, data, usableSize); page != nil {
+			typ = page.Type
+		}
+	}
+
+	fmt.Printf("Flags: 0x%04x\n", hdr.Flags)
+	fmt.Printf("Type:  %s\n", typ)
+
+	if hdr.Pgno == 1 {
+		if dbHdr, err := sqlitepage.ParseDatabaseHeader(data); err != nil {
+			fmt.Printf("Database header: %s\n", err)
+		} else {
+			fmt.Printf("Database header:\n")
+			fmt.Printf("  Page size:          %d\n", dbHdr.PageSize)
+			fmt.Printf("  File format:        write=%d read=%d\n", dbHdr.WriteVersion, dbHdr.ReadVersion)
+			fmt.Printf("  Reserved size:      %d\n", dbHdr.ReservedSize)
+			fmt.Printf("  Payload fractions:  max=%d min=%d leaf=%d\n", dbHdr.MaxPayloadFrac, dbHdr.MinPayloadFrac, dbHdr.LeafPayloadFrac)
+			fmt.Printf("  Change counter:     %d\n", dbHdr.ChangeCounter)
+			fmt.Printf("  Page count:         %d\n", dbHdr.PageN)
+			fmt.Printf("  Freelist trunk:     %d\n", dbHdr.FreelistTrunk)
+			fmt.Printf("  Freelist count:     %d\n", dbHdr.FreelistN)
+			fmt.Printf("  Schema cookie:      %d\n", dbHdr.SchemaCookie)
+			fmt.Printf("  Schema format:      %d\n", dbHdr.SchemaFormat)
+			fmt.Printf("  Default cache size: %d\n", dbHdr.DefaultCacheSize)
+			fmt.Printf("  Largest root page:  %d\n", dbHdr.LargestRootPage)
+			fmt.Printf("  Text encoding:      %d (%s)\n", dbHdr.TextEncoding, dbHdr.TextEncodingName())
+			fmt.Printf("  User version:       %d\n", dbHdr.UserVersion)
+			fmt.Printf("  Incremental vacuum: %d\n", dbHdr.IncrementalVacuum)
+			fmt.Printf("  Application ID:     %d\n", dbHdr.ApplicationID)
+			fmt.Printf("  Version valid for:  %d\n", dbHdr.VersionValidFor)
+			fmt.Printf("  SQLite version:     %d\n", dbHdr.SQLiteVersion)
+		}
+	}
+
+	switch {
+	case typ.IsBTree():
+		page, err := sqlitepage.ParseBTreePage(hdr.Pgno, data, usableSize)
+		if page != nil {
+			fmt.Printf("First freeblock:    %d\n", page.FirstFreeblock)
+			fmt.Printf("Cell count:         %d\n", page.CellN)
+			fmt.Printf("Cell content start: %d\n", page.CellContentStart)
+			fmt.Printf("Fragmented bytes:   %d\n", page.FragmentedBytes)
+			if typ.IsInterior() {
+				fmt.Printf("Right child:        %d\n", page.RightChild)
+			}
+			for _, fb := range page.Freeblocks {
+				fmt.Printf("Freeblock: offset=%d size=%d\n", fb.Offset, fb.Size)
+			}
+			for i, cell := range page.Cells {
+				c.printCell(typ, i, cell)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+		}
+
+	case typ == sqlitepage.TypeOverflow:
+		fmt.Printf("Next overflow page: %d\n", binary.BigEndian.Uint32(data))
+
+	case typ == sqlitepage.TypeFreelistTrunk:
+		if trunk, err := sqlitepage.ParseFreelistTrunk(data, usableSize); err != nil {
+			fmt.Printf("Error: %s\n", err)
+		} else {
+			fmt.Printf("Next trunk page: %d\n", trunk.Next)
+			fmt.Printf("Leaf pages:      %v\n", trunk.Leaves)
+		}
+	}
+}
+
+// printCell prints the fields of a b-tree cell which apply to the page type.
+func (c *DumpCommand) printCell(typ sqlitepage.Type, i int, cell sqlitepage.Cell) {
+	fmt.Printf("Cell #%d: offset=%d", i, cell.Offset)
+	if typ.IsInterior() {
+		fmt.Printf(" left=%d", cell.LeftChild)
+	}
+	if typ == sqlitepage.TypeTableInterior || typ == sqlitepage.TypeTableLeaf {
+		fmt.Printf(" rowid=%d", cell.Rowid)
+	}
+	if typ != sqlitepage.TypeTableInterior {
+		fmt.Printf(" payload=%d local=%d", cell.PayloadSize, cell.LocalSize)
+	}
+	if cell.OverflowPgno != 0 {
+		fmt.Printf(" overflow=%d", cell.OverflowPgno)
+	}
+	fmt.Println()
+}
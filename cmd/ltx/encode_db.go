@@ -1,23 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/superfly/ltx"
-)
-
-const (
-	SQLITE_DATABASE_HEADER_STRING = "SQLite format 3\x00"
-	SQLITE_DATABASE_HEADER_SIZE   = 100
+	"github.com/superfly/ltx/internal/sqlitepage"
 )
 
 // EncodeDBCommand represents a command to encode an SQLite database file as a single LTX file.
@@ -29,9 +23,12 @@ func NewEncodeDBCommand() *EncodeDBCommand {
 }
 
 // Run executes the command.
-func (c *EncodeDBCommand) Run(ctx context.Context, args []string) (ret error) {
+func (c *EncodeDBCommand) Run(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("ltx-encode-db", flag.ContinueOnError)
 	outPath := fs.String("o", "", "output path")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines used to compress pages")
+	omitFreePages := fs.Bool("omit-free-pages", false, "encode freelist leaf pages without their contents")
+	databaseID := fs.String("database-id", "", "database id to write to the file, generated if unset")
 	fs.Usage = func() {
 		fmt.Println(`
 The encode-db command encodes an SQLite database into an LTX file.
@@ -40,6 +37,17 @@ Usage:
 
 	ltx encode-db [arguments] PATH
 
+The file is written with the database ID passed by -database-id, or with a
+newly generated ID, so that later files can be checked against the snapshot.
+
+With -omit-free-pages, pages on the SQLite freelist are encoded without their
+contents & are zeroed when the file is applied. The post-apply checksum is
+computed with those pages zeroed so it only matches the original database if
+its free pages are already zeroed, such as with secure_delete enabled.
+Otherwise, the snapshot is terminal: later LTX files from the original database
+cannot be applied to the restored database, which must be restored from a new
+snapshot to continue.
+
 Arguments:
 `[1:])
 		fs.PrintDefaults()
@@ -55,6 +63,16 @@ Arguments:
 		return fmt.Errorf("required: -o PATH")
 	}
 
+	var id ltx.DatabaseID
+	var err error
+	if *databaseID != "" {
+		if id, err = ltx.ParseDatabaseID(*databaseID); err != nil {
+			return err
+		}
+	} else if id, err = ltx.NewDatabaseID(); err != nil {
+		return fmt.Errorf("generate database id: %w", err)
+	}
+
 	db, err := os.Open(fs.Arg(0))
 	if err != nil {
 		return fmt.Errorf("open DB file: %w", err)
@@ -65,65 +83,89 @@ Arguments:
 	if err != nil {
 		return fmt.Errorf("stat DB file: %w", err)
 	}
-	var outMode os.FileMode
-	var preserveOutMode bool
+	// New output files are only accessible by the owner & existing output
+	// files keep their mode.
+	outMode := os.FileMode(0o600)
 	if outInfo, err := os.Stat(*outPath); err == nil {
 		if os.SameFile(dbInfo, outInfo) {
 			return fmt.Errorf("input and output files are the same")
 		}
 		outMode = outInfo.Mode().Perm()
-		preserveOutMode = true
 	} else if !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("stat output file: %w", err)
 	}
 
-	rd, hdr, err := c.readSQLiteDatabaseHeader(db)
+	hdr, err := c.readSQLiteDatabaseHeader(db)
 	if err != nil {
 		return fmt.Errorf("read database header: %w", err)
 	}
 
-	out, err := os.CreateTemp(filepath.Dir(*outPath), "."+filepath.Base(*outPath)+".tmp-*")
+	// Holes in sparse databases are not read.
+	rd, err := ltx.NewPageReader(db, hdr.PageSize)
 	if err != nil {
-		return fmt.Errorf("create temporary output file: %w", err)
+		return fmt.Errorf("stat DB file: %w", err)
 	}
-	tmpPath := out.Name()
-	defer func() {
-		if out != nil {
-			_ = out.Close()
-		}
-		if tmpPath != "" {
-			_ = os.Remove(tmpPath)
-		}
-	}()
-	if preserveOutMode {
-		if err := out.Chmod(outMode); err != nil {
-			return fmt.Errorf("chmod temporary output file: %w", err)
+
+	var free map[uint32]struct{}
+	if *omitFreePages {
+		if free, err = ltx.ReadFreelist(hdr.PageSize, hdr.PageN, rd.ReadPage); err != nil {
+			return fmt.Errorf("read freelist: %w", err)
 		}
 	}
 
+	out, err := ltx.CreateAtomicFile(*outPath)
+	if err != nil {
+		return fmt.Errorf("create temporary output file: %w", err)
+	}
+	defer func() { _ = out.Abort() }()
+
+	if err := out.Chmod(outMode); err != nil {
+		return fmt.Errorf("chmod temporary output file: %w", err)
+	}
+
 	var postApplyChecksum ltx.Checksum
 	enc, err := ltx.NewEncoder(out)
 	if err != nil {
 		return fmt.Errorf("create ltx encoder: %w", err)
 	}
+	defer func() { _ = enc.Abort() }()
+
+	var flags uint32
+	if *omitFreePages {
+		flags |= ltx.HeaderFlagFreePages
+	}
+
+	enc.CompressionWorkers = *workers
 	if err := enc.EncodeHeader(ltx.Header{
-		Version:   ltx.Version,
-		PageSize:  hdr.pageSize,
-		Commit:    hdr.pageN,
-		MinTXID:   ltx.TXID(1),
-		MaxTXID:   ltx.TXID(1),
-		Timestamp: time.Now().UnixMilli(),
+		Version:    ltx.Version,
+		Flags:      flags,
+		PageSize:   hdr.PageSize,
+		Commit:     hdr.PageN,
+		MinTXID:    ltx.TXID(1),
+		MaxTXID:    ltx.TXID(1),
+		Timestamp:  time.Now().UnixMilli(),
+		DatabaseID: id,
 	}); err != nil {
 		return fmt.Errorf("encode ltx header: %w", err)
 	}
 
-	buf := make([]byte, hdr.pageSize)
-	for pgno := uint32(1); pgno <= hdr.pageN; pgno++ {
-		if _, err := io.ReadFull(rd, buf); err != nil {
+	buf := make([]byte, hdr.PageSize)
+	for pgno := uint32(1); pgno <= hdr.PageN; pgno++ {
+		// Free pages are not read & are checksummed as zeros.
+		if _, ok := free[pgno]; ok {
+			if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno, Flags: ltx.PageHeaderFlagFree}, nil); err != nil {
+				return fmt.Errorf("encode free page %d: %w", pgno, err)
+			}
+			clear(buf)
+			postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, buf))
+			continue
+		}
+
+		if err := rd.ReadPage(pgno, buf); err != nil {
 			return fmt.Errorf("read page %d: %w", pgno, err)
 		}
 
-		if pgno == ltx.LockPgno(hdr.pageSize) {
+		if pgno == ltx.LockPgno(hdr.PageSize) {
 			continue
 		}
 
@@ -137,44 +179,21 @@ Arguments:
 	enc.SetPostApplyChecksum(postApplyChecksum)
 	if err := enc.Close(); err != nil {
 		return fmt.Errorf("close ltx encoder: %w", err)
-	} else if err := out.Sync(); err != nil {
-		return fmt.Errorf("sync ltx file: %w", err)
 	} else if err := out.Close(); err != nil {
 		return fmt.Errorf("close ltx file: %w", err)
 	}
-	out = nil
-	if err := os.Rename(tmpPath, *outPath); err != nil {
-		return fmt.Errorf("rename temporary output file: %w", err)
-	}
-	tmpPath = ""
 
 	return nil
 }
 
-type sqliteDatabaseHeader struct {
-	pageSize uint32
-	pageN    uint32
-}
-
-func (c *EncodeDBCommand) readSQLiteDatabaseHeader(rd io.Reader) (ord io.Reader, hdr sqliteDatabaseHeader, err error) {
-	b := make([]byte, SQLITE_DATABASE_HEADER_SIZE)
+func (c *EncodeDBCommand) readSQLiteDatabaseHeader(rd io.Reader) (*sqlitepage.DatabaseHeader, error) {
+	b := make([]byte, sqlitepage.HeaderSize)
 	if _, err := io.ReadFull(rd, b); err == io.ErrUnexpectedEOF {
-		return ord, hdr, fmt.Errorf("invalid database header")
+		return nil, fmt.Errorf("invalid database header")
 	} else if err == io.EOF {
-		return ord, hdr, fmt.Errorf("empty database")
+		return nil, fmt.Errorf("empty database")
 	} else if err != nil {
-		return ord, hdr, err
-	} else if !bytes.Equal(b[:len(SQLITE_DATABASE_HEADER_STRING)], []byte(SQLITE_DATABASE_HEADER_STRING)) {
-		return ord, hdr, fmt.Errorf("invalid database header")
+		return nil, err
 	}
-
-	hdr.pageSize = uint32(binary.BigEndian.Uint16(b[16:]))
-	hdr.pageN = binary.BigEndian.Uint32(b[28:])
-	if hdr.pageSize == 1 {
-		hdr.pageSize = 65536
-	}
-
-	ord = io.MultiReader(bytes.NewReader(b), rd)
-
-	return ord, hdr, nil
+	return sqlitepage.ParseDatabaseHeader(b)
 }
@@ -38,6 +38,8 @@ func TestEncodeDBCommand(t *testing.T) {
 			t.Fatal(err)
 		} else if got, want := dec.Header().Version, ltx.Version; got != want {
 			t.Fatalf("version=%d, want %d", got, want)
+		} else if dec.Header().DatabaseID.IsZero() {
+			t.Fatal("expected generated database id")
 		}
 
 		if info, err := f.Stat(); err != nil {
@@ -47,6 +49,127 @@ func TestEncodeDBCommand(t *testing.T) {
 		}
 	})
 
+	t.Run("DatabaseID", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		writeSQLiteDatabase(t, dbPath)
+
+		const id = "0123abcd-0000-4000-8000-00000000beef"
+		outPath := filepath.Join(dir, "ltx")
+		if err := NewEncodeDBCommand().Run(context.Background(), []string{"-database-id", id, "-o", outPath, dbPath}); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, _, err := ltx.PeekHeader(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr.DatabaseID.String(), id; got != want {
+			t.Fatalf("DatabaseID=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ErrInvalidDatabaseID", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+		writeSQLiteDatabase(t, dbPath)
+
+		if err := NewEncodeDBCommand().Run(context.Background(), []string{"-database-id", "xyz", "-o", filepath.Join(dir, "ltx"), dbPath}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	// Freelist leaf pages are encoded as free page frames.
+	t.Run("OmitFreePages", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+
+		// Page 2 is a freelist trunk page with page 3 as its only leaf.
+		b := make([]byte, 512*4)
+		copy(b, "SQLite format 3\x00")
+		binary.BigEndian.PutUint16(b[16:], 512)
+		binary.BigEndian.PutUint32(b[28:], 4)
+		binary.BigEndian.PutUint32(b[32:], 2)
+		binary.BigEndian.PutUint32(b[36:], 2)
+		binary.BigEndian.PutUint32(b[512+4:], 1)
+		binary.BigEndian.PutUint32(b[512+8:], 3)
+		copy(b[1024:1536], bytes.Repeat([]byte("x"), 512))
+		if err := os.WriteFile(dbPath, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		outPath := filepath.Join(dir, "ltx")
+		if err := NewEncodeDBCommand().Run(context.Background(), []string{"-omit-free-pages", "-o", outPath, dbPath}); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := ltx.NewDecoder(bytes.NewReader(buf)).Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := ltx.NewDecoder(bytes.NewReader(buf))
+		if err := dec.DecodeHeader(); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 512)
+		for pgno := uint32(1); pgno <= 4; pgno++ {
+			var hdr ltx.PageHeader
+			if err := dec.DecodePage(&hdr, data); err != nil {
+				t.Fatal(err)
+			} else if got, want := hdr.IsFree(), pgno == 3; got != want {
+				t.Fatalf("pgno=%d: IsFree()=%v, want %v", pgno, got, want)
+			} else if pgno == 3 && !bytes.Equal(data, make([]byte, 512)) {
+				t.Fatal("expected zero free page")
+			}
+		}
+	})
+
+	t.Run("ZeroPages", func(t *testing.T) {
+		dir := t.TempDir()
+		dbPath := filepath.Join(dir, "db")
+
+		// Page 2 is all zeros.
+		b := make([]byte, 512*3)
+		copy(b, "SQLite format 3\x00")
+		binary.BigEndian.PutUint16(b[16:], 512)
+		binary.BigEndian.PutUint32(b[28:], 3)
+		copy(b[1024:], bytes.Repeat([]byte("x"), 512))
+		if err := os.WriteFile(dbPath, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		outPath := filepath.Join(dir, "ltx")
+		if err := NewEncodeDBCommand().Run(context.Background(), []string{"-o", outPath, dbPath}); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+
+		dec := ltx.NewDecoder(f)
+		if err := dec.DecodeHeader(); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 512)
+		for pgno := uint32(1); pgno <= 3; pgno++ {
+			var hdr ltx.PageHeader
+			if err := dec.DecodePage(&hdr, data); err != nil {
+				t.Fatal(err)
+			} else if got, want := !hdr.HasData(), pgno == 2; got != want {
+				t.Fatalf("pgno=%d: HasData()=%v", pgno, !got)
+			}
+		}
+	})
+
 	t.Run("NewOutputMode", func(t *testing.T) {
 		dir := t.TempDir()
 		dbPath := filepath.Join(dir, "db")
@@ -180,7 +303,7 @@ func writeSQLiteDatabase(t *testing.T, path string) []byte {
 	)
 
 	b := make([]byte, pageSize*pageN)
-	copy(b, SQLITE_DATABASE_HEADER_STRING)
+	copy(b, "SQLite format 3\x00")
 	binary.BigEndian.PutUint16(b[16:], pageSize)
 	binary.BigEndian.PutUint32(b[28:], pageN)
 	if err := os.WriteFile(path, b, 0o644); err != nil {
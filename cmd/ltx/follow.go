@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/superfly/ltx"
+)
+
+// FollowCommand represents a command to continuously apply a directory of LTX
+// files to a database file.
+type FollowCommand struct{}
+
+// NewFollowCommand returns a new instance of FollowCommand.
+func NewFollowCommand() *FollowCommand {
+	return &FollowCommand{}
+}
+
+// Run executes the command.
+func (c *FollowCommand) Run(ctx context.Context, args []string) (ret error) {
+	fs := flag.NewFlagSet("ltx-follow", flag.ContinueOnError)
+	dbPath := fs.String("db", "", "database path")
+	locking := fs.Bool("locking", ltx.LockingSupported, "hold SQLite-compatible locks while applying; unsupported on non-unix platforms")
+	interval := fs.Duration("interval", ltx.DefaultFollowerPollInterval, "interval between checks for new files")
+	fs.Usage = func() {
+		fmt.Println(`
+The follow command continuously applies the LTX files in a directory to a
+database file. Level-zero files are stored in DIR and higher levels are stored
+in numbered subdirectories.
+
+The database position is stored in a "-pos" file next to the database so
+following resumes from the same position when restarted. If the position is
+unknown, or not part of the directory's history, the database is restored from
+the latest snapshot.
+
+Usage:
+
+	ltx follow [arguments] DIR
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one directory required")
+	} else if *dbPath == "" {
+		return fmt.Errorf("required: -db PATH")
+	}
+
+	f := ltx.NewFollower(ltx.NewFileStore(fs.Arg(0)), *dbPath)
+	f.Locking = *locking
+	f.PollInterval = *interval
+	f.OnApply = func(pos ltx.Pos) { fmt.Printf("applied %s\n", pos) }
+	f.OnError = func(err error) { fmt.Fprintf(os.Stderr, "sync failed: %s\n", err) }
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	fmt.Printf("following %s from %s\n", fs.Arg(0), f.Pos())
+	if err := f.Run(ctx); err != nil {
+		return err
+	}
+	return f.Close()
+}
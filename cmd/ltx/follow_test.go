@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+// Ensure the follow command restores the database with the default flags,
+// which must be usable on every platform.
+func TestFollowCommand_DefaultFlags(t *testing.T) {
+	const pageSize = 512
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "db")
+	want := bytes.Repeat([]byte{0x12}, pageSize)
+	writeApplyTestLTX(t, filepath.Join(dir, ltx.FormatFilename(1, 1)), &ltx.FileSpec{
+		Header: ltx.Header{
+			Version:  ltx.Version,
+			Flags:    ltx.HeaderFlagNoChecksum,
+			PageSize: pageSize,
+			Commit:   1,
+			MinTXID:  1,
+			MaxTXID:  1,
+		},
+		Pages: []ltx.PageSpec{
+			{Header: ltx.PageHeader{Pgno: 1}, Data: want},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() { ch <- NewFollowCommand().Run(ctx, []string{"-db", dbPath, dir}) }()
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if buf, _ := os.ReadFile(dbPath); bytes.Equal(buf, want) {
+			break
+		}
+		select {
+		case err := <-ch:
+			t.Fatalf("unexpected exit: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for database")
+		}
+	}
+
+	cancel()
+	if err := <-ch; !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
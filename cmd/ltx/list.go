@@ -51,7 +51,7 @@ Arguments:
 		w = tw
 	}
 
-	_, _ = fmt.Fprintln(w, "min_txid\tmax_txid\tcommit\tpages\tpreapply\tpostapply\ttimestamp\twal_offset\twal_size\twal_salt")
+	_, _ = fmt.Fprintln(w, "min_txid\tmax_txid\tcommit\tpages\tpreapply\tpostapply\ttimestamp\twal_offset\twal_size\twal_salt\tdatabase_id\ttimeline\tshard")
 	for _, arg := range fs.Args() {
 		if err := c.printFile(w, arg); err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", arg, err)
@@ -79,7 +79,13 @@ func (c *ListCommand) printFile(w io.Writer, filename string) error {
 		timestamp = ""
 	}
 
-	_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%08x %08x\n",
+	// Only show the page range of shards.
+	var shard string
+	if hdr := dec.Header(); hdr.IsShard() {
+		shard = fmt.Sprintf("%d-%d", hdr.ShardMinPgno, hdr.ShardMaxPgno)
+	}
+
+	_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%08x %08x\t%s\t%d\t%s\n",
 		dec.Header().MinTXID.String(),
 		dec.Header().MaxTXID.String(),
 		dec.Header().Commit,
@@ -90,6 +96,9 @@ func (c *ListCommand) printFile(w io.Writer, filename string) error {
 		dec.Header().WALOffset,
 		dec.Header().WALSize,
 		dec.Header().WALSalt1, dec.Header().WALSalt2,
+		dec.Header().DatabaseID,
+		dec.Header().Timeline,
+		shard,
 	)
 
 	return nil
@@ -45,12 +45,20 @@ func (m *Main) Run(ctx context.Context, args []string) (err error) {
 		return NewApplyCommand().Run(ctx, args)
 	case "checksum":
 		return NewChecksumCommand().Run(ctx, args)
+	case "divergence":
+		return NewDivergenceCommand().Run(ctx, args)
 	case "dump":
 		return NewDumpCommand().Run(ctx, args)
 	case "encode-db":
 		return NewEncodeDBCommand().Run(ctx, args)
+	case "follow":
+		return NewFollowCommand().Run(ctx, args)
 	case "list":
 		return NewListCommand().Run(ctx, args)
+	case "prune":
+		return NewPruneCommand().Run(ctx, args)
+	case "serve":
+		return NewServeCommand().Run(ctx, args)
 	case "verify":
 		return NewVerifyCommand().Run(ctx, args)
 	case "version":
@@ -84,8 +92,12 @@ The commands are:
 
 	apply        applies a set of LTX files to a database
 	checksum     computes the LTX checksum of a database file
+	divergence   finds where the LTX histories of two replicas diverge
 	dump         writes out metadata and page headers for a set of LTX files
+	follow       continuously applies a directory of LTX files to a database
 	list         lists header & trailer fields for LTX files in a table
+	prune        deletes LTX files not needed by a retention policy
+	serve        serves a directory of LTX files over HTTP
 	verify       reads & verifies checksums of a set of LTX files
 	version      prints the version
 `[1:])
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/superfly/ltx"
+)
+
+// PruneCommand represents a command to delete LTX files that are no longer
+// needed under a retention policy.
+type PruneCommand struct{}
+
+// NewPruneCommand returns a new instance of PruneCommand.
+func NewPruneCommand() *PruneCommand {
+	return &PruneCommand{}
+}
+
+// Run executes the command.
+func (c *PruneCommand) Run(ctx context.Context, args []string) (ret error) {
+	var policy ltx.RetentionPolicy
+	fs := flag.NewFlagSet("ltx-prune", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print files that would be deleted without deleting them")
+	fs.DurationVar(&policy.L0Retention, "l0-retention", 0, "duration to retain level-zero files")
+	fs.DurationVar(&policy.SnapshotInterval, "snapshot-interval", 0, "retain the latest snapshot in each interval")
+	fs.DurationVar(&policy.SnapshotRetention, "snapshot-retention", 0, "duration to retain snapshots, if non-zero")
+	fs.BoolVar(&policy.KeepSinceLatestSnapshot, "keep-since-snapshot", false, "retain all positions after the latest snapshot")
+	fs.Usage = func() {
+		fmt.Println(`
+The prune command deletes LTX files from a directory that are not needed to
+restore any position retained by the policy. The latest position is always
+retained. Level-zero files are stored in DIR and higher levels are stored in
+numbered subdirectories.
+
+Usage:
+
+	ltx prune [arguments] DIR
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one directory required")
+	}
+
+	store := ltx.NewFileStore(fs.Arg(0))
+	itr, err := store.List(ctx, -1, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	plan, err := policy.Plan(itr)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer func() { _ = tw.Flush() }()
+
+	_, _ = fmt.Fprintln(tw, "level\tfilename\tsize")
+	for _, info := range plan.Delete {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%d\n", info.Level, ltx.FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), info.Size)
+		if *dryRun {
+			continue
+		}
+		if err := store.Delete(ctx, info); err != nil {
+			return fmt.Errorf("delete %s: %w", store.FilePath(info), err)
+		}
+	}
+
+	return nil
+}
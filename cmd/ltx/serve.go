@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxhttp"
+)
+
+// ServeCommand represents a command to serve a directory of LTX files over HTTP.
+type ServeCommand struct{}
+
+// NewServeCommand returns a new instance of ServeCommand.
+func NewServeCommand() *ServeCommand {
+	return &ServeCommand{}
+}
+
+// Run executes the command.
+func (c *ServeCommand) Run(ctx context.Context, args []string) (ret error) {
+	fs := flag.NewFlagSet("ltx-serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.Usage = func() {
+		fmt.Println(`
+The serve command serves the LTX files in a directory over HTTP. Level-zero
+files are stored in DIR and higher levels are stored in numbered subdirectories.
+
+Endpoints:
+
+	GET /pos                              latest position
+	GET /files?level=N&min=TXID&max=TXID  list files
+	GET /files/LEVEL/FILENAME             download an LTX file
+	GET /page?pgno=N&txid=TXID            read a page at a position
+
+Usage:
+
+	ltx serve [arguments] DIR
+
+Arguments:
+`[1:])
+		fs.PrintDefaults()
+		fmt.Println()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one directory required")
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	h := ltxhttp.NewHandler(ltx.NewFileStore(fs.Arg(0)))
+	defer func() { _ = h.Close() }()
+
+	srv := &http.Server{Handler: h}
+	stop := context.AfterFunc(ctx, func() { _ = srv.Close() })
+	defer stop()
+
+	fmt.Printf("serving %s on http://%s\n", fs.Arg(0), ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,326 @@
+package ltx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// CompactionPolicy represents the rules for compacting files from the level
+// below into Level. A level is due for compaction once any of the non-zero
+// thresholds are met. If all thresholds are zero then any pending input files
+// are compacted immediately.
+type CompactionPolicy struct {
+	// Destination level. Input files are read from Level-1.
+	Level int
+
+	// Time window covered by each compaction. The level is due once the
+	// newest file in the level is at least this old, based on its timestamp.
+	Interval time.Duration
+
+	// Number of pending input files which triggers a compaction. A set of
+	// shards counts as a single file.
+	MinFiles int
+
+	// Total size of pending input files, in bytes, which triggers a compaction.
+	MaxSize int64
+}
+
+// CompactionJob represents a single compaction of input files into one
+// output file at the destination level.
+type CompactionJob struct {
+	Level   int
+	MinTXID TXID
+	MaxTXID TXID
+	Inputs  []*FileInfo
+}
+
+// CompactionPlanner determines which files need to be compacted based on a
+// set of per-level policies, similar to a log-structured merge tree. Level
+// zero holds files as they are written and each higher level holds larger
+// files covering longer transaction ranges.
+//
+// Input files are not removed after compaction. Removing obsolete files is
+// left to the caller once they are no longer needed for restores.
+type CompactionPlanner struct {
+	// Policies for each destination level. Must be sorted by level and each
+	// level must be greater than zero.
+	Policies []CompactionPolicy
+
+	// Header flags to set on compacted output files.
+	HeaderFlags uint32
+
+	// If greater than zero, compacted output is split into shards that are
+	// each no larger than this many bytes. See Compactor.MaxOutputSize.
+	MaxOutputSize int64
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewCompactionPlanner returns a new instance of CompactionPlanner.
+func NewCompactionPlanner(policies []CompactionPolicy) *CompactionPlanner {
+	return &CompactionPlanner{
+		Policies: policies,
+		Now:      time.Now,
+	}
+}
+
+// Validate returns an error if the policies are invalid.
+func (p *CompactionPlanner) Validate() error {
+	for i, policy := range p.Policies {
+		if policy.Level <= 0 {
+			return fmt.Errorf("compaction policy level must be greater than zero: %d", policy.Level)
+		} else if i > 0 && policy.Level <= p.Policies[i-1].Level {
+			return fmt.Errorf("compaction policies must be sorted by level: %d -> %d", p.Policies[i-1].Level, policy.Level)
+		} else if policy.Interval < 0 || policy.MinFiles < 0 || policy.MaxSize < 0 {
+			return fmt.Errorf("compaction policy thresholds must not be negative: level %d", policy.Level)
+		}
+	}
+	return nil
+}
+
+// Plan returns the compaction jobs that are currently due for the files in
+// itr. Each job only includes files that exist at the time of planning so
+// jobs for higher levels do not include the output of jobs for lower levels.
+//
+// The iterator is closed before returning.
+func (p *CompactionPlanner) Plan(itr FileIterator) ([]*CompactionJob, error) {
+	if err := p.Validate(); err != nil {
+		_ = itr.Close()
+		return nil, err
+	}
+
+	infos, err := SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make(map[int][]*FileInfo)
+	for _, info := range infos {
+		levels[info.Level] = append(levels[info.Level], info)
+	}
+
+	var jobs []*CompactionJob
+	for _, policy := range p.Policies {
+		if job := p.planLevel(policy, levels[policy.Level-1], levels[policy.Level]); job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// planLevel returns a job for the policy's level or nil if it is not due.
+func (p *CompactionPlanner) planLevel(policy CompactionPolicy, src, dst []*FileInfo) *CompactionJob {
+	if len(src) == 0 {
+		return nil
+	}
+
+	// Only plan over the history of the latest timeline so that files after a
+	// branch point on a parent timeline are never compacted. Branch points are
+	// resolved in the same way as TimelineChain().
+	all := slices.Concat(src, dst)
+	var timeline uint32
+	for _, info := range all {
+		timeline = max(timeline, info.Timeline)
+	}
+	history := timelineHistory(groupTimelines(all), timeline)
+	src, dst = filterTimelineHistory(src, history), filterTimelineHistory(dst, history)
+
+	// Determine the last transaction & creation time of the destination level.
+	var maxTXID TXID
+	var lastCreatedAt time.Time
+	for _, info := range dst {
+		if info.MaxTXID > maxTXID {
+			maxTXID = info.MaxTXID
+		}
+		if info.CreatedAt.After(lastCreatedAt) {
+			lastCreatedAt = info.CreatedAt
+		}
+	}
+
+	// Collect the contiguous run of source files after the destination level.
+	// Shard sets are only included once every shard in the set exists.
+	var inputs []*FileInfo
+	var n int
+	var size int64
+	for _, g := range groupShards(src) {
+		info := g[0]
+		if info.MinTXID <= maxTXID {
+			continue
+		}
+
+		if len(inputs) == 0 {
+			if maxTXID != 0 && info.MinTXID != maxTXID+1 {
+				break // gap between levels, wait for the source to catch up
+			}
+		} else if prev := inputs[len(inputs)-1]; !IsContiguous(prev.MaxTXID, info.MinTXID, info.MaxTXID) {
+			break
+		}
+
+		inputs = append(inputs, g...)
+		for _, shard := range g {
+			size += shard.Size
+		}
+		n++
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	if !p.isDue(policy, n, size, lastCreatedAt) {
+		return nil
+	}
+
+	return &CompactionJob{
+		Level:   policy.Level,
+		MinTXID: inputs[0].MinTXID,
+		MaxTXID: inputs[len(inputs)-1].MaxTXID,
+		Inputs:  inputs,
+	}
+}
+
+// isDue returns true if any of the policy's thresholds have been met.
+func (p *CompactionPlanner) isDue(policy CompactionPolicy, n int, size int64, lastCreatedAt time.Time) bool {
+	if policy.Interval == 0 && policy.MinFiles == 0 && policy.MaxSize == 0 {
+		return true
+	}
+
+	if policy.Interval > 0 && p.now().Sub(lastCreatedAt) >= policy.Interval {
+		return true
+	}
+	if policy.MinFiles > 0 && n >= policy.MinFiles {
+		return true
+	}
+	if policy.MaxSize > 0 && size >= policy.MaxSize {
+		return true
+	}
+	return false
+}
+
+func (p *CompactionPlanner) now() time.Time {
+	if p.Now == nil {
+		return time.Now()
+	}
+	return p.Now()
+}
+
+// Compact plans & executes compactions for each level in order against s.
+// Files are re-listed before planning each level so that the output of one
+// level can cascade into the next. Returns the metadata of all output files.
+func (p *CompactionPlanner) Compact(ctx context.Context, s Store) ([]*FileInfo, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var outputs []*FileInfo
+	for _, policy := range p.Policies {
+		src, err := listStoreLevel(ctx, s, policy.Level-1)
+		if err != nil {
+			return outputs, err
+		}
+		dst, err := listStoreLevel(ctx, s, policy.Level)
+		if err != nil {
+			return outputs, err
+		}
+
+		job := p.planLevel(policy, src, dst)
+		if job == nil {
+			continue
+		}
+
+		infos, err := p.Execute(ctx, s, job)
+		if err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, infos...)
+	}
+	return outputs, nil
+}
+
+// Execute compacts the job's input files from s and writes the output to the
+// job's level. Returns the metadata of each output file, which is more than
+// one file if the output is split into shards. Each file is only visible once
+// it is fully written. If any shard fails then the other shards are deleted.
+func (p *CompactionPlanner) Execute(ctx context.Context, s Store, job *CompactionJob) ([]*FileInfo, error) {
+	rdrs := make([]io.Reader, 0, len(job.Inputs))
+	closers := make([]io.Closer, 0, len(job.Inputs))
+	defer func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}()
+	for _, info := range job.Inputs {
+		rc, err := s.Open(ctx, info)
+		if err != nil {
+			return nil, fmt.Errorf("open input file: %w", err)
+		}
+		rdrs, closers = append(rdrs, rc), append(closers, rc)
+	}
+
+	// Each output file is streamed to the store through its own pipe. Shard
+	// trailers are not written until compaction finishes so all shards are
+	// written to the store concurrently.
+	type result struct {
+		info *FileInfo
+		err  error
+	}
+	var pws []*io.PipeWriter
+	var results []chan result
+	nextWriter := func() io.Writer {
+		pr, pw := io.Pipe()
+		ch := make(chan result, 1)
+		go func() {
+			info, err := s.Write(ctx, job.Level, pr)
+			_ = pr.CloseWithError(io.ErrClosedPipe) // unblock compactor if write failed early
+			ch <- result{info: info, err: err}
+		}()
+		pws, results = append(pws, pw), append(results, ch)
+		return pw
+	}
+
+	// The first writer is started before the compactor is created so its
+	// store write must also be stopped if the compactor cannot be created.
+	c, compactErr := NewCompactor(nextWriter(), rdrs)
+	if compactErr == nil {
+		c.HeaderFlags = p.HeaderFlags
+		c.MaxOutputSize = p.MaxOutputSize
+		c.NextWriter = func() (io.Writer, error) { return nextWriter(), nil }
+		compactErr = c.Compact(ctx)
+	}
+	for _, pw := range pws {
+		_ = pw.CloseWithError(compactErr)
+	}
+
+	var err error
+	var infos []*FileInfo
+	for _, ch := range results {
+		if r := <-ch; r.err != nil && err == nil {
+			err = r.err
+		} else if r.err == nil {
+			infos = append(infos, r.info)
+		}
+	}
+	if compactErr != nil {
+		err = compactErr
+	}
+
+	if err != nil {
+		for _, info := range infos {
+			_ = s.Delete(ctx, info)
+		}
+		return nil, fmt.Errorf("compact level %d (%s-%s): %w", job.Level, job.MinTXID, job.MaxTXID, err)
+	}
+	return infos, nil
+}
+
+// listStoreLevel returns all files in a single level of s.
+func listStoreLevel(ctx context.Context, s Store, level int) ([]*FileInfo, error) {
+	itr, err := s.List(ctx, level, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return SliceFileIterator(itr)
+}
@@ -0,0 +1,170 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestCompactionPlanner_Plan(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	infos := []*ltx.FileInfo{
+		{Level: 0, MinTXID: 1, MaxTXID: 1, Size: 100},
+		{Level: 0, MinTXID: 2, MaxTXID: 2, Size: 100},
+		{Level: 0, MinTXID: 3, MaxTXID: 3, Size: 100},
+		{Level: 0, MinTXID: 4, MaxTXID: 4, Size: 100},
+		{Level: 1, MinTXID: 1, MaxTXID: 2, Size: 150, CreatedAt: now.Add(-30 * time.Second)},
+	}
+
+	plan := func(tb testing.TB, policies ...ltx.CompactionPolicy) []*ltx.CompactionJob {
+		tb.Helper()
+		p := ltx.NewCompactionPlanner(policies)
+		p.Now = func() time.Time { return now }
+		jobs, err := p.Plan(ltx.NewFileInfoSliceIterator(infos))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return jobs
+	}
+
+	t.Run("NoThresholds", func(t *testing.T) {
+		jobs := plan(t, ltx.CompactionPolicy{Level: 1}, ltx.CompactionPolicy{Level: 2})
+		if got, want := len(jobs), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		}
+		if job := jobs[0]; job.Level != 1 || job.MinTXID != 3 || job.MaxTXID != 4 || len(job.Inputs) != 2 {
+			t.Fatalf("unexpected job: %+v", job)
+		}
+		if job := jobs[1]; job.Level != 2 || job.MinTXID != 1 || job.MaxTXID != 2 || len(job.Inputs) != 1 {
+			t.Fatalf("unexpected job: %+v", job)
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, Interval: time.Minute}); len(jobs) != 0 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, Interval: 30 * time.Second}); len(jobs) != 1 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+	})
+
+	t.Run("MinFiles", func(t *testing.T) {
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, MinFiles: 3}); len(jobs) != 0 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, MinFiles: 2}); len(jobs) != 1 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+	})
+
+	t.Run("MaxSize", func(t *testing.T) {
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, MaxSize: 201}); len(jobs) != 0 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+		if jobs := plan(t, ltx.CompactionPolicy{Level: 1, MaxSize: 200}); len(jobs) != 1 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		p := ltx.NewCompactionPlanner([]ltx.CompactionPolicy{{Level: 1}})
+		jobs, err := p.Plan(ltx.NewFileInfoSliceIterator([]*ltx.FileInfo{
+			{Level: 0, MinTXID: 4, MaxTXID: 4},
+			{Level: 1, MinTXID: 1, MaxTXID: 2},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(jobs) != 0 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+	})
+
+	// Timeline 0 is restored to TXID 3 & resumed as timeline 1 so its file
+	// for TXID 4 is never compacted.
+	t.Run("Timeline", func(t *testing.T) {
+		infos := []*ltx.FileInfo{
+			{Level: 0, MinTXID: 1, MaxTXID: 1},
+			{Level: 0, MinTXID: 2, MaxTXID: 2},
+			{Level: 0, MinTXID: 3, MaxTXID: 3},
+			{Level: 0, MinTXID: 4, MaxTXID: 4},
+			{Level: 0, Timeline: 1, MinTXID: 4, MaxTXID: 4},
+			{Level: 0, Timeline: 1, MinTXID: 5, MaxTXID: 5},
+		}
+
+		p := ltx.NewCompactionPlanner([]ltx.CompactionPolicy{{Level: 1}})
+		jobs, err := p.Plan(ltx.NewFileInfoSliceIterator(slices.Clone(infos)))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(jobs) != 1 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+		assertFileInfoPtrs(t, jobs[0].Inputs, infos[0], infos[1], infos[2], infos[4], infos[5])
+
+		// Files compacted before the branch are continued by the new timeline.
+		jobs, err = p.Plan(ltx.NewFileInfoSliceIterator(append(slices.Clone(infos), &ltx.FileInfo{Level: 1, MinTXID: 1, MaxTXID: 3})))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(jobs) != 1 {
+			t.Fatalf("unexpected jobs: %d", len(jobs))
+		}
+		assertFileInfoPtrs(t, jobs[0].Inputs, infos[4], infos[5])
+	})
+
+	t.Run("ErrUnsortedPolicies", func(t *testing.T) {
+		p := ltx.NewCompactionPlanner([]ltx.CompactionPolicy{{Level: 2}, {Level: 1}})
+		if _, err := p.Plan(ltx.NewFileInfoSliceIterator(nil)); err == nil || err.Error() != `compaction policies must be sorted by level: 2 -> 1` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrInvalidLevel", func(t *testing.T) {
+		p := ltx.NewCompactionPlanner([]ltx.CompactionPolicy{{Level: 0}})
+		if _, err := p.Plan(ltx.NewFileInfoSliceIterator(nil)); err == nil || err.Error() != `compaction policy level must be greater than zero: 0` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestCompactionPlanner_Compact(t *testing.T) {
+	ctx := context.Background()
+	s := ltx.NewMemStore()
+	for txID := ltx.TXID(1); txID <= 4; txID++ {
+		if _, err := s.Write(ctx, 0, bytes.NewReader(ltxtest.EncodeFile(t, 0, txID, txID))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := ltx.NewCompactionPlanner([]ltx.CompactionPolicy{{Level: 1, MinFiles: 2}, {Level: 2}})
+	outputs, err := p.Compact(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(outputs), 2; got != want {
+		t.Fatalf("len=%d, want %d", got, want)
+	}
+
+	// Level 1 output should cascade into level 2 within the same call.
+	for i, level := range []int{1, 2} {
+		if info := outputs[i]; info.Level != level || info.MinTXID != 1 || info.MaxTXID != 4 {
+			t.Fatalf("%d. unexpected output: %+v", i, info)
+		} else if got, want := info.PostApplyChecksum, ltxtest.FileChecksum(4); got != want {
+			t.Fatalf("%d. PostApplyChecksum=%s, want %s", i, got, want)
+		}
+	}
+
+	// Only one new file is pending so the level 1 threshold is not met.
+	if _, err := s.Write(ctx, 0, bytes.NewReader(ltxtest.EncodeFile(t, 0, 5, 5))); err != nil {
+		t.Fatal(err)
+	}
+	if outputs, err := p.Compact(ctx, s); err != nil {
+		t.Fatal(err)
+	} else if len(outputs) != 0 {
+		t.Fatalf("unexpected outputs: %d", len(outputs))
+	}
+}
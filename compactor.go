@@ -2,9 +2,12 @@ package ltx
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"sync/atomic"
+
+	"github.com/pierrec/lz4/v4"
 )
 
 // CompactorStatus represents the current progress of compaction.
@@ -29,9 +32,12 @@ func (s CompactorStatus) Pct() float64 {
 
 // Compactor represents a compactor of LTX files.
 type Compactor struct {
-	enc    *Encoder
+	enc    *Encoder   // current output encoder
+	encs   []*Encoder // all output encoders, if sharded
 	inputs []*compactorInput
 
+	shardPageN uint32 // page numbers per output shard, zero if unsharded
+
 	n     atomic.Uint32 // last page that was compacted
 	total atomic.Uint32 // total pages (from last input's Commit)
 
@@ -42,6 +48,17 @@ type Compactor struct {
 	// transaction IDs. This is false by default but can be enabled when
 	// rebuilding snapshots with missing transactions.
 	AllowNonContiguousTXIDs bool
+
+	// If greater than zero, the output is split into shards by page number so
+	// that no output file is larger than this many bytes. Shard page ranges
+	// are sized as if no page compresses so shards are usually smaller than
+	// the limit. The first shard is written to the compactor's writer and
+	// each subsequent shard is written to the writer returned by NextWriter.
+	//
+	// Trailers for all shards are written once the last input is read so
+	// no shard is complete until Compact() returns.
+	MaxOutputSize int64
+	NextWriter    func() (io.Writer, error)
 }
 
 // NewCompactor returns a new instance of Compactor with default settings.
@@ -51,7 +68,7 @@ func NewCompactor(w io.Writer, rdrs []io.Reader) (*Compactor, error) {
 		return nil, fmt.Errorf("create ltx encoder: %w", err)
 	}
 
-	c := &Compactor{enc: enc}
+	c := &Compactor{enc: enc, encs: []*Encoder{enc}}
 	c.inputs = make([]*compactorInput, len(rdrs))
 	for i := range c.inputs {
 		c.inputs[i] = &compactorInput{dec: NewDecoder(rdrs[i])}
@@ -74,8 +91,18 @@ func (c *Compactor) Status() CompactorStatus {
 	}
 }
 
-// Compact merges the input readers into a single LTX writer.
-func (c *Compactor) Compact(ctx context.Context) error {
+// Compact merges the input readers into a single LTX writer. On error, all
+// output encoders are aborted so writers which support it, such as
+// AtomicFile, discard their partial output.
+func (c *Compactor) Compact(ctx context.Context) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			for _, enc := range c.encs {
+				_ = enc.Abort()
+			}
+		}
+	}()
+
 	if len(c.inputs) == 0 {
 		return fmt.Errorf("at least one input reader required")
 	}
@@ -87,7 +114,8 @@ func (c *Compactor) Compact(ctx context.Context) error {
 		}
 	}
 
-	// Validate that reader page sizes match & TXIDs are contiguous.
+	// Validate that reader page sizes match, database IDs match & TXIDs are contiguous.
+	databaseID := c.inputs[0].dec.Header().DatabaseID
 	for i := 1; i < len(c.inputs); i++ {
 		prevHdr := c.inputs[i-1].dec.Header()
 		hdr := c.inputs[i].dec.Header()
@@ -95,6 +123,30 @@ func (c *Compactor) Compact(ctx context.Context) error {
 		if prevHdr.PageSize != hdr.PageSize {
 			return fmt.Errorf("input files have mismatched page sizes: %d != %d", prevHdr.PageSize, hdr.PageSize)
 		}
+		if err := CheckDatabaseID(databaseID, hdr.DatabaseID); err != nil {
+			return fmt.Errorf("input file %d: %w", i, err)
+		} else if databaseID.IsZero() {
+			databaseID = hdr.DatabaseID
+		}
+		// Shards from the same set share a transaction range so they are
+		// merged like a single file. Sets must be complete & in page order.
+		if IsNextShard(prevHdr, hdr) {
+			continue
+		} else if !prevHdr.IsLastShard() || !hdr.IsFirstShard() {
+			return fmt.Errorf("input file %d: incomplete or out-of-order shard set", i)
+		}
+		// Files may only cross onto a new timeline at the exact branch point.
+		// Overlapping files would otherwise mix an abandoned history into the output.
+		if prevHdr.Timeline != hdr.Timeline {
+			if hdr.Timeline < prevHdr.Timeline {
+				return fmt.Errorf("input files have out-of-order timelines: %d -> %d", prevHdr.Timeline, hdr.Timeline)
+			} else if prevHdr.MaxTXID+1 != hdr.MinTXID {
+				return fmt.Errorf("input files must meet at branch point when changing timelines: (%s,%s) -> (%s,%s)",
+					prevHdr.MinTXID.String(), prevHdr.MaxTXID.String(),
+					hdr.MinTXID.String(), hdr.MaxTXID.String(),
+				)
+			}
+		}
 		if !c.AllowNonContiguousTXIDs && !IsContiguous(prevHdr.MaxTXID, hdr.MinTXID, hdr.MaxTXID) {
 			return fmt.Errorf("non-contiguous transaction ids in input files: (%s,%s) -> (%s,%s)",
 				prevHdr.MinTXID.String(), prevHdr.MaxTXID.String(),
@@ -106,27 +158,47 @@ func (c *Compactor) Compact(ctx context.Context) error {
 	// Fetch the first and last headers from the sorted readers.
 	minHdr := c.inputs[0].dec.Header()
 	maxHdr := c.inputs[len(c.inputs)-1].dec.Header()
+	if !minHdr.IsFirstShard() || !maxHdr.IsLastShard() {
+		return fmt.Errorf("input files contain an incomplete shard set")
+	}
 
 	// Generate output header. Skip NodeID as it's not meaningful after compaction.
-	if err := c.enc.EncodeHeader(Header{
+	// Free pages are copied from a snapshot input so the output keeps its flag.
+	hdr := Header{
 		Version:          Version,
-		Flags:            c.HeaderFlags,
+		Flags:            c.HeaderFlags | minHdr.Flags&HeaderFlagFreePages,
 		PageSize:         minHdr.PageSize,
 		Commit:           maxHdr.Commit,
 		MinTXID:          minHdr.MinTXID,
 		MaxTXID:          maxHdr.MaxTXID,
 		Timestamp:        maxHdr.Timestamp,
 		PreApplyChecksum: minHdr.PreApplyChecksum,
-	}); err != nil {
+		DatabaseID:       databaseID,
+		Timeline:         maxHdr.Timeline,
+	}
+
+	// Split output into shards if it could exceed the maximum output size.
+	var err error
+	if c.shardPageN, err = c.calcShardPageN(hdr.PageSize, hdr.Commit); err != nil {
+		return err
+	} else if c.shardPageN > 0 {
+		hdr.Flags |= HeaderFlagShard
+		hdr.ShardMinPgno, hdr.ShardMaxPgno = 1, c.shardPageN
+	}
+
+	if err := c.enc.EncodeHeader(hdr); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
 
 	// Set total page count for progress tracking.
 	c.total.Store(maxHdr.Commit)
 
-	// Write page headers & data.
+	// Write page headers & data. Trailing shards are written even if they
+	// contain no pages so that the shard set covers the entire database.
 	if err := c.writePageBlock(ctx); err != nil {
 		return err
+	} else if err := c.nextShard(hdr.Commit); err != nil {
+		return err
 	}
 
 	// Close readers to ensure they're valid.
@@ -136,12 +208,65 @@ func (c *Compactor) Compact(ctx context.Context) error {
 		}
 	}
 
-	// Close encoder.
-	c.enc.SetPostApplyChecksum(c.inputs[len(c.inputs)-1].dec.Trailer().PostApplyChecksum)
-	if err := c.enc.Close(); err != nil {
-		return fmt.Errorf("close encoder: %w", err)
+	// Close encoders. Every shard shares the post-apply checksum of the set.
+	for _, enc := range c.encs {
+		enc.SetPostApplyChecksum(c.inputs[len(c.inputs)-1].dec.Trailer().PostApplyChecksum)
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("close encoder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// calcShardPageN returns the number of page numbers covered by each output
+// shard so that a shard cannot exceed MaxOutputSize even if no pages compress.
+// Returns zero if the output does not need to be split.
+func (c *Compactor) calcShardPageN(pageSize, commit uint32) (uint32, error) {
+	if c.MaxOutputSize <= 0 || commit == 0 {
+		return 0, nil
+	}
+
+	const overhead = HeaderSize + PageHeaderSize + 1 + 8 + TrailerSize // header, end of page block, index end & size, trailer
+	frameSize := int64(PageHeaderSize + 4 + lz4.CompressBlockBound(int(pageSize)) + 2*binary.MaxVarintLen32 + binary.MaxVarintLen64)
+
+	n := (c.MaxOutputSize - overhead) / frameSize
+	if n < 1 {
+		return 0, fmt.Errorf("max output size too small for page size %d: %d", pageSize, c.MaxOutputSize)
+	} else if n >= int64(commit) {
+		return 0, nil // entire output fits in one file
+	} else if c.NextWriter == nil {
+		return 0, fmt.Errorf("next writer required to split compaction output")
 	}
+	return uint32(n), nil
+}
 
+// nextShard moves the output to the shard containing pgno. Shards before it
+// are finished even if they contain no pages. This is a no-op if the output
+// is unsharded.
+func (c *Compactor) nextShard(pgno uint32) error {
+	for hdr := c.enc.Header(); hdr.IsShard() && pgno > hdr.ShardMaxPgno; hdr = c.enc.Header() {
+		if err := c.enc.closePageBlock(); err != nil {
+			return fmt.Errorf("close shard: %w", err)
+		}
+
+		w, err := c.NextWriter()
+		if err != nil {
+			return fmt.Errorf("next shard writer: %w", err)
+		}
+		enc, err := NewEncoder(w)
+		if err != nil {
+			return fmt.Errorf("create ltx encoder: %w", err)
+		}
+
+		hdr.ShardMinPgno = hdr.ShardMaxPgno + 1
+		hdr.ShardMaxPgno = min(hdr.ShardMaxPgno+c.shardPageN, hdr.Commit)
+		if err := enc.EncodeHeader(hdr); err != nil {
+			return fmt.Errorf("write shard header: %w", err)
+		}
+		c.enc = enc
+		c.encs = append(c.encs, enc)
+	}
 	return nil
 }
 
@@ -219,7 +344,9 @@ func (c *Compactor) writePageBuffer(_ context.Context, pgno uint32) error {
 		}
 		pageWritten = true
 
-		if err := c.enc.EncodePage(hdr, data); err != nil {
+		if err := c.nextShard(pgno); err != nil {
+			return err
+		} else if err := c.enc.EncodePage(hdr, data); err != nil {
 			return fmt.Errorf("copy page %d header: %w", pgno, err)
 		}
 	}
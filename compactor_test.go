@@ -5,6 +5,9 @@ import (
 	"context"
 	"errors"
 	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/superfly/ltx"
@@ -121,6 +124,48 @@ func TestCompactor_Compact(t *testing.T) {
 			},
 		})
 	})
+	// Zero page frames are carried through compaction without data.
+	t.Run("ZeroPages", func(t *testing.T) {
+		page1, zero := bytes.Repeat([]byte{0x81}, 1024), make([]byte, 1024)
+		chksum1 := ltx.ChecksumFlag | (ltx.ChecksumPage(1, page1) ^ ltx.ChecksumPage(2, zero))
+		chksum2 := ltx.ChecksumFlag | (ltx.ChecksumPage(1, zero) ^ ltx.ChecksumPage(2, zero))
+
+		spec, err := compactFileSpecs(t,
+			&ltx.FileSpec{
+				Header: ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+				Pages: []ltx.PageSpec{
+					{Header: ltx.PageHeader{Pgno: 1}, Data: page1},
+					{Header: ltx.PageHeader{Pgno: 2, Flags: ltx.PageHeaderFlagZero}, Data: zero},
+				},
+				Trailer: ltx.Trailer{PostApplyChecksum: chksum1},
+			},
+			&ltx.FileSpec{
+				Header: ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 2, MaxTXID: 2, Timestamp: 2000, PreApplyChecksum: chksum1},
+				Pages: []ltx.PageSpec{
+					{Header: ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagZero}, Data: zero},
+				},
+				Trailer: ltx.Trailer{PostApplyChecksum: chksum2},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertFileSpecEqual(t, spec, &ltx.FileSpec{
+			Header: ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 2, Timestamp: 2000},
+			Pages: []ltx.PageSpec{
+				{Header: ltx.PageHeader{Pgno: 1}, Data: zero},
+				{Header: ltx.PageHeader{Pgno: 2}, Data: zero},
+			},
+			Trailer: ltx.Trailer{PostApplyChecksum: chksum2},
+		})
+		for _, page := range spec.Pages {
+			if got, want := page.Header.Flags, ltx.PageHeaderFlagZero; got != want {
+				t.Fatalf("pgno=%d: flags=0x%04x, want 0x%04x", page.Header.Pgno, got, want)
+			}
+		}
+	})
+
 	t.Run("NonSnapshotPageDataOnly", func(t *testing.T) {
 		spec, err := compactFileSpecs(t,
 			&ltx.FileSpec{
@@ -302,6 +347,88 @@ func TestCompactor_Compact(t *testing.T) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
+	t.Run("ErrAbortOutput", func(t *testing.T) {
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, &ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x81}, 1024)}, {Header: ltx.PageHeader{Pgno: 2}, Data: bytes.Repeat([]byte{0x82}, 1024)}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+		})
+
+		dir := t.TempDir()
+		f, err := ltx.CreateAtomicFile(filepath.Join(dir, "out.ltx"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Truncate input so compaction fails after the header is written.
+		c, err := ltx.NewCompactor(f, []io.Reader{bytes.NewReader(buf.Bytes()[:buf.Len()-100])})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := c.Compact(context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if ents, err := os.ReadDir(dir); err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 0 {
+			t.Fatalf("unexpected files: %v", ents)
+		}
+	})
+	t.Run("DatabaseID", func(t *testing.T) {
+		id := ltx.DatabaseID{1, 2, 3}
+		spec, err := compactFileSpecs(t,
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x81}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: 0xeb953fc47685d740},
+			},
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: ltx.ChecksumFlag | 2, DatabaseID: id},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x91}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: 0x914e5275dc2f7dea},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := spec.Header.DatabaseID, id; got != want {
+			t.Fatalf("DatabaseID=%s, want %s", got, want)
+		}
+	})
+	t.Run("ErrDatabaseIDMismatch", func(t *testing.T) {
+		_, err := compactFileSpecs(t,
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1, Timestamp: 1000, DatabaseID: ltx.DatabaseID{1}},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x81}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+			},
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: ltx.ChecksumFlag | 2, DatabaseID: ltx.DatabaseID{2}},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x91}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+			},
+		)
+		if !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrTimelineOverlap", func(t *testing.T) {
+		_, err := compactFileSpecs(t,
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 3, Timestamp: 1000},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x81}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: 0xeb953fc47685d740},
+			},
+			&ltx.FileSpec{
+				Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 3, MaxTXID: 3, Timestamp: 1000, PreApplyChecksum: ltx.ChecksumFlag | 2, Timeline: 1},
+				Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{0x91}, 1024)}},
+				Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+			},
+		)
+		if err == nil || err.Error() != `input files must meet at branch point when changing timelines: (0000000000000001,0000000000000003) -> (0000000000000003,0000000000000003)` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
 	t.Run("AllowNonContiguousTXID", func(t *testing.T) {
 		bufs := make([]bytes.Buffer, 2)
 		writeFileSpec(t, &bufs[0], &ltx.FileSpec{
@@ -382,3 +509,120 @@ func TestCompactor_Compact(t *testing.T) {
 		}
 	})
 }
+
+func TestCompactor_MaxOutputSize(t *testing.T) {
+	const pageSize, commit = 512, 10
+
+	// Build a snapshot with random, incompressible page data.
+	input := &ltx.FileSpec{
+		Header: ltx.Header{Version: ltx.Version, PageSize: pageSize, Commit: commit, MinTXID: 1, MaxTXID: 2, Timestamp: 1000},
+	}
+	postApplyChecksum := ltx.ChecksumFlag
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		data := make([]byte, pageSize)
+		_, _ = rand.Read(data)
+		input.Pages = append(input.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: pgno}, Data: data})
+		postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, data))
+	}
+	input.Trailer.PostApplyChecksum = postApplyChecksum
+
+	var inputBuf bytes.Buffer
+	writeFileSpec(t, &inputBuf, input)
+
+	// Limit output to three uncompressed pages per shard.
+	const maxOutputSize = 1811
+	var outputs []*bytes.Buffer
+	outputs = append(outputs, &bytes.Buffer{})
+	c, err := ltx.NewCompactor(outputs[0], []io.Reader{bytes.NewReader(inputBuf.Bytes())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxOutputSize = maxOutputSize
+	c.NextWriter = func() (io.Writer, error) {
+		outputs = append(outputs, &bytes.Buffer{})
+		return outputs[len(outputs)-1], nil
+	}
+	if err := c.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(outputs), 4; got != want {
+		t.Fatalf("len(outputs)=%d, want %d", got, want)
+	}
+	ranges := [][2]uint32{{1, 3}, {4, 6}, {7, 9}, {10, 10}}
+	for i, output := range outputs {
+		if output.Len() > maxOutputSize {
+			t.Fatalf("%d. output size %d exceeds max", i, output.Len())
+		}
+
+		spec := readFileSpec(t, bytes.NewReader(output.Bytes()))
+		if !spec.Header.IsShard() {
+			t.Fatalf("%d. expected shard", i)
+		} else if got, want := [2]uint32{spec.Header.ShardMinPgno, spec.Header.ShardMaxPgno}, ranges[i]; got != want {
+			t.Fatalf("%d. shard range=%v, want %v", i, got, want)
+		} else if got, want := spec.Trailer.PostApplyChecksum, postApplyChecksum; got != want {
+			t.Fatalf("%d. PostApplyChecksum=%s, want %s", i, got, want)
+		} else if got, want := len(spec.Pages), int(ranges[i][1]-ranges[i][0]+1); got != want {
+			t.Fatalf("%d. len(Pages)=%d, want %d", i, got, want)
+		}
+	}
+
+	t.Run("Recombine", func(t *testing.T) {
+		rdrs := make([]io.Reader, len(outputs))
+		for i := range outputs {
+			rdrs[i] = bytes.NewReader(outputs[i].Bytes())
+		}
+
+		var buf bytes.Buffer
+		c, err := ltx.NewCompactor(&buf, rdrs)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := c.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		spec := readFileSpec(t, &buf)
+		if spec.Header.IsShard() {
+			t.Fatal("expected unsharded output")
+		}
+		assertFileSpecEqual(t, spec, input)
+	})
+
+	t.Run("ErrIncompleteShardSet", func(t *testing.T) {
+		c, err := ltx.NewCompactor(io.Discard, []io.Reader{bytes.NewReader(outputs[0].Bytes()), bytes.NewReader(outputs[2].Bytes())})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := c.Compact(context.Background()); err == nil || err.Error() != `input file 1: incomplete or out-of-order shard set` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDecodeDatabase", func(t *testing.T) {
+		dec := ltx.NewDecoder(bytes.NewReader(outputs[0].Bytes()))
+		if err := dec.DecodeDatabaseTo(io.Discard); err == nil || err.Error() != `cannot decode LTX shard to SQLite database` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNextWriterRequired", func(t *testing.T) {
+		c, err := ltx.NewCompactor(io.Discard, []io.Reader{bytes.NewReader(inputBuf.Bytes())})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.MaxOutputSize = maxOutputSize
+		if err := c.Compact(context.Background()); err == nil || err.Error() != `next writer required to split compaction output` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrMaxOutputSizeTooSmall", func(t *testing.T) {
+		c, err := ltx.NewCompactor(io.Discard, []io.Reader{bytes.NewReader(inputBuf.Bytes())})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.MaxOutputSize = 200
+		if err := c.Compact(context.Background()); err == nil || err.Error() != `max output size too small for page size 512: 200` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package ltx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+var _ io.ReaderAt = (*DatabaseReader)(nil)
+
+// SizeReaderAt represents an io.ReaderAt with a known size such as
+// *bytes.Reader or *io.SectionReader.
+type SizeReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// DatabaseReader represents a read-only view of the SQLite database file
+// produced by applying a snapshot and the LTX files that follow it. Pages are
+// read on demand from the latest file containing them using each file's page
+// index so the database is never materialized. The lock page and any pages
+// not contained in a file are zero-filled, as with Decoder.DecodeDatabaseTo().
+//
+// Files are not verified against their checksums. Use RestorePath() to find
+// the files required for a position.
+type DatabaseReader struct {
+	files     []*databaseReaderFile
+	pages     map[uint32]databaseReaderPage
+	pageSize  uint32
+	commit    uint32
+	pos       Pos
+	timestamp int64
+	id        DatabaseID
+	freePages bool // true if the snapshot omitted free pages
+
+	closers []io.Closer // files opened by OpenDatabaseReader()
+}
+
+type databaseReaderFile struct {
+	r      io.ReaderAt
+	header Header
+}
+
+// databaseReaderPage is the location of the latest version of a page.
+type databaseReaderPage struct {
+	file int
+	elem PageIndexElem
+}
+
+// NewDatabaseReader returns a new DatabaseReader for the database after
+// applying rdrs in order. The first file must be a snapshot, or the first
+// shard of a snapshot, and each subsequent file must follow on from the
+// position of the previous file.
+func NewDatabaseReader(rdrs []SizeReaderAt) (*DatabaseReader, error) {
+	if len(rdrs) == 0 {
+		return nil, fmt.Errorf("at least one file required")
+	}
+
+	r := &DatabaseReader{
+		pages: make(map[uint32]databaseReaderPage),
+	}
+
+	var prev *Header
+	for i, rd := range rdrs {
+		hdr, trailer, index, err := readFileIndexAt(rd, rd.Size())
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", i, err)
+		}
+
+		if err := r.validateNext(prev, hdr); err != nil {
+			return nil, fmt.Errorf("file %d: %w", i, err)
+		}
+		prev = &hdr
+
+		r.files = append(r.files, &databaseReaderFile{r: rd, header: hdr})
+		for pgno, elem := range index {
+			r.pages[pgno] = databaseReaderPage{file: i, elem: elem}
+		}
+
+		// Wait until the last shard in a set to shrink the database.
+		if hdr.IsLastShard() {
+			for pgno := range r.pages {
+				if pgno > hdr.Commit {
+					delete(r.pages, pgno)
+				}
+			}
+			r.commit = hdr.Commit
+			r.pos = Pos{TXID: hdr.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}
+			r.timestamp = hdr.Timestamp
+		}
+	}
+
+	if !prev.IsLastShard() {
+		return nil, fmt.Errorf("incomplete shard set: missing shard starting at page %d", prev.ShardMaxPgno+1)
+	}
+	return r, nil
+}
+
+// OpenDatabaseReader returns a DatabaseReader for the database at pos using
+// the files in store. The files are chosen by RestorePath() so a zero checksum
+// in pos matches any checksum. The reader must be closed by the caller.
+func OpenDatabaseReader(ctx context.Context, store Store, pos Pos) (_ *DatabaseReader, retErr error) {
+	itr, err := store.List(ctx, -1, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := RestorePath(infos, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	var closers []io.Closer
+	defer func() {
+		if retErr != nil {
+			for _, c := range closers {
+				_ = c.Close()
+			}
+		}
+	}()
+
+	rdrs := make([]SizeReaderAt, len(path))
+	for i, info := range path {
+		rc, err := store.Open(ctx, info)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, rc)
+
+		if rdrs[i], err = newSizeReaderAt(info, rc); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := NewDatabaseReader(rdrs)
+	if err != nil {
+		return nil, err
+	}
+	r.closers = closers
+	return r, nil
+}
+
+// newSizeReaderAt returns a random access reader for the LTX file read from
+// rc. The file is read into memory if rc is not an io.ReaderAt.
+func newSizeReaderAt(info *FileInfo, rc io.Reader) (SizeReaderAt, error) {
+	if ra, ok := rc.(io.ReaderAt); ok {
+		return io.NewSectionReader(ra, 0, info.Size), nil
+	}
+
+	buf, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), err)
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// Close closes the files opened by OpenDatabaseReader(). Readers passed to
+// NewDatabaseReader() are not closed.
+func (r *DatabaseReader) Close() (err error) {
+	for _, c := range r.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.closers = nil
+	return err
+}
+
+// validateNext returns an error if hdr cannot be applied after prev.
+func (r *DatabaseReader) validateNext(prev *Header, hdr Header) error {
+	if err := CheckDatabaseID(r.id, hdr.DatabaseID); err != nil {
+		return err
+	} else if r.id.IsZero() {
+		r.id = hdr.DatabaseID
+	}
+
+	if prev == nil {
+		if !hdr.IsSnapshot() {
+			return fmt.Errorf("first file must be a snapshot")
+		} else if !hdr.IsFirstShard() {
+			return fmt.Errorf("shard applied out of order: starts at page %d", hdr.ShardMinPgno)
+		}
+		r.pageSize, r.freePages = hdr.PageSize, hdr.HasFreePages()
+		return nil
+	}
+
+	if hdr.PageSize != r.pageSize {
+		return fmt.Errorf("page size mismatch: %d != %d", hdr.PageSize, r.pageSize)
+	} else if !prev.IsLastShard() {
+		if !IsNextShard(*prev, hdr) {
+			return fmt.Errorf("incomplete shard set: expected shard starting at page %d", prev.ShardMaxPgno+1)
+		}
+		return nil
+	} else if !hdr.IsFirstShard() {
+		return fmt.Errorf("shard applied out of order: starts at page %d", hdr.ShardMinPgno)
+	} else if hdr.MinTXID != prev.MaxTXID+1 {
+		return fmt.Errorf("non-contiguous transaction ids: %s -> %s", prev.MaxTXID, hdr.MinTXID)
+	} else if !hdr.NoChecksum() && r.pos.PostApplyChecksum != 0 && hdr.PreApplyChecksum != r.pos.PostApplyChecksum {
+		return preApplyChecksumError(r.pos.PostApplyChecksum, hdr.PreApplyChecksum, r.freePages)
+	}
+	return nil
+}
+
+// PageSize returns the size of each page, in bytes.
+func (r *DatabaseReader) PageSize() uint32 { return r.pageSize }
+
+// Commit returns the size of the database, in pages.
+func (r *DatabaseReader) Commit() uint32 { return r.commit }
+
+// Size returns the size of the database file, in bytes.
+func (r *DatabaseReader) Size() int64 { return int64(r.commit) * int64(r.pageSize) }
+
+// Pos returns the replication position of the database.
+func (r *DatabaseReader) Pos() Pos { return r.pos }
+
+// DatabaseID returns the database ID of the files, if set.
+func (r *DatabaseReader) DatabaseID() DatabaseID { return r.id }
+
+// Timestamp returns the timestamp of the last file applied.
+func (r *DatabaseReader) Timestamp() time.Time { return time.UnixMilli(r.timestamp).UTC() }
+
+// ReadAt reads len(p) bytes of the database file starting at off. Safe to
+// call concurrently.
+func (r *DatabaseReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	} else if off >= r.Size() {
+		return 0, io.EOF
+	}
+
+	data := make([]byte, r.pageSize)
+	var frame []byte
+	for len(p) > 0 && off < r.Size() {
+		pgno := uint32(off/int64(r.pageSize)) + 1
+		if frame, err = r.readPage(pgno, data, frame); err != nil {
+			return n, fmt.Errorf("read page %d: %w", pgno, err)
+		}
+
+		nn := copy(p, data[off%int64(r.pageSize):])
+		p, off, n = p[nn:], off+int64(nn), n+nn
+	}
+
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ReadPage reads the contents of pgno into data, which must be the page size.
+func (r *DatabaseReader) ReadPage(pgno uint32, data []byte) error {
+	if pgno == 0 || pgno > r.commit {
+		return fmt.Errorf("page number %d out of range for commit size %d", pgno, r.commit)
+	} else if uint32(len(data)) != r.pageSize {
+		return fmt.Errorf("invalid page buffer size: %d, expecting %d", len(data), r.pageSize)
+	}
+	_, err := r.readPage(pgno, data, nil)
+	return err
+}
+
+// readPage reads pgno into data using frame as a buffer for the compressed
+// page frame. Returns the frame buffer so it can be reused.
+func (r *DatabaseReader) readPage(pgno uint32, data, frame []byte) ([]byte, error) {
+	page, ok := r.pages[pgno]
+	if !ok {
+		clear(data) // lock page or unwritten page
+		return frame, nil
+	}
+
+	if int64(cap(frame)) < page.elem.Size {
+		frame = make([]byte, page.elem.Size)
+	}
+	frame = frame[:page.elem.Size]
+
+	if _, err := r.files[page.file].r.ReadAt(frame, page.elem.Offset); err != nil {
+		return frame, err
+	}
+
+	hdr, buf, err := DecodePageDataInto(frame, data)
+	if err != nil {
+		return frame, err
+	} else if hdr.Pgno != pgno {
+		return frame, fmt.Errorf("unexpected page number in frame: %d", hdr.Pgno)
+	} else if !hdr.HasData() {
+		clear(data)
+		return frame, nil
+	} else if uint32(len(buf)) != r.pageSize {
+		return frame, fmt.Errorf("invalid page size: %d, expecting %d", len(buf), r.pageSize)
+	}
+	copy(data, buf) // no-op unless the old frame format allocated a buffer
+	return frame, nil
+}
+
+// readFileIndexAt reads the header, trailer & page index of an LTX file of
+// the given size.
+func readFileIndexAt(r io.ReaderAt, size int64) (hdr Header, trailer Trailer, index map[uint32]PageIndexElem, err error) {
+	const sizeN = 8 // page index size field
+
+	if size < HeaderSize+sizeN+TrailerSize {
+		return hdr, trailer, nil, ErrInvalidFile
+	}
+
+	b := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return hdr, trailer, nil, fmt.Errorf("read header: %w", err)
+	} else if err := hdr.UnmarshalBinary(b); err != nil {
+		return hdr, trailer, nil, fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	b = b[:sizeN+TrailerSize]
+	if _, err := r.ReadAt(b, size-int64(len(b))); err != nil {
+		return hdr, trailer, nil, fmt.Errorf("read trailer: %w", err)
+	} else if err := trailer.UnmarshalBinary(b[sizeN:]); err != nil {
+		return hdr, trailer, nil, fmt.Errorf("unmarshal trailer: %w", err)
+	}
+
+	indexSize := int64(binary.BigEndian.Uint64(b[:sizeN]))
+	if indexSize <= 0 || indexSize > size-HeaderSize-sizeN-TrailerSize {
+		return hdr, trailer, nil, fmt.Errorf("invalid page index size: %d", indexSize)
+	}
+
+	b = make([]byte, indexSize+sizeN)
+	if _, err := r.ReadAt(b, size-TrailerSize-int64(len(b))); err != nil {
+		return hdr, trailer, nil, fmt.Errorf("read page index: %w", err)
+	}
+	if index, err = DecodePageIndex(bytes.NewReader(b), 0, hdr.MinTXID, hdr.MaxTXID); err != nil {
+		return hdr, trailer, nil, err
+	}
+	return hdr, trailer, index, nil
+}
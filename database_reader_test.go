@@ -0,0 +1,197 @@
+package ltx_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestDatabaseReader(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		img := newTestImage(t, 512, 4)
+		rdrs := []ltx.SizeReaderAt{encodeImageSnapshot(t, img, 2)}
+
+		// Grow the database & change a page.
+		next := img.Clone()
+		if err := next.SetPage(2, bytes.Repeat([]byte{0xf2}, 512)); err != nil {
+			t.Fatal(err)
+		} else if err := next.SetPage(6, bytes.Repeat([]byte{0xf6}, 512)); err != nil {
+			t.Fatal(err)
+		}
+		rdrs = append(rdrs, encodeImageDiff(t, next, img, 3, 3))
+		img = next
+
+		// Shrink the database then grow it so that old pages are not reused.
+		next = img.Clone()
+		next.Truncate(3)
+		rdrs = append(rdrs, encodeImageDiff(t, next, img, 4, 4))
+		img = next
+
+		next = img.Clone()
+		next.Truncate(5)
+		rdrs = append(rdrs, encodeImageDiff(t, next, img, 5, 5))
+		img = next
+
+		r, err := ltx.NewDatabaseReader(rdrs)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := r.Size(), img.Size(); got != want {
+			t.Fatalf("Size()=%d, want %d", got, want)
+		} else if got, want := r.Pos(), ltx.NewPos(5, img.Checksum()); got != want {
+			t.Fatalf("Pos()=%s, want %s", got, want)
+		}
+
+		var want bytes.Buffer
+		if _, err := img.WriteTo(&want); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size())); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, want.Bytes()) {
+			t.Fatal("database mismatch")
+		}
+
+		// Read across a page boundary and past the end of the database.
+		buf := make([]byte, 1000)
+		if n, err := r.ReadAt(buf, 500); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf[:n], want.Bytes()[500:1500]) {
+			t.Fatal("partial read mismatch")
+		}
+		if n, err := r.ReadAt(buf, r.Size()-100); err != io.EOF {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := n, 100; got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		}
+	})
+
+	// Free page frames are read as zeros.
+	t.Run("FreePages", func(t *testing.T) {
+		img, zeroed := newTestFreelistImage(t)
+		img.OmitFreePages = true
+
+		var buf bytes.Buffer
+		if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 1, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := ltx.NewDatabaseReader([]ltx.SizeReaderAt{bytes.NewReader(buf.Bytes())})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want bytes.Buffer
+		if _, err := zeroed.WriteTo(&want); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size())); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want.Bytes()) {
+			t.Fatal("database mismatch")
+		}
+	})
+	t.Run("Shards", func(t *testing.T) {
+		img := newTestImage(t, 512, 5)
+
+		var rdrs []ltx.SizeReaderAt
+		for _, rng := range [][2]uint32{{1, 2}, {3, 5}} {
+			var buf bytes.Buffer
+			if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, MinTXID: 1, MaxTXID: 1, ShardMinPgno: rng[0], ShardMaxPgno: rng[1]}); err != nil {
+				t.Fatal(err)
+			}
+			rdrs = append(rdrs, bytes.NewReader(buf.Bytes()))
+		}
+
+		r, err := ltx.NewDatabaseReader(rdrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := make([]byte, 512)
+		for pgno := uint32(1); pgno <= 5; pgno++ {
+			if err := r.ReadPage(pgno, data); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, img.Page(pgno)) {
+				t.Fatalf("page %d mismatch", pgno)
+			}
+		}
+
+		if _, err := ltx.NewDatabaseReader(rdrs[:1]); err == nil || err.Error() != `incomplete shard set: missing shard starting at page 3` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotSnapshot", func(t *testing.T) {
+		img := newTestImage(t, 512, 2)
+		next := img.Clone()
+		next.Truncate(1)
+		if _, err := ltx.NewDatabaseReader([]ltx.SizeReaderAt{encodeImageDiff(t, next, img, 2, 2)}); err == nil || err.Error() != `file 0: first file must be a snapshot` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNonContiguous", func(t *testing.T) {
+		img := newTestImage(t, 512, 2)
+		next := img.Clone()
+		next.Truncate(1)
+		if _, err := ltx.NewDatabaseReader([]ltx.SizeReaderAt{
+			encodeImageSnapshot(t, img, 1),
+			encodeImageDiff(t, next, img, 3, 3),
+		}); err == nil || err.Error() != `file 1: non-contiguous transaction ids: 0000000000000001 -> 0000000000000003` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrDatabaseIDMismatch", func(t *testing.T) {
+		img := newTestImage(t, 512, 2)
+		next := img.Clone()
+		next.Truncate(1)
+
+		var snapshot, diff bytes.Buffer
+		if err := img.EncodeSnapshot(&snapshot, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 1, Timestamp: 1000, DatabaseID: ltx.DatabaseID{1}}); err != nil {
+			t.Fatal(err)
+		} else if err := next.EncodeDiff(&diff, img, ltx.Header{Version: ltx.Version, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, DatabaseID: ltx.DatabaseID{2}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ltx.NewDatabaseReader([]ltx.SizeReaderAt{
+			bytes.NewReader(snapshot.Bytes()),
+			bytes.NewReader(diff.Bytes()),
+		}); !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrChecksumMismatch", func(t *testing.T) {
+		img := newTestImage(t, 512, 2)
+		next := newTestImage(t, 512, 3)
+		if _, err := ltx.NewDatabaseReader([]ltx.SizeReaderAt{
+			encodeImageSnapshot(t, img, 1),
+			encodeImageDiff(t, next, ltx.NewImage(512), 2, 2),
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func encodeImageSnapshot(tb testing.TB, img *ltx.Image, maxTXID ltx.TXID) *bytes.Reader {
+	tb.Helper()
+	var buf bytes.Buffer
+	if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: maxTXID, Timestamp: 1000}); err != nil {
+		tb.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func encodeImageDiff(tb testing.TB, img, base *ltx.Image, minTXID, maxTXID ltx.TXID) *bytes.Reader {
+	tb.Helper()
+	var buf bytes.Buffer
+	if err := img.EncodeDiff(&buf, base, ltx.Header{Version: ltx.Version, MinTXID: minTXID, MaxTXID: maxTXID, Timestamp: 1000}); err != nil {
+		tb.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
@@ -8,6 +8,7 @@ import (
 	"hash/crc64"
 	"io"
 	"math"
+	"runtime"
 
 	"github.com/pierrec/lz4/v4"
 )
@@ -32,6 +33,31 @@ type Decoder struct {
 	hash   hash.Hash64
 	pageN  int   // pages read
 	n      int64 // bytes read
+
+	// Number of page frames to read & decompress ahead of DecodePage() on
+	// background goroutines. If zero, frames are read on the caller's
+	// goroutine. Must be set before the first call to DecodePage(). Close()
+	// must be called to release the goroutines, even if decoding fails.
+	ReadAhead int
+
+	headerBuf    [HeaderSize]byte  // reused for each header
+	frame        decodeFrame       // reused when not reading ahead
+	frames       chan *decodeFrame // read in file order, awaiting DecodePage()
+	free         chan *decodeFrame // available for reuse by the reader
+	stop         chan struct{}     // closed to stop the reader
+	stopped      chan struct{}     // closed once the reader has exited
+	readAheadErr error             // first error from the reader
+}
+
+// decodeFrame represents a single page frame read from the file.
+type decodeFrame struct {
+	hdr        PageHeader
+	hdrBuf     []byte // raw page header
+	sizeBuf    []byte // raw size prefix, if block compressed
+	compressed []byte // block compressed page data
+	data       []byte // uncompressed page data
+	err        error
+	done       chan struct{}
 }
 
 // NewDecoder returns a new instance of Decoder.
@@ -40,10 +66,26 @@ func NewDecoder(r io.Reader) *Decoder {
 		r:     r,
 		zr:    lz4.NewReader(r),
 		state: stateHeader,
-		hash:  crc64.New(crc64.MakeTable(crc64.ISO)),
+		hash:  crc64.New(crc64ISOTable),
 	}
 }
 
+// Reset discards the decoder's state and switches to reading from r. Buffers
+// are retained so a single decoder can be reused for many files. Any frames
+// read ahead are discarded.
+func (dec *Decoder) Reset(r io.Reader) {
+	dec.stopReadAhead()
+
+	dec.r = r
+	dec.header, dec.trailer = Header{}, Trailer{}
+	dec.pageIndex = nil
+	dec.state = stateHeader
+	dec.chksum = 0
+	dec.hash.Reset()
+	dec.pageN, dec.n = 0, 0
+	dec.frames, dec.free, dec.readAheadErr = nil, nil, nil
+}
+
 // N returns the number of bytes read.
 func (dec *Decoder) N() int64 { return dec.n }
 
@@ -73,6 +115,8 @@ func (dec *Decoder) PageIndex() map[uint32]PageIndexElem {
 
 // Close verifies the reader is at the end of the file and that the checksum matches.
 func (dec *Decoder) Close() error {
+	dec.stopReadAhead()
+
 	if dec.state == stateClosed {
 		return nil // no-op
 	} else if dec.state != stateClose {
@@ -110,7 +154,9 @@ func (dec *Decoder) Close() error {
 	}
 
 	// Verify post-apply checksum for snapshot files if checksums are being tracked.
-	if dec.header.IsSnapshot() && !dec.header.NoChecksum() {
+	// Shards only contain part of the database so their checksum is verified
+	// once the complete set has been applied.
+	if dec.header.IsSnapshot() && !dec.header.NoChecksum() && !dec.header.IsShard() {
 		if dec.trailer.PostApplyChecksum != dec.chksum {
 			return fmt.Errorf("post-apply checksum in trailer (%s) does not match calculated checksum (%s)", dec.trailer.PostApplyChecksum, dec.chksum)
 		}
@@ -125,7 +171,7 @@ func (dec *Decoder) Close() error {
 // DecodeHeader reads the LTX file header frame and stores it internally.
 // Call Header() to retrieve the header after this is successfully called.
 func (dec *Decoder) DecodeHeader() error {
-	b := make([]byte, HeaderSize)
+	b := dec.headerBuf[:]
 	if _, err := io.ReadFull(dec.r, b); err != nil {
 		return err
 	} else if err := dec.header.UnmarshalBinary(b); err != nil {
@@ -159,15 +205,25 @@ func (dec *Decoder) DecodePage(hdr *PageHeader, data []byte) error {
 		return fmt.Errorf("invalid page buffer size: %d, expecting %d", len(data), dec.header.PageSize)
 	}
 
-	// Read and unmarshal page header.
-	b := make([]byte, PageHeaderSize)
-	if _, err := io.ReadFull(dec.r, b); err != nil {
-		return err
-	} else if err := hdr.UnmarshalBinary(b); err != nil {
-		return fmt.Errorf("unmarshal: %w", err)
+	var f *decodeFrame
+	if dec.ReadAhead > 0 {
+		var err error
+		if f, err = dec.nextFrame(); err != nil {
+			return err
+		}
+		defer func() { dec.free <- f }()
+	} else {
+		f = &dec.frame
+		f.data = data
+		if err := dec.readFrame(dec.r, &dec.header, f); err != nil {
+			return err
+		} else if err := f.decompress(); err != nil {
+			return err
+		}
 	}
 
-	dec.writeToHash(b)
+	*hdr = f.hdr
+	dec.writeToHash(f.hdrBuf)
 
 	// An empty page header indicates the end of the page block.
 	if hdr.IsZero() {
@@ -175,57 +231,196 @@ func (dec *Decoder) DecodePage(hdr *PageHeader, data []byte) error {
 		return io.EOF
 	}
 
-	if err := hdr.Validate(); err != nil {
+	dec.writeToHash(f.sizeBuf)
+	if dec.ReadAhead > 0 {
+		copy(data, f.data)
+	}
+	dec.writeToHash(data)
+	dec.pageN++
+
+	// Calculate checksum while decoding snapshots if tracking checksums.
+	if dec.header.IsSnapshot() && !dec.header.NoChecksum() {
+		if hdr.Pgno != LockPgno(dec.header.PageSize) {
+			dec.chksum = ChecksumFlag | (dec.chksum ^ ChecksumPage(hdr.Pgno, data))
+		}
+	}
+
+	return nil
+}
+
+// readFrame reads the next page frame from r into f. The file header is
+// passed in so the read-ahead reader does not share decoder state with the
+// caller. Block compressed data is not decompressed until f.decompress() is
+// called. Frames using the older LZ4 frame format are decompressed
+// immediately since their compressed size is not known until the frame has
+// been read.
+func (dec *Decoder) readFrame(r io.Reader, header *Header, f *decodeFrame) error {
+	if f.hdrBuf == nil {
+		f.hdrBuf = make([]byte, PageHeaderSize)
+	}
+	f.sizeBuf, f.compressed = f.sizeBuf[:0], f.compressed[:0]
+
+	// Read and unmarshal page header.
+	f.hdr = PageHeader{}
+	if _, err := io.ReadFull(r, f.hdrBuf); err != nil {
+		return err
+	} else if err := f.hdr.UnmarshalBinary(f.hdrBuf); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	// An empty page header indicates the end of the page block.
+	if f.hdr.IsZero() {
+		return nil
+	}
+
+	if err := f.hdr.Validate(); err != nil {
 		return err
+	} else if !header.ContainsPgno(f.hdr.Pgno) {
+		return fmt.Errorf("page number %d outside shard page range (%d,%d)", f.hdr.Pgno, header.ShardMinPgno, header.ShardMaxPgno)
+	} else if f.hdr.IsFree() && !header.HasFreePages() {
+		return fmt.Errorf("free page %d requires free pages header flag", f.hdr.Pgno)
+	}
+
+	// Free & zero pages have no data. The page is cleared by f.decompress().
+	if !f.hdr.HasData() {
+		return nil
 	}
 
 	// Read page data using format-specific approach.
-	if hdr.Flags&PageHeaderFlagSize != 0 {
+	if f.hdr.Flags&PageHeaderFlagSize != 0 {
 		// New block format: read size prefix, then LZ4 block data.
-		sizeBuf := make([]byte, 4)
-		if _, err := io.ReadFull(dec.r, sizeBuf); err != nil {
+		f.sizeBuf = append(f.sizeBuf, 0, 0, 0, 0)
+		if _, err := io.ReadFull(r, f.sizeBuf); err != nil {
 			return fmt.Errorf("read data size: %w", err)
 		}
-		dec.writeToHash(sizeBuf)
-		dataSize := binary.BigEndian.Uint32(sizeBuf)
+		dataSize := int(binary.BigEndian.Uint32(f.sizeBuf))
 
-		compressed := make([]byte, dataSize)
-		if _, err := io.ReadFull(dec.r, compressed); err != nil {
-			return fmt.Errorf("read compressed data: %w", err)
-		}
-		if _, err := lz4.UncompressBlock(compressed, data); err != nil {
-			return fmt.Errorf("decompress block: %w", err)
+		if cap(f.compressed) < dataSize {
+			f.compressed = make([]byte, dataSize)
 		}
-	} else {
-		// Old format: use LimitedReader workaround for lz4 frame concatenation.
-		// The lz4 library peeks ahead after EOF to check for concatenated frames,
-		// so we limit reads to prevent it from reading into the next page header.
-		dec.lr.R = dec.r
-		dec.lr.N = math.MaxInt64
-		dec.zr.Reset(&dec.lr)
-
-		if _, err := io.ReadFull(dec.zr, data); err != nil {
-			return err
+		f.compressed = f.compressed[:dataSize]
+		if _, err := io.ReadFull(r, f.compressed); err != nil {
+			return fmt.Errorf("read compressed data: %w", err)
 		}
+		return nil
+	}
 
-		// Limit remaining reads to the LZ4 frame footer size before checking EOF.
-		dec.lr.N = lz4FrameFooterSize
-		if err := dec.readLZ4Trailer(); err != nil {
-			return fmt.Errorf("read lz4 trailer: %w", err)
-		}
+	// Old format: use LimitedReader workaround for lz4 frame concatenation.
+	// The lz4 library peeks ahead after EOF to check for concatenated frames,
+	// so we limit reads to prevent it from reading into the next page header.
+	dec.lr.R = r
+	dec.lr.N = math.MaxInt64
+	dec.zr.Reset(&dec.lr)
+
+	if _, err := io.ReadFull(dec.zr, f.data); err != nil {
+		return err
 	}
 
-	dec.writeToHash(data)
-	dec.pageN++
+	// Limit remaining reads to the LZ4 frame footer size before checking EOF.
+	dec.lr.N = lz4FrameFooterSize
+	if err := dec.readLZ4Trailer(); err != nil {
+		return fmt.Errorf("read lz4 trailer: %w", err)
+	}
+	return nil
+}
 
-	// Calculate checksum while decoding snapshots if tracking checksums.
-	if dec.header.IsSnapshot() && !dec.header.NoChecksum() {
-		if hdr.Pgno != LockPgno(dec.header.PageSize) {
-			dec.chksum = ChecksumFlag | (dec.chksum ^ ChecksumPage(hdr.Pgno, data))
+// decompress decompresses block compressed page data into f.data.
+func (f *decodeFrame) decompress() error {
+	if f.hdr.IsZero() {
+		return nil
+	} else if !f.hdr.HasData() {
+		clear(f.data)
+		return nil
+	} else if f.hdr.Flags&PageHeaderFlagSize == 0 {
+		return nil
+	}
+	if _, err := lz4.UncompressBlock(f.compressed, f.data); err != nil {
+		return fmt.Errorf("decompress block: %w", err)
+	}
+	return nil
+}
+
+// nextFrame returns the next frame from the read-ahead goroutines. The
+// frame must be returned to dec.free once its data has been copied.
+func (dec *Decoder) nextFrame() (*decodeFrame, error) {
+	if dec.readAheadErr != nil {
+		return nil, dec.readAheadErr
+	} else if dec.frames == nil {
+		dec.startReadAhead()
+	}
+
+	f := <-dec.frames
+	<-f.done
+	if f.err != nil {
+		dec.readAheadErr = f.err
+		dec.free <- f
+		return nil, f.err
+	}
+	return f, nil
+}
+
+// startReadAhead starts a goroutine to read frames in order and a pool of
+// goroutines to decompress them. At most ReadAhead frames are buffered.
+//
+// The reader is passed everything it reads or writes so that Reset() cannot
+// change them while it is running. The LZ4 reader is only shared as it is not
+// used by the caller until the reader has exited, see stopReadAhead().
+func (dec *Decoder) startReadAhead() {
+	dec.frames = make(chan *decodeFrame, dec.ReadAhead)
+	dec.free = make(chan *decodeFrame, dec.ReadAhead)
+	dec.stop = make(chan struct{})
+	dec.stopped = make(chan struct{})
+	for i := 0; i < dec.ReadAhead; i++ {
+		dec.free <- &decodeFrame{
+			data: make([]byte, dec.header.PageSize),
+			done: make(chan struct{}, 1),
 		}
 	}
 
-	return nil
+	work := make(chan *decodeFrame, dec.ReadAhead)
+	for i := 0; i < min(dec.ReadAhead, runtime.GOMAXPROCS(0)); i++ {
+		go func() {
+			for f := range work {
+				f.err = f.decompress()
+				f.done <- struct{}{}
+			}
+		}()
+	}
+
+	go func(r io.Reader, header Header, frames, free chan *decodeFrame, stop, stopped chan struct{}) {
+		defer close(stopped)
+		defer close(work)
+
+		for {
+			var f *decodeFrame
+			select {
+			case f = <-free:
+			case <-stop:
+				return
+			}
+
+			// Frames are queued in file order before they are decompressed.
+			// The reader exits after the end of the page block or an error.
+			// Sends never block as there are only ReadAhead frames.
+			f.err = dec.readFrame(r, &header, f)
+			frames <- f
+			if f.err != nil || f.hdr.IsZero() {
+				f.done <- struct{}{}
+				return
+			}
+			work <- f
+		}
+	}(dec.r, dec.header, dec.frames, dec.free, dec.stop, dec.stopped)
+}
+
+// stopReadAhead stops the read-ahead reader, if running, and waits for it to
+// exit. Frames which have already been read are discarded.
+func (dec *Decoder) stopReadAhead() {
+	if dec.stop != nil {
+		close(dec.stop)
+		<-dec.stopped
+		dec.stop, dec.stopped = nil, nil
+	}
 }
 
 // Verify reads the entire file. Header & trailer can be accessed via methods
@@ -252,6 +447,10 @@ func (dec *Decoder) Verify() error {
 }
 
 // DecodeDatabaseTo decodes the LTX file as a SQLite database to w.
+//
+// If w is a file positioned at its end, such as a new file, then zero pages
+// and the lock page are skipped rather than written so the database is
+// created as a sparse file.
 // The LTX file MUST be a snapshot file.
 func (dec *Decoder) DecodeDatabaseTo(w io.Writer) error {
 	if err := dec.DecodeHeader(); err != nil {
@@ -262,6 +461,15 @@ func (dec *Decoder) DecodeDatabaseTo(w io.Writer) error {
 	lockPgno := hdr.LockPgno()
 	if !dec.header.IsSnapshot() {
 		return fmt.Errorf("cannot decode non-snapshot LTX file to SQLite database")
+	} else if dec.header.IsShard() {
+		return fmt.Errorf("cannot decode LTX shard to SQLite database")
+	}
+
+	sw, err := newSparseWriter(w)
+	if err != nil {
+		return fmt.Errorf("stat output: %w", err)
+	} else if sw != nil {
+		w = sw
 	}
 
 	var pageHeader PageHeader
@@ -286,6 +494,12 @@ func (dec *Decoder) DecodeDatabaseTo(w io.Writer) error {
 		}
 	}
 
+	if sw != nil {
+		if err := sw.Flush(); err != nil {
+			return fmt.Errorf("extend database: %w", err)
+		}
+	}
+
 	// Issue one more final read and expect to see an EOF. This is required so
 	// that the decoder can successfully close and validate.
 	if err := dec.DecodePage(&pageHeader, data); err == nil {
@@ -327,10 +541,21 @@ func DecodeHeader(r io.Reader) (hdr Header, data []byte, err error) {
 
 // DecodePageData decodes the page header & data from a single frame.
 func DecodePageData(b []byte) (hdr PageHeader, data []byte, err error) {
+	return DecodePageDataInto(b, nil)
+}
+
+// DecodePageDataInto decodes the page header & data from a single frame and
+// decompresses the data into dst, which must have a capacity of at least the
+// page size. Returns the page data, which shares the underlying array of dst.
+// If dst is nil then a buffer is allocated for the largest page size.
+//
+// Free & zero page frames have no data so nil data is returned and the caller
+// must treat the page as zeros. See PageHeader.HasData().
+func DecodePageDataInto(b, dst []byte) (hdr PageHeader, data []byte, err error) {
 	if err := hdr.UnmarshalBinary(b); err != nil {
 		return hdr, data, fmt.Errorf("unmarshal: %w", err)
 	}
-	if hdr.IsZero() {
+	if hdr.IsZero() || !hdr.HasData() {
 		return hdr, data, nil
 	}
 
@@ -346,11 +571,13 @@ func DecodePageData(b []byte) (hdr PageHeader, data []byte, err error) {
 			return hdr, nil, fmt.Errorf("buffer too small for data: need %d, have %d", offset+int(dataSize), len(b))
 		}
 
-		// LZ4 block compressed data.
+		// LZ4 block compressed data. The uncompressed size is not stored so
+		// allocate enough for the largest page size if no buffer is provided.
 		compressed := b[offset : offset+int(dataSize)]
-		// Estimate uncompressed size - pages are typically 512-65536 bytes.
-		// We'll use a reasonable upper bound and resize if needed.
-		data = make([]byte, 65536)
+		data = dst[:cap(dst)]
+		if len(data) == 0 {
+			data = make([]byte, MaxPageSize)
+		}
 		n, err := lz4.UncompressBlock(compressed, data)
 		if err != nil {
 			return hdr, nil, fmt.Errorf("decompress block: %w", err)
@@ -3,8 +3,13 @@ package ltx_test
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/superfly/ltx"
@@ -175,6 +180,41 @@ func TestDecoder_DecodeDatabaseTo(t *testing.T) {
 		}
 	})
 
+	// Zero pages are skipped when decoding to a new file so the database must
+	// still be extended over trailing zero pages.
+	t.Run("SparseFile", func(t *testing.T) {
+		zero := make([]byte, 512)
+		data := bytes.Repeat([]byte("2"), 512)
+		spec := &ltx.FileSpec{
+			Header: ltx.Header{Version: ltx.Version, Flags: 0, PageSize: 512, Commit: 4, MinTXID: 1, MaxTXID: 2, Timestamp: 1000},
+			Pages: []ltx.PageSpec{
+				{Header: ltx.PageHeader{Pgno: 1}, Data: data},
+				{Header: ltx.PageHeader{Pgno: 2}, Data: zero},
+				{Header: ltx.PageHeader{Pgno: 3}, Data: data},
+				{Header: ltx.PageHeader{Pgno: 4}, Data: zero},
+			},
+		}
+		want := slices.Concat(data, zero, data, zero)
+		spec.Trailer.PostApplyChecksum = mustChecksumReader(t, want)
+
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, spec)
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+
+		if err := ltx.NewDecoder(&buf).DecodeDatabaseTo(f); err != nil {
+			t.Fatal(err)
+		} else if got, err := os.ReadFile(f.Name()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, want) {
+			t.Fatal("output mismatch")
+		}
+	})
+
 	t.Run("WithLockPage", func(t *testing.T) {
 		if testing.Short() {
 			t.Skip("skipping in short mode")
@@ -394,3 +434,260 @@ func TestDecoder_64KBPageSize(t *testing.T) {
 		}
 	})
 }
+
+func TestDecoder_ReadAhead(t *testing.T) {
+	const pageSize, commit = 1024, 100
+
+	// Generate a snapshot with pages of varying compressibility. Every tenth
+	// page is left as zeros so it is encoded without data.
+	spec := &ltx.FileSpec{
+		Header: ltx.Header{Version: ltx.Version, PageSize: pageSize, Commit: commit, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+	}
+	postApplyChecksum := ltx.ChecksumFlag
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		hdr, data := ltx.PageHeader{Pgno: pgno}, make([]byte, pageSize)
+		if pgno%10 == 0 {
+			hdr.Flags = ltx.PageHeaderFlagZero
+		} else {
+			_, _ = rand.Read(data[:pgno*pageSize/commit])
+		}
+		spec.Pages = append(spec.Pages, ltx.PageSpec{Header: hdr, Data: data})
+		postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, data))
+	}
+	spec.Trailer.PostApplyChecksum = postApplyChecksum
+
+	var buf bytes.Buffer
+	writeFileSpec(t, &buf, spec)
+
+	t.Run("OK", func(t *testing.T) {
+		for _, n := range []int{1, 4, 256} {
+			dec := ltx.NewDecoder(bytes.NewReader(buf.Bytes()))
+			dec.ReadAhead = n
+			if err := dec.DecodeHeader(); err != nil {
+				t.Fatal(err)
+			}
+
+			var hdr ltx.PageHeader
+			data := make([]byte, pageSize)
+			for i := range spec.Pages {
+				if err := dec.DecodePage(&hdr, data); err != nil {
+					t.Fatal(err)
+				} else if got, want := hdr.Pgno, spec.Pages[i].Header.Pgno; got != want {
+					t.Fatalf("ReadAhead=%d: pgno=%d, want %d", n, got, want)
+				} else if !bytes.Equal(data, spec.Pages[i].Data) {
+					t.Fatalf("ReadAhead=%d: page %d data mismatch", n, hdr.Pgno)
+				}
+			}
+			if err := dec.DecodePage(&hdr, data); err != io.EOF {
+				t.Fatalf("ReadAhead=%d: expected EOF, got: %v", n, err)
+			}
+
+			if err := dec.Close(); err != nil {
+				t.Fatal(err)
+			} else if got, want := dec.PostApplyPos(), (ltx.Pos{TXID: 1, PostApplyChecksum: postApplyChecksum}); got != want {
+				t.Fatalf("ReadAhead=%d: PostApplyPos=%s, want %s", n, got, want)
+			}
+		}
+	})
+
+	t.Run("ErrChecksumMismatch", func(t *testing.T) {
+		b := bytes.Clone(buf.Bytes())
+		b[len(b)-1]++
+
+		dec := ltx.NewDecoder(bytes.NewReader(b))
+		dec.ReadAhead = 4
+		if err := dec.Verify(); !errors.Is(err, ltx.ErrChecksumMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrUnexpectedEOF", func(t *testing.T) {
+		dec := ltx.NewDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+		dec.ReadAhead = 4
+		if err := dec.Verify(); err == nil || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Errors are returned on subsequent reads & close does not block.
+		if err := dec.DecodePage(&ltx.PageHeader{}, make([]byte, pageSize)); err == nil {
+			t.Fatal("expected error")
+		} else if err := dec.Close(); err == nil {
+			t.Fatal("expected close error")
+		}
+	})
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	var buf0, buf1 bytes.Buffer
+	writeFileSpec(t, &buf0, &ltx.FileSpec{
+		Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{1}, 1024)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | ltx.ChecksumPage(1, bytes.Repeat([]byte{1}, 1024))},
+	})
+	writeFileSpec(t, &buf1, &ltx.FileSpec{
+		Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 2, MinTXID: 2, MaxTXID: 2, Timestamp: 2000, PreApplyChecksum: ltx.ChecksumFlag | 1},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 2}, Data: bytes.Repeat([]byte{2}, 512)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 2},
+	})
+
+	t.Run("OK", func(t *testing.T) {
+		for _, readAhead := range []int{0, 4} {
+			dec := ltx.NewDecoder(bytes.NewReader(buf0.Bytes()))
+			dec.ReadAhead = readAhead
+			if err := dec.Verify(); err != nil {
+				t.Fatal(err)
+			}
+
+			dec.Reset(bytes.NewReader(buf1.Bytes()))
+			if err := dec.Verify(); err != nil {
+				t.Fatal(err)
+			} else if got, want := dec.PostApplyPos(), (ltx.Pos{TXID: 2, PostApplyChecksum: ltx.ChecksumFlag | 2}); got != want {
+				t.Fatalf("PostApplyPos=%s, want %s", got, want)
+			} else if got, want := dec.PageN(), 1; got != want {
+				t.Fatalf("PageN=%d, want %d", got, want)
+			}
+		}
+	})
+
+	// Resetting or closing while frames are still being read ahead must wait
+	// for the reader to exit. Run with -race to detect shared state.
+	t.Run("ReadAheadMidStream", func(t *testing.T) {
+		spec := &ltx.FileSpec{
+			Header: ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 100, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+		}
+		for pgno := uint32(1); pgno <= 100; pgno++ {
+			data := bytes.Repeat([]byte{byte(pgno)}, 512)
+			spec.Pages = append(spec.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: pgno}, Data: data})
+			spec.Trailer.PostApplyChecksum ^= ltx.ChecksumPage(pgno, data)
+		}
+		spec.Trailer.PostApplyChecksum |= ltx.ChecksumFlag
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, spec)
+
+		for i := 0; i < 100; i++ {
+			dec := ltx.NewDecoder(bytes.NewReader(buf.Bytes()))
+			dec.ReadAhead = 4
+			if err := dec.DecodeHeader(); err != nil {
+				t.Fatal(err)
+			} else if err := dec.DecodePage(&ltx.PageHeader{}, make([]byte, 512)); err != nil {
+				t.Fatal(err)
+			}
+
+			dec.Reset(bytes.NewReader(buf.Bytes()))
+			if err := dec.Verify(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Closing before the end of the page block stops the reader.
+			dec.Reset(bytes.NewReader(buf.Bytes()))
+			if err := dec.DecodeHeader(); err != nil {
+				t.Fatal(err)
+			} else if err := dec.DecodePage(&ltx.PageHeader{}, make([]byte, 512)); err != nil {
+				t.Fatal(err)
+			} else if err := dec.Close(); err == nil {
+				t.Fatal("expected close error")
+			}
+		}
+	})
+}
+
+func TestDecoder_DecodePage_Allocs(t *testing.T) {
+	const pageN = 2000
+	spec := &ltx.FileSpec{
+		Header: ltx.Header{Version: ltx.Version, PageSize: 4096, Commit: pageN, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+	}
+	for pgno := uint32(1); pgno <= pageN; pgno++ {
+		data := bytes.Repeat([]byte{byte(pgno)}, 4096)
+		spec.Pages = append(spec.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: pgno}, Data: data})
+		spec.Trailer.PostApplyChecksum ^= ltx.ChecksumPage(pgno, data)
+	}
+	spec.Trailer.PostApplyChecksum |= ltx.ChecksumFlag
+
+	var buf bytes.Buffer
+	writeFileSpec(t, &buf, spec)
+
+	dec := ltx.NewDecoder(bytes.NewReader(buf.Bytes()))
+	if err := dec.DecodeHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	var hdr ltx.PageHeader
+	data := make([]byte, 4096)
+	if n := testing.AllocsPerRun(pageN/2, func() {
+		if err := dec.DecodePage(&hdr, data); err != nil {
+			t.Fatal(err)
+		}
+	}); n != 0 {
+		t.Fatalf("allocs per page: %v", n)
+	}
+}
+
+func TestDecodePageDataInto_Allocs(t *testing.T) {
+	var buf bytes.Buffer
+	writeFileSpec(t, &buf, &ltx.FileSpec{
+		Header:  ltx.Header{Version: ltx.Version, PageSize: 4096, Commit: 1, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{1}, 4096)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumPage(1, bytes.Repeat([]byte{1}, 4096))},
+	})
+	frame := buf.Bytes()[ltx.HeaderSize:]
+	want := bytes.Repeat([]byte{1}, 4096)
+
+	dst := make([]byte, 4096)
+	if n := testing.AllocsPerRun(100, func() {
+		if _, data, err := ltx.DecodePageDataInto(frame, dst); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, want) {
+			t.Fatal("page data mismatch")
+		}
+	}); n != 0 {
+		t.Fatalf("allocs per page: %v", n)
+	}
+}
+
+func BenchmarkDecoder_DecodePage(b *testing.B) {
+	const pageN = 1000
+	spec := &ltx.FileSpec{
+		Header: ltx.Header{Version: ltx.Version, PageSize: 4096, Commit: pageN, MinTXID: 1, MaxTXID: 1, Timestamp: 1000},
+	}
+	postApplyChecksum := ltx.ChecksumFlag
+	for pgno := uint32(1); pgno <= pageN; pgno++ {
+		data := make([]byte, 4096)
+		_, _ = rand.Read(data[:1024])
+		spec.Pages = append(spec.Pages, ltx.PageSpec{Header: ltx.PageHeader{Pgno: pgno}, Data: data})
+		postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, data))
+	}
+	spec.Trailer.PostApplyChecksum = postApplyChecksum
+
+	var buf bytes.Buffer
+	writeFileSpec(b, &buf, spec)
+
+	var hdr ltx.PageHeader
+	data := make([]byte, 4096)
+	dec := ltx.NewDecoder(nil)
+
+	b.ReportAllocs()
+	b.SetBytes(pageN * 4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec.Reset(bytes.NewReader(buf.Bytes()))
+		if err := dec.DecodeHeader(); err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if err := dec.DecodePage(&hdr, data); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func mustChecksumReader(tb testing.TB, data []byte) ltx.Checksum {
+	tb.Helper()
+	chksum, err := ltx.ChecksumReader(bytes.NewReader(data), 512)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return chksum
+}
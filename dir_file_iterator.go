@@ -0,0 +1,235 @@
+package ltx
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var _ FileIterator = (*DirFileIterator)(nil)
+
+// DirFileIterator represents an iterator over LTX files stored in a directory.
+//
+// Files directly inside the directory are level zero. Subdirectories named
+// with a decimal integer hold the files for that level, e.g. "1/" and "2/".
+// Files are matched by name so filtering by level & TXID range does not read
+// any file contents. The header & trailer of each file are only read once the
+// iterator moves to that file.
+type DirFileIterator struct {
+	dir  string
+	init bool
+	ents []dirFileEntry
+	item *FileInfo
+	err  error
+
+	// Restricts iteration to a single level. Negative values include all
+	// levels. Defaults to -1.
+	Level int
+
+	// Restricts iteration to files that overlap the transaction range.
+	// Zero values are unbounded.
+	MinTXID TXID
+	MaxTXID TXID
+}
+
+type dirFileEntry struct {
+	path     string
+	level    int
+	timeline uint32
+	minTXID  TXID
+	maxTXID  TXID
+	shardMin uint32
+}
+
+// NewDirFileIterator returns a new instance of DirFileIterator for dir.
+func NewDirFileIterator(dir string) *DirFileIterator {
+	return &DirFileIterator{
+		dir:   dir,
+		Level: -1,
+	}
+}
+
+// Close always returns nil.
+func (itr *DirFileIterator) Close() error { return nil }
+
+// Next moves to the next LTX file. Returns true if another file is available.
+func (itr *DirFileIterator) Next() bool {
+	if itr.err != nil {
+		return false
+	}
+
+	if !itr.init {
+		itr.init = true
+		if itr.ents, itr.err = itr.readEntries(); itr.err != nil {
+			return false
+		}
+	} else if len(itr.ents) > 0 {
+		itr.ents = itr.ents[1:]
+	}
+
+	itr.item = nil
+	if len(itr.ents) == 0 {
+		return false
+	}
+
+	ent := itr.ents[0]
+	if itr.item, itr.err = readFileInfoFile(ent.path); itr.err != nil {
+		itr.err = fmt.Errorf("%s: %w", ent.path, itr.err)
+		return false
+	}
+	itr.item.Level = ent.level
+	return true
+}
+
+// Err returns an error that occurred while reading the directory or a file.
+func (itr *DirFileIterator) Err() error { return itr.err }
+
+// Item returns the metadata for the currently positioned LTX file.
+func (itr *DirFileIterator) Item() *FileInfo { return itr.item }
+
+// Path returns the path of the currently positioned LTX file.
+func (itr *DirFileIterator) Path() string {
+	if itr.item == nil {
+		return ""
+	}
+	return itr.ents[0].path
+}
+
+// readEntries returns a sorted list of all LTX files matching the filters.
+func (itr *DirFileIterator) readEntries() ([]dirFileEntry, error) {
+	ents, err := os.ReadDir(itr.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var a []dirFileEntry
+	for _, ent := range ents {
+		if !ent.IsDir() {
+			a = itr.appendEntry(a, itr.dir, 0, ent.Name())
+			continue
+		}
+
+		level, err := strconv.Atoi(ent.Name())
+		if err != nil || level < 0 || strconv.Itoa(level) != ent.Name() {
+			continue // not a level directory
+		} else if itr.Level >= 0 && level != itr.Level {
+			continue
+		}
+
+		levelDir := filepath.Join(itr.dir, ent.Name())
+		levelEnts, err := os.ReadDir(levelDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, levelEnt := range levelEnts {
+			if !levelEnt.IsDir() {
+				a = itr.appendEntry(a, levelDir, level, levelEnt.Name())
+			}
+		}
+	}
+
+	slices.SortFunc(a, func(x, y dirFileEntry) int {
+		if v := cmp.Compare(x.level, y.level); v != 0 {
+			return v
+		}
+		if v := cmp.Compare(x.minTXID, y.minTXID); v != 0 {
+			return v
+		}
+		if v := cmp.Compare(x.maxTXID, y.maxTXID); v != 0 {
+			return v
+		}
+		if v := cmp.Compare(x.timeline, y.timeline); v != 0 {
+			return v
+		}
+		return cmp.Compare(x.shardMin, y.shardMin)
+	})
+
+	return a, nil
+}
+
+// appendEntry appends the file to a if its name is a valid LTX filename
+// that matches the iterator's filters.
+func (itr *DirFileIterator) appendEntry(a []dirFileEntry, dir string, level int, name string) []dirFileEntry {
+	timeline, minTXID, maxTXID, shardMin, err := ParseShardFilename(name)
+	if err != nil {
+		return a
+	} else if itr.Level >= 0 && level != itr.Level {
+		return a
+	} else if itr.MinTXID != 0 && maxTXID < itr.MinTXID {
+		return a
+	} else if itr.MaxTXID != 0 && minTXID > itr.MaxTXID {
+		return a
+	}
+
+	return append(a, dirFileEntry{
+		path:     filepath.Join(dir, name),
+		level:    level,
+		timeline: timeline,
+		minTXID:  minTXID,
+		maxTXID:  maxTXID,
+		shardMin: shardMin,
+	})
+}
+
+// readFileInfoFile reads the header & trailer of the LTX file at path.
+func readFileInfoFile(path string) (*FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readFileInfoAt(f, fi.Size())
+}
+
+// readFileInfoAt reads the header & trailer of an LTX file of the given size.
+// The returned level is always zero.
+func readFileInfoAt(r io.ReaderAt, size int64) (*FileInfo, error) {
+	if size < HeaderSize+TrailerSize {
+		return nil, ErrInvalidFile
+	}
+
+	b := make([]byte, HeaderSize)
+	var hdr Header
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	} else if err := hdr.UnmarshalBinary(b); err != nil {
+		return nil, fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	var trailer Trailer
+	if _, err := r.ReadAt(b[:TrailerSize], size-TrailerSize); err != nil {
+		return nil, fmt.Errorf("read trailer: %w", err)
+	} else if err := trailer.UnmarshalBinary(b[:TrailerSize]); err != nil {
+		return nil, fmt.Errorf("unmarshal trailer: %w", err)
+	}
+
+	return newFileInfo(hdr, trailer, size), nil
+}
+
+// newFileInfo returns file metadata from an LTX header & trailer.
+func newFileInfo(hdr Header, trailer Trailer, size int64) *FileInfo {
+	return &FileInfo{
+		Timeline:          hdr.Timeline,
+		DatabaseID:        hdr.DatabaseID,
+		MinTXID:           hdr.MinTXID,
+		MaxTXID:           hdr.MaxTXID,
+		PreApplyChecksum:  hdr.PreApplyChecksum,
+		PostApplyChecksum: trailer.PostApplyChecksum,
+		NodeID:            hdr.NodeID,
+		Commit:            hdr.Commit,
+		ShardMinPgno:      hdr.ShardMinPgno,
+		ShardMaxPgno:      hdr.ShardMaxPgno,
+		Size:              size,
+		CreatedAt:         time.UnixMilli(hdr.Timestamp).UTC(),
+	}
+}
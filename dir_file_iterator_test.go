@@ -0,0 +1,144 @@
+package ltx_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+func TestDirFileIterator(t *testing.T) {
+	dir := t.TempDir()
+	writeDirTestFile(t, dir, 0, 1, 1)
+	writeDirTestFile(t, dir, 0, 2, 2)
+	writeDirTestFile(t, dir, 0, 3, 4)
+	writeDirTestFile(t, dir, 1, 1, 2)
+	writeDirTestFile(t, dir, 1, 3, 4)
+	if err := os.WriteFile(filepath.Join(dir, "foo"), nil, 0o666); err != nil {
+		t.Fatal(err)
+	} else if err := os.Mkdir(filepath.Join(dir, "bar"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("All", func(t *testing.T) {
+		itr := ltx.NewDirFileIterator(dir)
+		infos, err := ltx.SliceFileIterator(itr)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := len(infos), 5; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		}
+
+		for i, want := range []struct {
+			level            int
+			minTXID, maxTXID ltx.TXID
+		}{{0, 1, 1}, {0, 2, 2}, {0, 3, 4}, {1, 1, 2}, {1, 3, 4}} {
+			if infos[i].Level != want.level || infos[i].MinTXID != want.minTXID || infos[i].MaxTXID != want.maxTXID {
+				t.Fatalf("%d: unexpected file: level=%d min=%s max=%s", i, infos[i].Level, infos[i].MinTXID, infos[i].MaxTXID)
+			}
+		}
+
+		info := infos[2]
+		if got, want := info.PreApplyChecksum, ltx.ChecksumFlag|2; got != want {
+			t.Fatalf("PreApplyChecksum=%s, want %s", got, want)
+		} else if got, want := info.PostApplyChecksum, ltx.ChecksumFlag|4; got != want {
+			t.Fatalf("PostApplyChecksum=%s, want %s", got, want)
+		} else if got, want := info.CreatedAt, time.UnixMilli(4000).UTC(); !got.Equal(want) {
+			t.Fatalf("CreatedAt=%s, want %s", got, want)
+		} else if fi, err := os.Stat(filepath.Join(dir, ltx.FormatFilename(3, 4))); err != nil {
+			t.Fatal(err)
+		} else if got, want := info.Size, fi.Size(); got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Level", func(t *testing.T) {
+		itr := ltx.NewDirFileIterator(dir)
+		itr.Level = 1
+		if !itr.Next() {
+			t.Fatal("expected file")
+		} else if got, want := itr.Path(), filepath.Join(dir, "1", ltx.FormatFilename(1, 2)); got != want {
+			t.Fatalf("Path=%s, want %s", got, want)
+		} else if !itr.Next() {
+			t.Fatal("expected file")
+		} else if itr.Next() {
+			t.Fatal("expected end of iterator")
+		} else if err := itr.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("TXIDRange", func(t *testing.T) {
+		itr := ltx.NewDirFileIterator(dir)
+		itr.Level, itr.MinTXID, itr.MaxTXID = 0, 2, 3
+		infos, err := ltx.SliceFileIterator(itr)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := len(infos), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if got, want := infos[0].MinTXID, ltx.TXID(2); got != want {
+			t.Fatalf("MinTXID=%s, want %s", got, want)
+		} else if got, want := infos[1].MinTXID, ltx.TXID(3); got != want {
+			t.Fatalf("MinTXID=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ErrInvalidFile", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ltx.FormatFilename(1, 1)), []byte("foo"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		itr := ltx.NewDirFileIterator(dir)
+		if itr.Next() {
+			t.Fatal("expected no file")
+		} else if err := itr.Err(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("ErrDirNotFound", func(t *testing.T) {
+		itr := ltx.NewDirFileIterator(filepath.Join(dir, "missing"))
+		if itr.Next() {
+			t.Fatal("expected no file")
+		} else if !os.IsNotExist(itr.Err()) {
+			t.Fatalf("unexpected error: %v", itr.Err())
+		}
+	})
+}
+
+// writeDirTestFile writes a single page LTX file to its level directory under dir.
+func writeDirTestFile(tb testing.TB, dir string, level int, minTXID, maxTXID ltx.TXID) {
+	tb.Helper()
+
+	if level > 0 {
+		dir = filepath.Join(dir, fmt.Sprint(level))
+		if err := os.MkdirAll(dir, 0o777); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	var preApplyChecksum ltx.Checksum
+	if minTXID > 1 {
+		preApplyChecksum = ltx.ChecksumFlag | ltx.Checksum(minTXID-1)
+	}
+
+	f := createFile(tb, filepath.Join(dir, ltx.FormatFilename(minTXID, maxTXID)))
+	writeFileSpec(tb, f, &ltx.FileSpec{
+		Header: ltx.Header{
+			Version:          ltx.Version,
+			PageSize:         512,
+			Commit:           1,
+			MinTXID:          minTXID,
+			MaxTXID:          maxTXID,
+			Timestamp:        int64(maxTXID) * 1000,
+			PreApplyChecksum: preApplyChecksum,
+		},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{byte(maxTXID)}, 512)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | ltx.Checksum(maxTXID)},
+	})
+}
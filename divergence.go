@@ -0,0 +1,154 @@
+package ltx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Divergence describes the point where two histories of the same database
+// stop agreeing, such as after a split brain between two nodes.
+type Divergence struct {
+	// Last position known to be shared by both histories. This is zero if the
+	// histories share no position. Positions are only known at file
+	// boundaries so the actual divergence may occur after this position.
+	Ancestor Pos
+
+	// First transaction ID where both histories report a position and the
+	// positions differ.
+	TXID TXID
+
+	// Files on each side which contain the first transaction after the
+	// ancestor. The NodeID on each file identifies which node wrote it.
+	A, B *FileInfo
+}
+
+// FindDivergence compares the positions reported by the files in a & b and
+// returns the first point where they differ. Returns nil if the histories do
+// not conflict, such as when one history is a prefix of the other.
+//
+// Each side is first reduced to the history of its latest timeline, see
+// TimelineChain(), so files from abandoned timelines are never compared.
+//
+// Both iterators are closed before returning.
+func FindDivergence(a, b FileIterator) (*Divergence, error) {
+	infosA, err := SliceFileIterator(a)
+	if err != nil {
+		_ = b.Close()
+		return nil, fmt.Errorf("iterate a: %w", err)
+	}
+	infosB, err := SliceFileIterator(b)
+	if err != nil {
+		return nil, fmt.Errorf("iterate b: %w", err)
+	}
+
+	if infosA, err = latestTimelineChain(infosA); err != nil {
+		return nil, fmt.Errorf("a: %w", err)
+	} else if infosB, err = latestTimelineChain(infosB); err != nil {
+		return nil, fmt.Errorf("b: %w", err)
+	}
+
+	posA, posB := filePositions(infosA), filePositions(infosB)
+
+	// Walk over transaction IDs known to both sides in order.
+	txIDs := make([]TXID, 0, len(posA))
+	for txID := range posA {
+		if _, ok := posB[txID]; ok {
+			txIDs = append(txIDs, txID)
+		}
+	}
+	slices.Sort(txIDs)
+
+	var ancestor Pos
+	for _, txID := range txIDs {
+		pa, pb := posA[txID], posB[txID]
+		if posMatches(pa, pb) {
+			ancestor = pa
+			continue
+		}
+
+		return &Divergence{
+			Ancestor: ancestor,
+			TXID:     txID,
+			A:        findContainingFile(infosA, ancestor.TXID+1),
+			B:        findContainingFile(infosB, ancestor.TXID+1),
+		}, nil
+	}
+	return nil, nil
+}
+
+// latestTimelineChain returns the files in a which make up the history of the
+// latest timeline. Returns a unchanged if it is empty.
+func latestTimelineChain(a []*FileInfo) ([]*FileInfo, error) {
+	latest := LatestFileInfo(a)
+	if latest == nil {
+		return a, nil
+	}
+	return TimelineChain(a, latest.Timeline)
+}
+
+// filePositions returns a lookup of all positions reported by a set of files.
+func filePositions(a []*FileInfo) map[TXID]Pos {
+	m := make(map[TXID]Pos, len(a)*2)
+	for _, info := range a {
+		if info.MinTXID > 1 {
+			m[info.MinTXID-1] = info.PreApplyPos()
+		}
+		m[info.MaxTXID] = info.Pos()
+	}
+	return m
+}
+
+// findContainingFile returns the file with the narrowest range containing txID.
+func findContainingFile(a []*FileInfo, txID TXID) *FileInfo {
+	var other *FileInfo
+	for _, info := range a {
+		if txID < info.MinTXID || txID > info.MaxTXID {
+			continue
+		}
+		if other == nil || info.MaxTXID-info.MinTXID < other.MaxTXID-other.MinTXID {
+			other = info
+		}
+	}
+	return other
+}
+
+// WriteRewind writes a snapshot LTX file to w which resets a replica to the
+// common ancestor of the divergence. The readers must contain the shared
+// history starting from a snapshot and ending exactly at the ancestor.
+func (d *Divergence) WriteRewind(ctx context.Context, w io.Writer, rdrs []io.Reader) error {
+	if d.Ancestor.IsZero() {
+		return fmt.Errorf("no common ancestor to rewind to")
+	} else if len(rdrs) == 0 {
+		return fmt.Errorf("at least one input reader required")
+	}
+
+	// Verify inputs cover the history up to the ancestor before writing anything.
+	rdrs = slices.Clone(rdrs)
+	for i := range rdrs {
+		hdr, r, err := PeekHeader(rdrs[i])
+		if err != nil {
+			return fmt.Errorf("peek header %d: %w", i, err)
+		}
+		rdrs[i] = r
+
+		if i == 0 && !hdr.IsSnapshot() {
+			return fmt.Errorf("first input must be a snapshot")
+		} else if i == len(rdrs)-1 && hdr.MaxTXID != d.Ancestor.TXID {
+			return fmt.Errorf("last input must end at ancestor transaction %s, got %s", d.Ancestor.TXID, hdr.MaxTXID)
+		}
+	}
+
+	c, err := NewCompactor(w, rdrs)
+	if err != nil {
+		return err
+	} else if err := c.Compact(ctx); err != nil {
+		return err
+	}
+
+	if pos := c.enc.PostApplyPos(); !posMatches(pos, d.Ancestor) {
+		return NewPosMismatchError(pos)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestFindDivergence(t *testing.T) {
+	shared := []*ltx.FileInfo{
+		{MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1, NodeID: 1},
+		{MinTXID: 2, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 3, NodeID: 1},
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		a := append(shared[:2:2],
+			&ltx.FileInfo{MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0xa4, NodeID: 1},
+			&ltx.FileInfo{MinTXID: 5, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 0xa4, PostApplyChecksum: ltx.ChecksumFlag | 0xa5, NodeID: 1},
+		)
+		b := append(shared[:2:2],
+			&ltx.FileInfo{MinTXID: 4, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0xb5, NodeID: 2},
+		)
+
+		d, err := ltx.FindDivergence(ltx.NewFileInfoSliceIterator(a), ltx.NewFileInfoSliceIterator(b))
+		if err != nil {
+			t.Fatal(err)
+		} else if d == nil {
+			t.Fatal("expected divergence")
+		}
+
+		if got, want := d.Ancestor, ltx.NewPos(3, ltx.ChecksumFlag|3); got != want {
+			t.Fatalf("Ancestor=%s, want %s", got, want)
+		} else if got, want := d.TXID, ltx.TXID(5); got != want {
+			t.Fatalf("TXID=%s, want %s", got, want)
+		} else if got, want := d.A.NodeID, uint64(1); got != want {
+			t.Fatalf("A.NodeID=%d, want %d", got, want)
+		} else if got, want := d.A.MaxTXID, ltx.TXID(4); got != want {
+			t.Fatalf("A.MaxTXID=%s, want %s", got, want)
+		} else if got, want := d.B.NodeID, uint64(2); got != want {
+			t.Fatalf("B.NodeID=%d, want %d", got, want)
+		}
+	})
+
+	// Files after the branch point on a parent timeline are abandoned and
+	// are not compared.
+	t.Run("Timeline", func(t *testing.T) {
+		a := append([]*ltx.FileInfo{
+			{Timeline: 1, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0xb4, NodeID: 2},
+			{Timeline: 1, MinTXID: 5, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 0xb4, PostApplyChecksum: ltx.ChecksumFlag | 0xb5, NodeID: 2},
+		}, append(shared[:2:2],
+			&ltx.FileInfo{MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0xa4, NodeID: 1},
+			&ltx.FileInfo{MinTXID: 5, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 0xa4, PostApplyChecksum: ltx.ChecksumFlag | 0xa5, NodeID: 1},
+		)...)
+		b := append(shared[:2:2],
+			&ltx.FileInfo{MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0xb4, NodeID: 2},
+			&ltx.FileInfo{MinTXID: 5, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 0xb4, PostApplyChecksum: ltx.ChecksumFlag | 0xc5, NodeID: 3},
+		)
+
+		d, err := ltx.FindDivergence(ltx.NewFileInfoSliceIterator(a), ltx.NewFileInfoSliceIterator(b))
+		if err != nil {
+			t.Fatal(err)
+		} else if d == nil {
+			t.Fatal("expected divergence")
+		}
+
+		if got, want := d.Ancestor, ltx.NewPos(4, ltx.ChecksumFlag|0xb4); got != want {
+			t.Fatalf("Ancestor=%s, want %s", got, want)
+		} else if got, want := d.TXID, ltx.TXID(5); got != want {
+			t.Fatalf("TXID=%s, want %s", got, want)
+		} else if got, want := d.A.Timeline, uint32(1); got != want {
+			t.Fatalf("A.Timeline=%d, want %d", got, want)
+		} else if got, want := d.B.NodeID, uint64(3); got != want {
+			t.Fatalf("B.NodeID=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		a := append(shared[:2:2],
+			&ltx.FileInfo{MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 4},
+		)
+		d, err := ltx.FindDivergence(ltx.NewFileInfoSliceIterator(a), ltx.NewFileInfoSliceIterator(shared[:2:2]))
+		if err != nil {
+			t.Fatal(err)
+		} else if d != nil {
+			t.Fatalf("unexpected divergence: %#v", d)
+		}
+	})
+
+	t.Run("NoAncestor", func(t *testing.T) {
+		a := []*ltx.FileInfo{{MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1}}
+		b := []*ltx.FileInfo{{MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 2}}
+		d, err := ltx.FindDivergence(ltx.NewFileInfoSliceIterator(a), ltx.NewFileInfoSliceIterator(b))
+		if err != nil {
+			t.Fatal(err)
+		} else if !d.Ancestor.IsZero() {
+			t.Fatalf("unexpected ancestor: %s", d.Ancestor)
+		} else if got, want := d.TXID, ltx.TXID(1); got != want {
+			t.Fatalf("TXID=%s, want %s", got, want)
+		}
+	})
+}
+
+func TestDivergence_WriteRewind(t *testing.T) {
+	page1 := bytes.Repeat([]byte{0x81}, 512)
+	page2 := bytes.Repeat([]byte{0x82}, 512)
+
+	var snapshot, incr bytes.Buffer
+	writeFileSpec(t, &snapshot, &ltx.FileSpec{
+		Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 1, MinTXID: 1, MaxTXID: 1},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: page1}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumPage(1, page1)},
+	})
+	postApplyChecksum := ltx.ChecksumFlag | (ltx.ChecksumPage(1, page1) ^ ltx.ChecksumPage(2, page2))
+	writeFileSpec(t, &incr, &ltx.FileSpec{
+		Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 2, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumPage(1, page1)},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 2}, Data: page2}},
+		Trailer: ltx.Trailer{PostApplyChecksum: postApplyChecksum},
+	})
+
+	t.Run("OK", func(t *testing.T) {
+		d := &ltx.Divergence{Ancestor: ltx.NewPos(2, postApplyChecksum), TXID: 3}
+
+		var buf bytes.Buffer
+		if err := d.WriteRewind(context.Background(), &buf, []io.Reader{bytes.NewReader(snapshot.Bytes()), bytes.NewReader(incr.Bytes())}); err != nil {
+			t.Fatal(err)
+		}
+
+		spec := readFileSpec(t, &buf)
+		if !spec.Header.IsSnapshot() {
+			t.Fatal("expected snapshot")
+		} else if got, want := spec.Header.MaxTXID, ltx.TXID(2); got != want {
+			t.Fatalf("MaxTXID=%s, want %s", got, want)
+		} else if got, want := len(spec.Pages), 2; got != want {
+			t.Fatalf("len(Pages)=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrPosMismatch", func(t *testing.T) {
+		d := &ltx.Divergence{Ancestor: ltx.NewPos(2, ltx.ChecksumFlag|1), TXID: 3}
+		err := d.WriteRewind(context.Background(), io.Discard, []io.Reader{bytes.NewReader(snapshot.Bytes()), bytes.NewReader(incr.Bytes())})
+		if _, ok := err.(*ltx.PosMismatchError); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNotAtAncestor", func(t *testing.T) {
+		d := &ltx.Divergence{Ancestor: ltx.NewPos(2, postApplyChecksum), TXID: 3}
+		err := d.WriteRewind(context.Background(), io.Discard, []io.Reader{bytes.NewReader(snapshot.Bytes())})
+		if err == nil || err.Error() != `last input must end at ancestor transaction 0000000000000002, got 0000000000000001` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrNoAncestor", func(t *testing.T) {
+		d := &ltx.Divergence{TXID: 1}
+		if err := d.WriteRewind(context.Background(), io.Discard, nil); err == nil || err.Error() != `no common ancestor to rewind to` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
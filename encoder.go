@@ -26,9 +26,38 @@ type Encoder struct {
 	compressor  lz4.Compressor
 	compressBuf []byte
 
+	// Scratch space for page headers & size prefixes.
+	scratch [PageHeaderSize + 4]byte
+
+	// Page of zeros hashed in place of free page data.
+	zero []byte
+
 	// Track how many of each write has occurred to move state.
 	prevPgno     uint32
 	pagesWritten uint32
+
+	// Number of goroutines used to compress pages concurrently. If less than
+	// two, pages are compressed on the caller's goroutine. Frames are always
+	// written in the order pages are encoded so the output is identical in
+	// either mode. Must be set before the first call to EncodePage() and
+	// Close() must be called to release the goroutines.
+	CompressionWorkers int
+
+	// Parallel compression state. At most 2*CompressionWorkers pages are
+	// buffered at any time.
+	jobs    chan *compressJob // submitted to workers
+	pending []*compressJob    // awaiting write, in encoded order
+	free    []*compressJob    // available for reuse
+}
+
+// compressJob represents a single page compressed by a worker goroutine.
+type compressJob struct {
+	hdr  PageHeader
+	data []byte // uncompressed page data
+	buf  []byte // compressed page data
+	n    int
+	err  error
+	done chan struct{}
 }
 
 // NewEncoder returns a new instance of Encoder.
@@ -40,6 +69,20 @@ func NewEncoder(w io.Writer) (*Encoder, error) {
 	}, nil
 }
 
+// Reset discards the encoder's state and switches to writing to w. Buffers
+// are retained so a single encoder can be reused for many files. Any pages
+// still being compressed are discarded.
+func (enc *Encoder) Reset(w io.Writer) {
+	enc.stopCompressionWorkers()
+
+	enc.w = w
+	enc.state = stateHeader
+	enc.header, enc.trailer = Header{}, Trailer{}
+	enc.n = 0
+	enc.prevPgno, enc.pagesWritten = 0, 0
+	clear(enc.index)
+}
+
 // N returns the number of bytes written.
 func (enc *Encoder) N() int64 { return enc.n }
 
@@ -68,23 +111,16 @@ func (enc *Encoder) SetPostApplyChecksum(chksum Checksum) {
 func (enc *Encoder) Close() error {
 	if enc.state == stateClosed {
 		return nil // no-op
-	} else if enc.state != statePage {
+	} else if enc.state == stateAborted {
+		return ErrEncoderAborted
+	} else if enc.state == statePage {
+		if err := enc.closePageBlock(); err != nil {
+			return err
+		}
+	} else if enc.state != stateClose {
 		return fmt.Errorf("cannot close, expected %s", enc.state)
 	}
 
-	// Marshal empty page header to mark end of page block.
-	b0, err := (&PageHeader{}).MarshalBinary()
-	if err != nil {
-		return fmt.Errorf("marshal empty page header: %w", err)
-	} else if _, err := enc.write(b0); err != nil {
-		return fmt.Errorf("write empty page header: %w", err)
-	}
-
-	// Write index to file.
-	if err := enc.encodePageIndex(); err != nil {
-		return fmt.Errorf("write page index: %w", err)
-	}
-
 	// Marshal trailer to bytes.
 	b1, err := enc.trailer.MarshalBinary()
 	if err != nil {
@@ -117,6 +153,60 @@ func (enc *Encoder) Close() error {
 	return nil
 }
 
+// Abort discards the file being encoded. Any pages still being compressed
+// are dropped and all further calls to the encoder return ErrEncoderAborted
+// until it is Reset(). If the underlying writer implements an Abort() method,
+// such as AtomicFile, it is called so the partial output can be discarded.
+//
+// Abort is a no-op if the encoder has already been closed or aborted so it is
+// safe to defer immediately after creating the encoder.
+func (enc *Encoder) Abort() error {
+	if enc.state == stateClosed || enc.state == stateAborted {
+		return nil
+	}
+	enc.stopCompressionWorkers()
+	enc.state = stateAborted
+
+	if w, ok := enc.w.(interface{ Abort() error }); ok {
+		return w.Abort()
+	}
+	return nil
+}
+
+// closePageBlock writes the end of the page block & the page index. The
+// trailer is not written until Close() so that the post-apply checksum can
+// still be set afterward.
+func (enc *Encoder) closePageBlock() error {
+	if enc.state != statePage {
+		return fmt.Errorf("cannot close page block, expected %s", enc.state)
+	}
+
+	// Write any pages still being compressed.
+	for len(enc.pending) > 0 {
+		if err := enc.flushPage(); err != nil {
+			enc.stopCompressionWorkers()
+			return err
+		}
+	}
+	enc.stopCompressionWorkers()
+
+	// Marshal empty page header to mark end of page block.
+	b0, err := (&PageHeader{}).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal empty page header: %w", err)
+	} else if _, err := enc.write(b0); err != nil {
+		return fmt.Errorf("write empty page header: %w", err)
+	}
+
+	// Write index to file.
+	if err := enc.encodePageIndex(); err != nil {
+		return fmt.Errorf("write page index: %w", err)
+	}
+
+	enc.state = stateClose
+	return nil
+}
+
 func (enc *Encoder) encodePageIndex() error {
 	offset := enc.n
 
@@ -160,6 +250,8 @@ func (enc *Encoder) encodePageIndex() error {
 func (enc *Encoder) EncodeHeader(hdr Header) error {
 	if enc.state == stateClosed {
 		return ErrEncoderClosed
+	} else if enc.state == stateAborted {
+		return ErrEncoderAborted
 	} else if enc.state != stateHeader {
 		return fmt.Errorf("cannot encode header frame, expected %s", enc.state)
 	} else if err := hdr.Validate(); err != nil {
@@ -169,7 +261,11 @@ func (enc *Encoder) EncodeHeader(hdr Header) error {
 	enc.header = hdr
 
 	// Initialize hash.
-	enc.hash = crc64.New(crc64.MakeTable(crc64.ISO))
+	if enc.hash == nil {
+		enc.hash = crc64.New(crc64ISOTable)
+	} else {
+		enc.hash.Reset()
+	}
 
 	// Write header to underlying writer.
 	b, err := enc.header.MarshalBinary()
@@ -186,28 +282,50 @@ func (enc *Encoder) EncodeHeader(hdr Header) error {
 }
 
 // EncodePage writes hdr & data to the file's page block.
+//
+// Free pages are written without data and hashed as zeros. A snapshot with
+// free pages is therefore terminal unless the source database's free pages
+// are zeroed, see HeaderFlagFreePages.
 func (enc *Encoder) EncodePage(hdr PageHeader, data []byte) (err error) {
 	if enc.state == stateClosed {
 		return ErrEncoderClosed
+	} else if enc.state == stateAborted {
+		return ErrEncoderAborted
 	} else if enc.state != statePage {
 		return fmt.Errorf("cannot encode page header, expected %s", enc.state)
 	} else if hdr.Pgno > enc.header.Commit {
 		return fmt.Errorf("page number %d out-of-bounds for commit size %d", hdr.Pgno, enc.header.Commit)
 	} else if err := hdr.Validate(); err != nil {
 		return err
-	} else if uint32(len(data)) != enc.header.PageSize {
+	} else if hdr.IsFree() && !enc.header.HasFreePages() {
+		return fmt.Errorf("free page %d requires free pages header flag", hdr.Pgno)
+	} else if uint32(len(data)) != enc.header.PageSize && !(!hdr.HasData() && data == nil) {
 		return fmt.Errorf("invalid page buffer size: %d, expecting %d", len(data), enc.header.PageSize)
 	}
 
+	// Pages of zeros are written without data.
+	if hdr.Flags&PageHeaderFlagZero != 0 && data != nil && !isZeroPage(data) {
+		return fmt.Errorf("zero page flag set for non-zero page data: pgno=%d", hdr.Pgno)
+	} else if hdr.HasData() && isZeroPage(data) {
+		hdr.Flags |= PageHeaderFlagZero
+	}
+
 	lockPgno := LockPgno(enc.header.PageSize)
 	if hdr.Pgno == lockPgno {
 		return fmt.Errorf("cannot encode lock page: pgno=%d", hdr.Pgno)
+	} else if !enc.header.ContainsPgno(hdr.Pgno) {
+		return fmt.Errorf("page number %d outside shard page range (%d,%d)", hdr.Pgno, enc.header.ShardMinPgno, enc.header.ShardMaxPgno)
 	}
 
 	// Snapshots must start with page 1 and include all pages up to the commit size.
+	// Snapshot shards start with the first page of their range instead.
 	// Non-snapshot files can include any pages but they must be in order.
 	if enc.header.IsSnapshot() {
-		if enc.prevPgno == 0 && hdr.Pgno != 1 {
+		if enc.prevPgno == 0 && enc.header.IsShard() {
+			if minPgno := enc.header.ShardMinPgno; hdr.Pgno != minPgno && !(minPgno == lockPgno && hdr.Pgno == minPgno+1) {
+				return fmt.Errorf("snapshot shard must start with page number %d", minPgno)
+			}
+		} else if enc.prevPgno == 0 && hdr.Pgno != 1 {
 			return fmt.Errorf("snapshot transaction file must start with page number 1")
 		}
 
@@ -224,51 +342,186 @@ func (enc *Encoder) EncodePage(hdr PageHeader, data []byte) (err error) {
 		}
 	}
 
-	offset := enc.n
+	if enc.CompressionWorkers > 1 || enc.jobs != nil {
+		return enc.encodePageAsync(hdr, data)
+	}
+
+	// Free & zero pages are written without data so there is nothing to compress.
+	if !hdr.HasData() {
+		if err := enc.writePageFrame(hdr, nil, nil); err != nil {
+			return err
+		}
+		enc.prevPgno = hdr.Pgno
+		return nil
+	}
 
 	// Allocate compression buffer if needed.
-	if enc.compressBuf == nil {
+	if len(enc.compressBuf) < lz4.CompressBlockBound(int(enc.header.PageSize)) {
 		enc.compressBuf = make([]byte, lz4.CompressBlockBound(int(enc.header.PageSize)))
 	}
 
-	// Compress data using LZ4 block compression.
-	n, err := enc.compressor.CompressBlock(data, enc.compressBuf)
+	n, err := compressPage(&enc.compressor, data, enc.compressBuf)
 	if err != nil {
-		return fmt.Errorf("compress page data: %w", err)
+		return err
+	} else if err := enc.writePageFrame(hdr, data, enc.compressBuf[:n]); err != nil {
+		return err
+	}
+	enc.prevPgno = hdr.Pgno
+	return nil
+}
+
+// encodePageAsync submits a copy of the page to the compression workers.
+// If the maximum number of pages are in flight then the oldest page is
+// written first.
+func (enc *Encoder) encodePageAsync(hdr PageHeader, data []byte) error {
+	if enc.jobs == nil {
+		enc.startCompressionWorkers()
+	}
+
+	if len(enc.pending) >= cap(enc.jobs) {
+		if err := enc.flushPage(); err != nil {
+			enc.stopCompressionWorkers()
+			return err
+		}
 	}
-	if n == 0 {
-		return fmt.Errorf("lz4 block compression failed")
+
+	// Discard reusable jobs if the page size changed after a reset.
+	if n := len(enc.free); n > 0 && len(enc.free[n-1].data) != int(enc.header.PageSize) {
+		enc.free = enc.free[:0]
+	}
+
+	var job *compressJob
+	if n := len(enc.free); n > 0 {
+		job, enc.free = enc.free[n-1], enc.free[:n-1]
+	} else {
+		job = &compressJob{
+			data: make([]byte, enc.header.PageSize),
+			buf:  make([]byte, lz4.CompressBlockBound(int(enc.header.PageSize))),
+			done: make(chan struct{}, 1),
+		}
+	}
+	job.hdr = hdr
+	if hdr.HasData() {
+		copy(job.data, data)
+	}
+
+	enc.pending = append(enc.pending, job)
+	enc.jobs <- job
+	enc.prevPgno = hdr.Pgno
+	return nil
+}
+
+// flushPage waits for the oldest pending page to finish compressing and
+// writes its frame.
+func (enc *Encoder) flushPage() error {
+	job := enc.pending[0]
+	<-job.done
+	copy(enc.pending, enc.pending[1:])
+	enc.pending = enc.pending[:len(enc.pending)-1]
+	enc.free = append(enc.free, job)
+
+	if job.err != nil {
+		return job.err
+	}
+	return enc.writePageFrame(job.hdr, job.data, job.buf[:job.n])
+}
+
+func (enc *Encoder) startCompressionWorkers() {
+	n := max(enc.CompressionWorkers, 1)
+	enc.jobs = make(chan *compressJob, 2*n)
+	for i := 0; i < n; i++ {
+		go func(jobs <-chan *compressJob) {
+			var compressor lz4.Compressor
+			for job := range jobs {
+				if !job.hdr.HasData() {
+					job.n, job.err = 0, nil
+				} else {
+					job.n, job.err = compressPage(&compressor, job.data, job.buf)
+				}
+				job.done <- struct{}{}
+			}
+		}(enc.jobs)
+	}
+}
+
+// stopCompressionWorkers discards any pending pages & stops the workers.
+func (enc *Encoder) stopCompressionWorkers() {
+	if enc.jobs == nil {
+		return
+	}
+	for _, job := range enc.pending {
+		<-job.done
+	}
+	enc.free = append(enc.free, enc.pending...)
+	enc.pending = enc.pending[:0]
+
+	close(enc.jobs)
+	enc.jobs = nil
+}
+
+// compressPage compresses data into buf using LZ4 block compression.
+func compressPage(c *lz4.Compressor, data, buf []byte) (int, error) {
+	n, err := c.CompressBlock(data, buf)
+	if err != nil {
+		return 0, fmt.Errorf("compress page data: %w", err)
+	} else if n == 0 {
+		return 0, fmt.Errorf("lz4 block compression failed")
+	}
+	return n, nil
+}
+
+// writePageFrame writes a page header followed by the compressed page data.
+// The checksum is computed over the uncompressed data.
+func (enc *Encoder) writePageFrame(hdr PageHeader, data, compressed []byte) error {
+	offset := enc.n
+
+	// Free & zero pages only write the header but are hashed as a page of
+	// zeros. The size flag may be set if the header came from a decoded frame.
+	if !hdr.HasData() {
+		hdr.Flags &^= PageHeaderFlagSize
+
+		b := enc.scratch[:PageHeaderSize]
+		binary.BigEndian.PutUint32(b[0:], hdr.Pgno)
+		binary.BigEndian.PutUint16(b[4:], hdr.Flags)
+		if _, err := enc.write(b); err != nil {
+			return fmt.Errorf("write page header: %w", err)
+		}
+		_, _ = enc.hash.Write(enc.zeroPage())
+
+		enc.pagesWritten++
+		enc.index[hdr.Pgno] = PageIndexElem{
+			Offset: offset,
+			Size:   enc.n - offset,
+		}
+		return nil
 	}
 
 	// Set flag indicating size field follows the page header (block format).
 	hdr.Flags |= PageHeaderFlagSize
 
-	writeData := enc.compressBuf[:n]
-
 	// Write page header.
-	b, err := hdr.MarshalBinary()
-	if err != nil {
-		return fmt.Errorf("marshal: %w", err)
-	} else if _, err := enc.write(b); err != nil {
+	b := enc.scratch[:PageHeaderSize]
+	binary.BigEndian.PutUint32(b[0:], hdr.Pgno)
+	binary.BigEndian.PutUint16(b[4:], hdr.Flags)
+	if _, err := enc.write(b); err != nil {
 		return fmt.Errorf("write page header: %w", err)
 	}
 
 	// Write data size (4 bytes, big-endian).
-	sizeBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(sizeBuf, uint32(len(writeData)))
+	sizeBuf := enc.scratch[PageHeaderSize:]
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(compressed)))
 	if _, err := enc.write(sizeBuf); err != nil {
 		return fmt.Errorf("write data size: %w", err)
 	}
 
-	// Write page data (compressed or uncompressed).
-	if _, err := enc.w.Write(writeData); err != nil {
+	// Write compressed page data.
+	if _, err := enc.w.Write(compressed); err != nil {
 		return fmt.Errorf("write page data: %w", err)
 	}
 	_, _ = enc.hash.Write(data) // hash the uncompressed data
-	enc.n += int64(len(writeData))
+	enc.n += int64(len(compressed))
 
 	enc.pagesWritten++
-	enc.prevPgno = hdr.Pgno
 	enc.index[hdr.Pgno] = PageIndexElem{
 		Offset: offset,
 		Size:   enc.n - offset,
@@ -277,6 +530,14 @@ func (enc *Encoder) EncodePage(hdr PageHeader, data []byte) (err error) {
 	return nil
 }
 
+// zeroPage returns a page of zeros for the current page size.
+func (enc *Encoder) zeroPage() []byte {
+	if len(enc.zero) != int(enc.header.PageSize) {
+		enc.zero = make([]byte, enc.header.PageSize)
+	}
+	return enc.zero
+}
+
 // write to the uncompressed writer & add to the checksum.
 func (enc *Encoder) write(b []byte) (n int, err error) {
 	n, err = enc.w.Write(b)
@@ -1,6 +1,8 @@
 package ltx_test
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"math/rand"
 	"path/filepath"
@@ -145,6 +147,92 @@ func TestEncode_Close(t *testing.T) {
 	})
 }
 
+func TestEncoder_Abort(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		for _, workers := range []int{0, 4} {
+			w := &abortWriter{}
+			enc, err := ltx.NewEncoder(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc.CompressionWorkers = workers
+			if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 1}); err != nil {
+				t.Fatal(err)
+			} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 1}, make([]byte, 1024)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := enc.Abort(); err != nil {
+				t.Fatal(err)
+			} else if got, want := w.aborted, 1; got != want {
+				t.Fatalf("aborted=%d, want %d", got, want)
+			}
+
+			// Ensure all methods return an error after abort.
+			if err := enc.EncodeHeader(ltx.Header{}); err != ltx.ErrEncoderAborted {
+				t.Fatal(err)
+			} else if err := enc.EncodePage(ltx.PageHeader{}, nil); err != ltx.ErrEncoderAborted {
+				t.Fatal(err)
+			} else if err := enc.Close(); err != ltx.ErrEncoderAborted {
+				t.Fatal(err)
+			}
+
+			// Aborting again is a no-op.
+			if err := enc.Abort(); err != nil {
+				t.Fatal(err)
+			} else if got, want := w.aborted, 1; got != want {
+				t.Fatalf("aborted=%d, want %d", got, want)
+			}
+		}
+	})
+
+	t.Run("AfterClose", func(t *testing.T) {
+		w := &abortWriter{}
+		enc, err := ltx.NewEncoder(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 1}, make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		enc.SetPostApplyChecksum(ltx.ChecksumFlag | ltx.ChecksumPage(1, make([]byte, 1024)))
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := enc.Abort(); err != nil {
+			t.Fatal(err)
+		} else if w.aborted != 0 {
+			t.Fatal("expected closed output to be kept")
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		enc, err := ltx.NewEncoder(&abortWriter{})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := enc.Abort(); err != nil {
+			t.Fatal(err)
+		}
+
+		enc.Reset(&abortWriter{})
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// abortWriter counts the number of times Abort() is called.
+type abortWriter struct {
+	bytes.Buffer
+	aborted int
+}
+
+func (w *abortWriter) Abort() error {
+	w.aborted++
+	return nil
+}
+
 func TestEncode_EncodeHeader(t *testing.T) {
 	t.Run("ErrInvalidState", func(t *testing.T) {
 		enc, err := ltx.NewEncoder(createFile(t, filepath.Join(t.TempDir(), "ltx")))
@@ -195,6 +283,75 @@ func TestEncode_EncodePage(t *testing.T) {
 		}
 	})
 
+	// Pages of zeros are written as a page header with no data.
+	t.Run("ZeroPage", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc, err := ltx.NewEncoder(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		page1, page2 := bytes.Repeat([]byte{1}, 1024), make([]byte, 1024)
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: 1}, page1); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 2}, page2); err != nil {
+			t.Fatal(err)
+		}
+
+		// The post-apply checksum is computed as if the zero data were present.
+		chksum := ltx.ChecksumFlag | ltx.ChecksumPage(1, page1)
+		chksum = ltx.ChecksumFlag | (chksum ^ ltx.ChecksumPage(2, page2))
+		enc.SetPostApplyChecksum(chksum)
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := ltx.NewDecoder(bytes.NewReader(buf.Bytes()))
+		if err := dec.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		elem := dec.PageIndex()[2]
+		if got, want := elem.Size, int64(ltx.PageHeaderSize); got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
+		}
+
+		frame := buf.Bytes()[elem.Offset : elem.Offset+elem.Size]
+		if hdr, data, err := ltx.DecodePageData(frame); err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr, (ltx.PageHeader{Pgno: 2, Flags: ltx.PageHeaderFlagZero}); got != want {
+			t.Fatalf("hdr=%#v, want %#v", got, want)
+		} else if hdr.HasData() || data != nil {
+			t.Fatal("expected no page data")
+		}
+	})
+
+	t.Run("ErrZeroPageData", func(t *testing.T) {
+		enc, err := ltx.NewEncoder(createFile(t, filepath.Join(t.TempDir(), "ltx")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagZero}, bytes.Repeat([]byte{1}, 1024)); err == nil || err.Error() != `zero page flag set for non-zero page data: pgno=1` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("ErrFreePageFlag", func(t *testing.T) {
+		enc, err := ltx.NewEncoder(createFile(t, filepath.Join(t.TempDir(), "ltx")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagFree}, nil); err == nil || err.Error() != `free page 1 requires free pages header flag` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
 	t.Run("ErrSnapshotInitialPage", func(t *testing.T) {
 		enc, err := ltx.NewEncoder(createFile(t, filepath.Join(t.TempDir(), "ltx")))
 		if err != nil {
@@ -284,3 +441,186 @@ func TestEncode_EncodePage(t *testing.T) {
 		}
 	})
 }
+
+func TestEncoder_CompressionWorkers(t *testing.T) {
+	encode := func(tb testing.TB, w io.Writer, workers int) error {
+		tb.Helper()
+
+		enc, err := ltx.NewEncoder(w)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		enc.CompressionWorkers = workers
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagFreePages, PageSize: 1024, Commit: 100, MinTXID: 1, MaxTXID: 1, Timestamp: 1000}); err != nil {
+			tb.Fatal(err)
+		}
+
+		// Reuse a single buffer so pages must be copied before compression.
+		rnd := rand.New(rand.NewSource(0))
+		buf := make([]byte, 1024)
+		postApplyChecksum := ltx.ChecksumFlag
+		for pgno := uint32(1); pgno <= 100; pgno++ {
+			// Mix in free pages which are written without compression.
+			if pgno%7 == 0 {
+				if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno, Flags: ltx.PageHeaderFlagFree}, nil); err != nil {
+					return err
+				}
+				postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, make([]byte, 1024)))
+				continue
+			}
+
+			rnd.Read(buf[:rnd.Intn(len(buf))]) // vary compressibility
+			if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, buf); err != nil {
+				return err
+			}
+			postApplyChecksum = ltx.ChecksumFlag | (postApplyChecksum ^ ltx.ChecksumPage(pgno, buf))
+		}
+		enc.SetPostApplyChecksum(postApplyChecksum)
+		return enc.Close()
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		var want bytes.Buffer
+		if err := encode(t, &want, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{1, 2, 4, 16} {
+			var got bytes.Buffer
+			if err := encode(t, &got, workers); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Fatalf("workers=%d: output mismatch", workers)
+			}
+		}
+
+		if err := ltx.NewDecoder(&want).Verify(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrWrite", func(t *testing.T) {
+		errMarker := errors.New("marker")
+		if err := encode(t, &errWriter{n: 10000, err: errMarker}, 4); !errors.Is(err, errMarker) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// errWriter returns err once n bytes have been written.
+type errWriter struct {
+	n   int
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEncoder_Reset(t *testing.T) {
+	encode := func(enc *ltx.Encoder, pageSize uint32) {
+		t.Helper()
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: pageSize, Commit: 2, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: ltx.ChecksumFlag | 1}); err != nil {
+			t.Fatal(err)
+		} else if err := enc.EncodePage(ltx.PageHeader{Pgno: 2}, bytes.Repeat([]byte{2}, int(pageSize))); err != nil {
+			t.Fatal(err)
+		}
+		enc.SetPostApplyChecksum(ltx.ChecksumFlag | 2)
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{0, 4} {
+		var buf0, buf1, buf2 bytes.Buffer
+		enc, err := ltx.NewEncoder(&buf0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.CompressionWorkers = workers
+		encode(enc, 4096)
+
+		// Reuse the encoder for a file with a different page size.
+		enc.Reset(&buf1)
+		encode(enc, 1024)
+
+		enc.Reset(&buf2)
+		encode(enc, 4096)
+
+		if !bytes.Equal(buf0.Bytes(), buf2.Bytes()) {
+			t.Fatalf("workers=%d: output mismatch after reset", workers)
+		} else if err := ltx.NewDecoder(&buf1).Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncoder_EncodePage_Allocs(t *testing.T) {
+	const pageN = 2000
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(0)).Read(data[:1024])
+
+	enc, err := ltx.NewEncoder(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encode a file first so the encoder's buffers have reached their
+	// steady-state size before measuring.
+	var pgno uint32
+	encodeHeader := func() {
+		pgno = 0
+		if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 4096, Commit: pageN, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encodePage := func() {
+		pgno++
+		if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encodeHeader()
+	for i := 0; i < pageN; i++ {
+		encodePage()
+	}
+	enc.SetPostApplyChecksum(ltx.ChecksumFlag | 2)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	enc.Reset(io.Discard)
+	encodeHeader()
+	if n := testing.AllocsPerRun(pageN/2, encodePage); n != 0 {
+		t.Fatalf("allocs per page: %v", n)
+	}
+}
+
+func BenchmarkEncoder_EncodePage(b *testing.B) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(0)).Read(data[:1024])
+
+	enc, err := ltx.NewEncoder(io.Discard)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := enc.EncodeHeader(ltx.Header{Version: ltx.Version, PageSize: 4096, Commit: uint32(b.N) + 1, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if pgno := uint32(i) + 1; pgno != ltx.LockPgno(4096) {
+			if err := enc.EncodePage(ltx.PageHeader{Pgno: pgno}, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package ltx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var _ Store = (*FileStore)(nil)
+
+// FileStore represents an implementation of Store that keeps LTX files on the
+// local file system using the same layout as DirFileIterator. Level zero files
+// are stored in the root directory and higher levels are stored in
+// subdirectories named by the level number, e.g. "1/" and "2/".
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a new instance of FileStore rooted at dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Path returns the root directory of the store.
+func (s *FileStore) Path() string { return s.dir }
+
+// LevelDir returns the directory holding files for level.
+func (s *FileStore) LevelDir(level int) string {
+	if level == 0 {
+		return s.dir
+	}
+	return filepath.Join(s.dir, strconv.Itoa(level))
+}
+
+// FilePath returns the path to the file identified by info.
+func (s *FileStore) FilePath(info *FileInfo) string {
+	return filepath.Join(s.LevelDir(info.Level), FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno))
+}
+
+// Open returns a reader for the LTX file identified by info.
+func (s *FileStore) Open(ctx context.Context, info *FileInfo) (io.ReadCloser, error) {
+	return os.Open(s.FilePath(info))
+}
+
+// Write verifies the LTX file read from r and atomically stores it at level.
+// The file is written to a temporary file and renamed once it is verified.
+func (s *FileStore) Write(ctx context.Context, level int, r io.Reader) (*FileInfo, error) {
+	levelDir := s.LevelDir(level)
+	if err := os.MkdirAll(levelDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// The filename is derived from the header so it is read before the file is
+	// created. The header is verified along with the rest of the file.
+	hdr, r, err := PeekHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := CreateAtomicFile(s.FilePath(&FileInfo{
+		Level:        level,
+		Timeline:     hdr.Timeline,
+		MinTXID:      hdr.MinTXID,
+		MaxTXID:      hdr.MaxTXID,
+		ShardMinPgno: hdr.ShardMinPgno,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Abort() }()
+
+	info, err := verifyFile(f, r)
+	if err != nil {
+		return nil, err
+	}
+	info.Level = level
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Delete removes the LTX file identified by info.
+func (s *FileStore) Delete(ctx context.Context, info *FileInfo) error {
+	if err := os.Remove(s.FilePath(info)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns an iterator over files at level which overlap the TXID range.
+func (s *FileStore) List(ctx context.Context, level int, minTXID, maxTXID TXID) (FileIterator, error) {
+	if _, err := os.Stat(s.dir); errors.Is(err, fs.ErrNotExist) {
+		return NewFileInfoSliceIterator(nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	itr := NewDirFileIterator(s.dir)
+	itr.Level, itr.MinTXID, itr.MaxTXID = level, minTXID, maxTXID
+	return itr, nil
+}
+
+// syncDir fsyncs a directory so that a rename within it is durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %w", err)
+	}
+	return f.Close()
+}
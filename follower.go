@@ -0,0 +1,279 @@
+package ltx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFollowerPollInterval is the default interval between checks of the
+// store for new files.
+const DefaultFollowerPollInterval = 1 * time.Second
+
+// DefaultFollowerMaxRetryInterval is the default maximum interval between
+// retries of a failed sync.
+const DefaultFollowerMaxRetryInterval = 1 * time.Minute
+
+// Follower continuously applies the LTX files in a store to a local SQLite
+// database file. Its position is persisted to a file next to the database,
+// see PosPath(), so it can resume after restarting.
+//
+// Files are applied with an Applier which, by default, holds SQLite-compatible
+// locks so the database can be read by SQLite while following. A crash while applying a
+// file is rolled back when the follower is next opened. If the position is unknown,
+// or the database does not match it, the database is restored from the latest
+// snapshot in the store.
+type Follower struct {
+	mu      sync.Mutex
+	store   Store
+	path    string
+	f       *os.File
+	applier *Applier
+	pos     Pos
+	listed  bool // true once the whole store has been listed from pos
+
+	// Database lineage that applied files must belong to. If zero, the
+	// lineage persisted with the position or of the first applied file is used.
+	// Files in the store from other lineages are ignored.
+	DatabaseID DatabaseID
+
+	// If true, files are applied while holding SQLite-compatible locks. See
+	// Applier.Locking. Defaults to LockingSupported.
+	Locking bool
+
+	// How often the store is checked for new files by Run().
+	PollInterval time.Duration
+
+	// Maximum interval between retries of a failed sync by Run(). The
+	// interval doubles from PollInterval after each consecutive failure.
+	MaxRetryInterval time.Duration
+
+	// If set, called after the database reaches each new position.
+	OnApply func(pos Pos)
+
+	// If set, called when a sync by Run() fails.
+	OnError func(err error)
+}
+
+// NewFollower returns a new instance of Follower which applies the files in
+// store to the database at path.
+func NewFollower(store Store, path string) *Follower {
+	return &Follower{
+		store:            store,
+		path:             path,
+		Locking:          LockingSupported,
+		PollInterval:     DefaultFollowerPollInterval,
+		MaxRetryInterval: DefaultFollowerMaxRetryInterval,
+	}
+}
+
+// Path returns the path of the database file.
+func (f *Follower) Path() string { return f.path }
+
+// PosPath returns the path of the file which holds the database position.
+func (f *Follower) PosPath() string { return PosPath(f.path) }
+
+// Pos returns the current position of the database.
+func (f *Follower) Pos() Pos {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pos
+}
+
+// Open opens the database file, creating it if it does not exist, rolls back
+// any interrupted apply and reads the persisted position & database ID. The
+// position is zero if there is no position file.
+func (f *Follower) Open() (err error) {
+	if f.f, err = os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0o666); err != nil {
+		return err
+	}
+	f.applier = NewApplier(f.f)
+	f.applier.Locking = f.Locking
+	if _, err := f.applier.Recover(); err != nil {
+		return err
+	}
+
+	pos, id, err := readPosFile(f.PosPath())
+	if err != nil {
+		return err
+	} else if err := CheckDatabaseID(f.DatabaseID, id); err != nil {
+		return err
+	}
+	f.applier.DatabaseID = f.DatabaseID
+	if f.applier.DatabaseID.IsZero() {
+		f.applier.DatabaseID = id
+	}
+	f.setPos(pos)
+	return nil
+}
+
+// Close closes the database file.
+func (f *Follower) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
+
+// Run calls Sync() every PollInterval until ctx is done. Failed syncs are
+// reported to OnError and retried with an exponential backoff.
+func (f *Follower) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for interval := f.PollInterval; ; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if err := f.Sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			} else if f.OnError != nil {
+				f.OnError(err)
+			}
+			interval = min(2*interval, max(f.MaxRetryInterval, f.PollInterval))
+		} else {
+			interval = f.PollInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// Sync applies the files which move the database from its current position
+// to the latest position in the store. If the current position is not part of
+// the store's history then the database is restored from the latest snapshot.
+//
+// If a file fails to apply then the database is rolled back and the position
+// is reset so the next call to Sync() restores the database from a snapshot.
+// This occurs if the database was modified by another process or if the
+// process crashed after applying a file but before persisting its position.
+//
+// The whole store is listed by the first sync. Later syncs only list files
+// which end after the current position so polling an unchanged store does not
+// read every file. The whole store is listed again if those files do not lead
+// on from the current position so a new timeline is picked up once it passes
+// the current position.
+func (f *Follower) Sync(ctx context.Context) error {
+	var path []*FileInfo
+	if pos := f.Pos(); f.listed && !pos.IsZero() {
+		infos, err := f.list(ctx, pos.TXID+1)
+		if err != nil {
+			return err
+		}
+
+		latest := LatestFileInfo(infos)
+		if latest == nil {
+			return nil
+		}
+		if path, err = ApplyPath(infos, pos, latest.Pos()); err != nil && !errors.Is(err, ErrNoRestorePath) {
+			return err
+		}
+	}
+
+	if path == nil {
+		infos, err := f.list(ctx, 0)
+		if err != nil {
+			return err
+		}
+
+		latest := LatestFileInfo(infos)
+		if latest == nil {
+			return nil
+		}
+		if path, err = ApplyPath(infos, f.Pos(), latest.Pos()); errors.Is(err, ErrNoRestorePath) {
+			path, err = RestorePath(infos, latest.Pos())
+		}
+		if err != nil {
+			return err
+		}
+		f.listed = true
+	}
+
+	for _, info := range path {
+		if err := f.applyFile(ctx, info); err != nil {
+			err = fmt.Errorf("apply %s: %w", FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), err)
+			if e := f.reset(); e != nil {
+				return fmt.Errorf("%w (reset: %s)", err, e)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns the complete shard sets of the follower's database in the
+// store which end at or after minTXID.
+func (f *Follower) list(ctx context.Context, minTXID TXID) ([]*FileInfo, error) {
+	itr, err := f.store.List(ctx, -1, minTXID, 0)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ignore shard sets which are still being written & files which belong
+	// to another database.
+	var complete []*FileInfo
+	for _, g := range groupShards(infos) {
+		if CheckDatabaseID(f.applier.DatabaseID, g[0].DatabaseID) == nil {
+			complete = append(complete, g...)
+		}
+	}
+	return complete, nil
+}
+
+// applyFile applies a single file from the store to the database and persists
+// the new position once the database is consistent.
+func (f *Follower) applyFile(ctx context.Context, info *FileInfo) error {
+	rc, err := f.store.Open(ctx, info)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	hdr, trailer, err := f.applier.Apply(rc)
+	if err != nil {
+		return err
+	} else if f.applier.PendingShard() != nil {
+		return nil
+	}
+
+	pos := Pos{TXID: hdr.MaxTXID, PostApplyChecksum: trailer.PostApplyChecksum}
+	if err := writePosFile(f.PosPath(), pos, f.applier.DatabaseID); err != nil {
+		return err
+	}
+	f.setPos(pos)
+
+	if f.OnApply != nil {
+		f.OnApply(pos)
+	}
+	return nil
+}
+
+// reset rolls back a partially applied shard set and durably resets the
+// position so the database is restored from a snapshot. The database ID is
+// kept so the database is only restored from files of the same lineage.
+func (f *Follower) reset() error {
+	f.setPos(Pos{})
+	f.listed = false
+	if err := f.applier.Rollback(); err != nil {
+		return err
+	} else if !f.applier.DatabaseID.IsZero() {
+		return writePosFile(f.PosPath(), Pos{}, f.applier.DatabaseID)
+	}
+	return removePosFile(f.PosPath())
+}
+
+func (f *Follower) setPos(pos Pos) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pos = pos
+}
@@ -0,0 +1,372 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestFollower_Sync(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		s := newFollowerTestStore(t)
+		path := filepath.Join(t.TempDir(), "db")
+
+		f := openFollower(t, s, path)
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 4)
+
+		// Only new files are applied on subsequent syncs.
+		writeStoreFile(t, s, 0, bytes.NewReader(ltxtest.EncodeFile(t, 0, 5, 5)))
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 5)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Position is restored when reopened.
+		f = openFollower(t, s, path)
+		if got, want := f.Pos(), ltx.NewPos(5, ltxtest.FileChecksum(5)); got != want {
+			t.Fatalf("pos=%s, want %s", got, want)
+		} else if buf, err := os.ReadFile(f.PosPath()); err != nil {
+			t.Fatal(err)
+		} else if got, want := string(buf), want.String()+"\n"; got != want {
+			t.Fatalf("pos file=%q, want %q", got, want)
+		}
+	})
+
+	// A missing position file means the previous process crashed while
+	// applying a file so the database is restored from a snapshot.
+	t.Run("RecoverAfterCrash", func(t *testing.T) {
+		s := newFollowerTestStore(t)
+		path := filepath.Join(t.TempDir(), "db")
+
+		f := openFollower(t, s, path)
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, bytes.Repeat([]byte{0xff}, 1024), 0o666); err != nil {
+			t.Fatal(err)
+		} else if err := os.Remove(path + "-pos"); err != nil {
+			t.Fatal(err)
+		}
+
+		f = openFollower(t, s, path)
+		if !f.Pos().IsZero() {
+			t.Fatalf("unexpected pos: %s", f.Pos())
+		} else if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 4)
+	})
+
+	// A position outside the store's history is reset from a snapshot.
+	t.Run("ResetFromSnapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db")
+
+		f := openFollower(t, newFollowerTestStore(t), path)
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		s := ltx.NewMemStore()
+		writeStoreFile(t, s, 1, bytes.NewReader(ltxtest.EncodeFile(t, 1, 1, 3)))
+		f = openFollower(t, s, path)
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 3)
+	})
+
+	// The database ID is persisted & files from other databases are ignored.
+	t.Run("DatabaseID", func(t *testing.T) {
+		s := newFollowerTestStore(t)
+		var buf bytes.Buffer
+		if _, err := (&ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 512, Commit: 1, MinTXID: 5, MaxTXID: 5, Timestamp: 5000, PreApplyChecksum: ltxtest.FileChecksum(4), DatabaseID: ltx.DatabaseID{2}},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: bytes.Repeat([]byte{5}, 512)}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltxtest.FileChecksum(5)},
+		}).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		writeStoreFile(t, s, 0, bytes.NewReader(buf.Bytes()))
+
+		path := filepath.Join(t.TempDir(), "db")
+		f := ltx.NewFollower(s, path)
+		f.DatabaseID = ltx.DatabaseID{1}
+		if err := f.Open(); err != nil {
+			t.Fatal(err)
+		} else if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 4)
+
+		f = ltx.NewFollower(s, path)
+		f.DatabaseID = ltx.DatabaseID{2}
+		if err := f.Open(); !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// After the first sync, only files after the position are read.
+	t.Run("ListNewFiles", func(t *testing.T) {
+		s := &followerTestStore{Store: newFollowerTestStore(t)}
+		f := openFollower(t, s, filepath.Join(t.TempDir(), "db"))
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := s.listed, 3; got != want {
+			t.Fatalf("listed=%d, want %d", got, want)
+		}
+
+		s.listed = 0
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := s.listed, 0; got != want {
+			t.Fatalf("listed=%d, want %d", got, want)
+		}
+
+		writeStoreFile(t, s, 0, bytes.NewReader(ltxtest.EncodeFile(t, 0, 5, 5)))
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := s.listed, 1; got != want {
+			t.Fatalf("listed=%d, want %d", got, want)
+		}
+		assertFollowerDB(t, f, 5)
+	})
+
+	// A snapshot with free pages omitted is terminal. Incremental files from
+	// the source database cannot follow it so the database stays at the
+	// snapshot until a new snapshot is written.
+	t.Run("FreePagesSnapshot", func(t *testing.T) {
+		img, zeroed := newTestFreelistImage(t)
+		snapshot := img.Clone()
+		snapshot.OmitFreePages = true
+
+		var buf bytes.Buffer
+		s := ltx.NewMemStore()
+		if err := snapshot.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 3, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+		writeStoreFile(t, s, 0, &buf)
+
+		f := openFollower(t, s, filepath.Join(t.TempDir(), "db"))
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := f.Pos(), ltx.NewPos(3, zeroed.Checksum()); got != want {
+			t.Fatalf("pos=%s, want %s", got, want)
+		}
+
+		next := img.Clone()
+		if err := next.SetPage(4, bytes.Repeat([]byte{0xff}, 1024)); err != nil {
+			t.Fatal(err)
+		} else if err := next.EncodeDiff(&buf, img, ltx.Header{Version: ltx.Version, MinTXID: 4, MaxTXID: 4, Timestamp: 2000}); err != nil {
+			t.Fatal(err)
+		}
+		writeStoreFile(t, s, 0, &buf)
+
+		if err := f.Sync(context.Background()); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := f.Pos(), ltx.NewPos(3, zeroed.Checksum()); got != want {
+			t.Fatalf("pos=%s, want %s", got, want)
+		}
+
+		// A new snapshot of the source database restores the follower.
+		if err := next.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 4, Timestamp: 2000}); err != nil {
+			t.Fatal(err)
+		}
+		writeStoreFile(t, s, 1, &buf)
+
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got, want := f.Pos(), ltx.NewPos(4, next.Checksum()); got != want {
+			t.Fatalf("pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("IncompleteShardSet", func(t *testing.T) {
+		s := newFollowerTestStore(t)
+		data := ltxtest.EncodeFile(t, 0, 5, 5)
+		hdr, _, _ := ltx.PeekHeader(bytes.NewReader(data))
+		hdr.Flags |= ltx.HeaderFlagShard
+		hdr.ShardMinPgno, hdr.ShardMaxPgno = 1, 1
+		hdr.Commit = 2
+		writeStoreFile(t, s, 0, encodeFollowerShard(t, hdr))
+
+		f := openFollower(t, s, filepath.Join(t.TempDir(), "db"))
+		if err := f.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertFollowerDB(t, f, 4)
+	})
+}
+
+func TestFollower_Run(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		s := newFollowerTestStore(t)
+		f := openFollower(t, s, filepath.Join(t.TempDir(), "db"))
+		f.PollInterval = 10 * time.Millisecond
+
+		applied := make(chan ltx.Pos, 10)
+		f.OnApply = func(pos ltx.Pos) { applied <- pos }
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- f.Run(ctx) }()
+
+		waitFollowerApplied(t, applied, 2)
+		waitFollowerApplied(t, applied, 3)
+		waitFollowerApplied(t, applied, 4)
+
+		writeStoreFile(t, s, 0, bytes.NewReader(ltxtest.EncodeFile(t, 0, 5, 5)))
+		waitFollowerApplied(t, applied, 5)
+
+		cancel()
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Failed syncs are reported & retried until they succeed.
+	t.Run("Retry", func(t *testing.T) {
+		s := &followerTestStore{Store: newFollowerTestStore(t), listErrN: 2}
+		f := openFollower(t, s, filepath.Join(t.TempDir(), "db"))
+		f.PollInterval = 10 * time.Millisecond
+
+		applied := make(chan ltx.Pos, 10)
+		f.OnApply = func(pos ltx.Pos) { applied <- pos }
+		errs := make(chan error, 10)
+		f.OnError = func(err error) { errs <- err }
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- f.Run(ctx) }()
+
+		waitFollowerApplied(t, applied, 2)
+		waitFollowerApplied(t, applied, 3)
+		waitFollowerApplied(t, applied, 4)
+
+		cancel()
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		close(errs)
+
+		var n int
+		for err := range errs {
+			if err.Error() != "marker" {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			n++
+		}
+		if got, want := n, 2; got != want {
+			t.Fatalf("errors=%d, want %d", got, want)
+		}
+	})
+}
+
+// waitFollowerApplied waits for the next position sent on applied & verifies
+// it is at txID.
+func waitFollowerApplied(tb testing.TB, applied <-chan ltx.Pos, txID ltx.TXID) {
+	tb.Helper()
+	select {
+	case pos := <-applied:
+		if got, want := pos, ltx.NewPos(txID, ltxtest.FileChecksum(txID)); got != want {
+			tb.Fatalf("pos=%s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		tb.Fatal("timeout")
+	}
+}
+
+// followerTestStore wraps a store to count the files listed & to fail the
+// first listErrN calls to List().
+type followerTestStore struct {
+	ltx.Store
+	listed   int
+	listErrN int
+}
+
+func (s *followerTestStore) List(ctx context.Context, level int, minTXID, maxTXID ltx.TXID) (ltx.FileIterator, error) {
+	if s.listErrN > 0 {
+		s.listErrN--
+		return nil, errors.New("marker")
+	}
+
+	itr, err := s.Store.List(ctx, level, minTXID, maxTXID)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ltx.SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	}
+	s.listed += len(infos)
+	return ltx.NewFileInfoSliceIterator(infos), nil
+}
+
+// newFollowerTestStore returns a store with a snapshot through TXID 2 and
+// single transaction files for TXIDs 3 & 4.
+func newFollowerTestStore(tb testing.TB) ltx.Store {
+	s := ltx.NewMemStore()
+	writeStoreFile(tb, s, 1, bytes.NewReader(ltxtest.EncodeFile(tb, 0, 1, 2)))
+	writeStoreFile(tb, s, 0, bytes.NewReader(ltxtest.EncodeFile(tb, 0, 3, 3)))
+	writeStoreFile(tb, s, 0, bytes.NewReader(ltxtest.EncodeFile(tb, 0, 4, 4)))
+	return s
+}
+
+func openFollower(tb testing.TB, s ltx.Store, path string) *ltx.Follower {
+	tb.Helper()
+	f := ltx.NewFollower(s, path)
+	if err := f.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+// assertFollowerDB verifies the follower & its database are at txID.
+func assertFollowerDB(tb testing.TB, f *ltx.Follower, txID ltx.TXID) {
+	tb.Helper()
+	if got, want := f.Pos(), ltx.NewPos(txID, ltxtest.FileChecksum(txID)); got != want {
+		tb.Fatalf("pos=%s, want %s", got, want)
+	}
+	if buf, err := os.ReadFile(f.Path()); err != nil {
+		tb.Fatal(err)
+	} else if !bytes.Equal(buf, bytes.Repeat([]byte{byte(txID)}, 512)) {
+		tb.Fatalf("database mismatch at txid %s", txID)
+	}
+}
+
+// encodeFollowerShard encodes a shard with a single page for hdr.
+func encodeFollowerShard(tb testing.TB, hdr ltx.Header) *bytes.Reader {
+	tb.Helper()
+	var buf bytes.Buffer
+	spec := &ltx.FileSpec{
+		Header:  hdr,
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: make([]byte, 512)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag},
+	}
+	if _, err := spec.WriteTo(&buf); err != nil {
+		tb.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
@@ -0,0 +1,66 @@
+package ltx
+
+import (
+	"fmt"
+
+	"github.com/superfly/ltx/internal/sqlitepage"
+)
+
+// ReadFreelist returns the page numbers of the leaf pages on the freelist of
+// the SQLite database with the given page size & commit. The readPage function
+// is called to read page 1 & each freelist trunk page into data.
+//
+// Leaf pages hold no content so they can be written as free page frames. See
+// PageHeaderFlagFree. Trunk pages hold the freelist itself so they are not
+// included. Returns an error if the freelist is malformed.
+func ReadFreelist(pageSize, commit uint32, readPage func(pgno uint32, data []byte) error) (map[uint32]struct{}, error) {
+	data := make([]byte, pageSize)
+	if err := readPage(1, data); err != nil {
+		return nil, fmt.Errorf("read page 1: %w", err)
+	}
+	hdr, err := sqlitepage.ParseDatabaseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	trunk, total := hdr.FreelistTrunk, hdr.FreelistN
+	usableSize := pageSize - uint32(hdr.ReservedSize)
+
+	free := make(map[uint32]struct{})
+	lockPgno := LockPgno(pageSize)
+	isValidPgno := func(pgno uint32) bool {
+		return pgno > 1 && pgno <= commit && pgno != lockPgno
+	}
+
+	for n := uint32(0); trunk != 0; {
+		if !isValidPgno(trunk) {
+			return nil, fmt.Errorf("invalid freelist trunk page: %d", trunk)
+		} else if n++; n > total {
+			return nil, fmt.Errorf("freelist exceeds page count %d", total)
+		}
+
+		if err := readPage(trunk, data); err != nil {
+			return nil, fmt.Errorf("read freelist trunk page %d: %w", trunk, err)
+		}
+
+		t, err := sqlitepage.ParseFreelistTrunk(data, usableSize)
+		if err != nil {
+			return nil, fmt.Errorf("freelist trunk page %d: %w", trunk, err)
+		} else if n += uint32(len(t.Leaves)); n > total {
+			return nil, fmt.Errorf("freelist exceeds page count %d", total)
+		}
+
+		for _, pgno := range t.Leaves {
+			if !isValidPgno(pgno) {
+				return nil, fmt.Errorf("invalid freelist leaf page on trunk page %d: %d", trunk, pgno)
+			} else if _, ok := free[pgno]; ok {
+				return nil, fmt.Errorf("duplicate freelist leaf page: %d", pgno)
+			}
+			free[pgno] = struct{}{}
+		}
+
+		trunk = t.Next
+	}
+
+	return free, nil
+}
@@ -0,0 +1,73 @@
+package ltx_test
+
+import (
+	"encoding/binary"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestReadFreelist(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		img, _ := newTestFreelistImage(t)
+		free, err := ltx.ReadFreelist(img.PageSize(), img.Commit(), readTestImagePage(img))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := slices.Sorted(maps.Keys(free)), []uint32{2, 5}; !slices.Equal(got, want) {
+			t.Fatalf("free=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		img, _ := newTestFreelistImage(t)
+		setTestImageUint32(t, img, 1, 32, 0)
+		if free, err := ltx.ReadFreelist(img.PageSize(), img.Commit(), readTestImagePage(img)); err != nil {
+			t.Fatal(err)
+		} else if len(free) != 0 {
+			t.Fatalf("free=%v, want none", free)
+		}
+	})
+
+	t.Run("ErrInvalidHeader", func(t *testing.T) {
+		img := newTestImage(t, 1024, 2)
+		if _, err := ltx.ReadFreelist(img.PageSize(), img.Commit(), readTestImagePage(img)); err == nil || err.Error() != `invalid database header` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrInvalidLeaf", func(t *testing.T) {
+		img, _ := newTestFreelistImage(t)
+		setTestImageUint32(t, img, 3, 12, 7)
+		if _, err := ltx.ReadFreelist(img.PageSize(), img.Commit(), readTestImagePage(img)); err == nil || err.Error() != `invalid freelist leaf page on trunk page 3: 7` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrLoop", func(t *testing.T) {
+		img, _ := newTestFreelistImage(t)
+		setTestImageUint32(t, img, 3, 0, 3)
+		if _, err := ltx.ReadFreelist(img.PageSize(), img.Commit(), readTestImagePage(img)); err == nil || err.Error() != `freelist exceeds page count 3` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// readTestImagePage returns a function which reads pages from img.
+func readTestImagePage(img *ltx.Image) func(pgno uint32, data []byte) error {
+	return func(pgno uint32, data []byte) error {
+		copy(data, img.Page(pgno))
+		return nil
+	}
+}
+
+// setTestImageUint32 writes v at offset within a page of img.
+func setTestImageUint32(tb testing.TB, img *ltx.Image, pgno uint32, offset int, v uint32) {
+	tb.Helper()
+	page := append([]byte(nil), img.Page(pgno)...)
+	binary.BigEndian.PutUint32(page[offset:], v)
+	if err := img.SetPage(pgno, page); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,436 @@
+package ltx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Image represents an in-memory SQLite database image stored as a sparse map
+// of page numbers to page data. Pages within the commit size that have not
+// been set are treated as zero-filled. The lock page is never stored.
+type Image struct {
+	pageSize uint32
+	commit   uint32
+	pages    map[uint32][]byte
+	id       DatabaseID
+
+	// Header of the last applied shard & the state of the image before the
+	// first shard if a shard set is partially applied.
+	shard *Header
+	undo  *imageUndo
+
+	// True if the last applied snapshot omitted free pages.
+	freePages bool
+
+	// If true, EncodeSnapshot() writes SQLite freelist leaf pages as free
+	// page frames. The post-apply checksum is then computed with those pages
+	// zeroed, which matches the database once the snapshot is applied but
+	// not the image itself unless its free pages are already zeroed. Such a
+	// snapshot is terminal as files encoded from the image cannot follow it.
+	OmitFreePages bool
+}
+
+// NewImage returns a new, empty database image. If pageSize is zero then the
+// page size is set by the first LTX file applied to the image.
+func NewImage(pageSize uint32) *Image {
+	return &Image{
+		pageSize: pageSize,
+		pages:    make(map[uint32][]byte),
+	}
+}
+
+// PageSize returns the size of each page, in bytes.
+func (img *Image) PageSize() uint32 { return img.pageSize }
+
+// DatabaseID returns the database ID of the files applied to the image, if set.
+func (img *Image) DatabaseID() DatabaseID { return img.id }
+
+// Commit returns the size of the database, in pages.
+func (img *Image) Commit() uint32 { return img.commit }
+
+// Size returns the size of the database, in bytes.
+func (img *Image) Size() int64 { return int64(img.commit) * int64(img.pageSize) }
+
+// Page returns the data for pgno. Returns nil if the page is not set. The
+// returned slice must not be modified.
+func (img *Image) Page(pgno uint32) []byte {
+	return img.pages[pgno]
+}
+
+// SetPage sets a copy of data as the contents of pgno. The commit size is
+// extended if pgno is beyond it.
+func (img *Image) SetPage(pgno uint32, data []byte) error {
+	if pgno == 0 {
+		return fmt.Errorf("page number required")
+	} else if img.pageSize == 0 || uint32(len(data)) != img.pageSize {
+		return fmt.Errorf("invalid page buffer size: %d, expecting %d", len(data), img.pageSize)
+	} else if pgno == LockPgno(img.pageSize) {
+		return fmt.Errorf("cannot set lock page: pgno=%d", pgno)
+	}
+
+	img.pages[pgno] = bytes.Clone(data)
+	img.commit = max(img.commit, pgno)
+	return nil
+}
+
+// Truncate sets the commit size of the database. Pages beyond commit are
+// removed. Pages added by extending the commit size are zero-filled.
+func (img *Image) Truncate(commit uint32) {
+	for pgno := range img.pages {
+		if pgno > commit {
+			delete(img.pages, pgno)
+		}
+	}
+	img.commit = commit
+}
+
+// Clone returns a deep copy of the image.
+func (img *Image) Clone() *Image {
+	other := &Image{
+		pageSize: img.pageSize,
+		commit:   img.commit,
+		pages:    make(map[uint32][]byte, len(img.pages)),
+		id:       img.id,
+
+		freePages:     img.freePages,
+		OmitFreePages: img.OmitFreePages,
+	}
+	for pgno, data := range img.pages {
+		other.pages[pgno] = bytes.Clone(data)
+	}
+	if img.shard != nil {
+		hdr := *img.shard
+		other.shard = &hdr
+	}
+	if img.undo != nil {
+		undo := *img.undo
+		undo.pages = maps.Clone(undo.pages)
+		other.undo = &undo
+	}
+	return other
+}
+
+// Checksum returns the rolling checksum of the database. This matches the
+// checksum computed by ChecksumReader() over the output of WriteTo().
+func (img *Image) Checksum() Checksum {
+	return img.checksum(nil)
+}
+
+// checksum returns the rolling checksum of the database with the pages in
+// free treated as zeros.
+func (img *Image) checksum(free map[uint32]struct{}) Checksum {
+	chksum := ChecksumFlag
+	if img.commit == 0 {
+		return chksum // empty image, page size may not be set
+	}
+
+	var zero []byte
+	lockPgno := LockPgno(img.pageSize)
+	for pgno := uint32(1); pgno <= img.commit; pgno++ {
+		if pgno == lockPgno {
+			continue
+		}
+
+		data := img.pages[pgno]
+		if _, ok := free[pgno]; ok || data == nil {
+			if zero == nil {
+				zero = make([]byte, img.pageSize)
+			}
+			data = zero
+		}
+		chksum = ChecksumFlag | (chksum ^ ChecksumPage(pgno, data))
+	}
+	return chksum
+}
+
+// WriteTo writes the image to w as a SQLite database file.
+func (img *Image) WriteTo(w io.Writer) (n int64, err error) {
+	zero := make([]byte, img.pageSize)
+	for pgno := uint32(1); pgno <= img.commit; pgno++ {
+		data := img.pages[pgno]
+		if data == nil {
+			data = zero
+		}
+
+		nn, err := w.Write(data)
+		n += int64(nn)
+		if err != nil {
+			return n, fmt.Errorf("write page %d: %w", pgno, err)
+		}
+	}
+	return n, nil
+}
+
+// ApplyLTX reads an LTX file from dec and applies it to the image. The
+// pre-apply checksum is verified before any pages are changed and the
+// post-apply checksum is verified afterward. If the file cannot be applied then
+// the image is restored to its state before the file.
+//
+// Shards must be applied as a complete set in page order. The commit size is
+// updated & the post-apply checksum verified once the last shard is applied.
+// If any shard in a set cannot be applied then the shards already applied are
+// also rolled back.
+func (img *Image) ApplyLTX(dec *Decoder) (err error) {
+	undo := img.undo
+	if undo == nil {
+		undo = &imageUndo{pages: make(map[uint32][]byte), pageSize: img.pageSize, commit: img.commit, id: img.id}
+	}
+	defer func() {
+		if err != nil {
+			img.rollback(undo)
+		}
+	}()
+
+	if err := dec.DecodeHeader(); err != nil {
+		return fmt.Errorf("decode header: %w", err)
+	}
+	hdr := dec.Header()
+
+	if err := CheckDatabaseID(img.id, hdr.DatabaseID); err != nil {
+		return err
+	} else if img.pageSize != 0 && hdr.PageSize != img.pageSize {
+		return fmt.Errorf("page size mismatch: %d != %d", hdr.PageSize, img.pageSize)
+	} else if img.shard != nil && !IsNextShard(*img.shard, hdr) {
+		return fmt.Errorf("incomplete shard set: expected shard starting at page %d", img.shard.ShardMaxPgno+1)
+	} else if img.shard == nil && !hdr.IsFirstShard() {
+		return fmt.Errorf("shard applied out of order: starts at page %d", hdr.ShardMinPgno)
+	}
+
+	if !hdr.IsSnapshot() && !hdr.NoChecksum() && hdr.IsFirstShard() {
+		if chksum := img.Checksum(); chksum != hdr.PreApplyChecksum {
+			return preApplyChecksumError(chksum, hdr.PreApplyChecksum, img.freePages)
+		}
+	}
+
+	// Read all pages before changing the image so that a corrupt file
+	// is not partially applied.
+	pages := make(map[uint32][]byte)
+	for {
+		var pageHeader PageHeader
+		data := make([]byte, hdr.PageSize)
+		if err := dec.DecodePage(&pageHeader, data); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode page: %w", err)
+		}
+		pages[pageHeader.Pgno] = data
+	}
+	if err := dec.Close(); err != nil {
+		return fmt.Errorf("close decoder: %w", err)
+	}
+
+	img.pageSize = hdr.PageSize
+	if img.id.IsZero() {
+		img.id = hdr.DatabaseID
+	}
+	for pgno, data := range pages {
+		undo.save(pgno, img.pages[pgno])
+		img.pages[pgno] = data
+	}
+
+	// Wait until all shards are applied before truncating & verifying.
+	if !hdr.IsLastShard() {
+		img.shard, img.undo = &hdr, undo
+		return nil
+	}
+
+	for pgno, data := range img.pages {
+		if pgno > hdr.Commit {
+			undo.save(pgno, data)
+			delete(img.pages, pgno)
+		}
+	}
+	img.commit = hdr.Commit
+
+	if !hdr.NoChecksum() {
+		if chksum := img.Checksum(); chksum != dec.Trailer().PostApplyChecksum {
+			return fmt.Errorf("post-apply checksum mismatch: %s <> %s", chksum, dec.Trailer().PostApplyChecksum)
+		}
+	}
+
+	if hdr.IsSnapshot() {
+		img.freePages = hdr.HasFreePages()
+	}
+	img.shard, img.undo = nil, nil
+	return nil
+}
+
+// imageUndo represents the state of an image before a file, or a set of
+// shards, was applied.
+type imageUndo struct {
+	pages    map[uint32][]byte // original page data, nil if the page was unset
+	pageSize uint32
+	commit   uint32
+	id       DatabaseID
+}
+
+// save records the original data of a page unless it has already been saved.
+func (u *imageUndo) save(pgno uint32, data []byte) {
+	if _, ok := u.pages[pgno]; !ok {
+		u.pages[pgno] = data
+	}
+}
+
+// rollback restores the image to its state before undo was created & discards
+// any partially applied shard set.
+func (img *Image) rollback(undo *imageUndo) {
+	for pgno, data := range undo.pages {
+		if data == nil {
+			delete(img.pages, pgno)
+		} else {
+			img.pages[pgno] = data
+		}
+	}
+	img.pageSize, img.commit, img.id = undo.pageSize, undo.commit, undo.id
+	img.shard, img.undo = nil, nil
+}
+
+// EncodeSnapshot writes the image to w as a snapshot LTX file. The page size,
+// commit & checksums are set from the image while all other header fields are
+// used as given. The header must have a minimum TXID of 1. If hdr is a shard
+// then only pages within its page range are written.
+func (img *Image) EncodeSnapshot(w io.Writer, hdr Header) error {
+	if !hdr.IsSnapshot() {
+		return fmt.Errorf("snapshot header must have a minimum transaction id of 1")
+	}
+	hdr.PageSize, hdr.Commit = img.pageSize, img.commit
+
+	var free map[uint32]struct{}
+	if img.OmitFreePages {
+		var err error
+		if free, err = img.readFreelist(); err != nil {
+			return fmt.Errorf("read freelist: %w", err)
+		}
+		hdr.Flags |= HeaderFlagFreePages
+	}
+
+	enc, err := NewEncoder(w)
+	if err != nil {
+		return fmt.Errorf("create ltx encoder: %w", err)
+	} else if err := enc.EncodeHeader(hdr); err != nil {
+		return fmt.Errorf("encode header: %w", err)
+	}
+
+	zero := make([]byte, img.pageSize)
+	lockPgno := LockPgno(img.pageSize)
+	for pgno := uint32(1); pgno <= img.commit; pgno++ {
+		if pgno == lockPgno || !hdr.ContainsPgno(pgno) {
+			continue
+		}
+
+		if _, ok := free[pgno]; ok {
+			if err := enc.EncodePage(PageHeader{Pgno: pgno, Flags: PageHeaderFlagFree}, nil); err != nil {
+				return fmt.Errorf("encode free page %d: %w", pgno, err)
+			}
+			continue
+		}
+
+		data := img.pages[pgno]
+		if data == nil {
+			data = zero
+		}
+		if err := enc.EncodePage(PageHeader{Pgno: pgno}, data); err != nil {
+			return fmt.Errorf("encode page %d: %w", pgno, err)
+		}
+	}
+
+	if !hdr.NoChecksum() {
+		enc.SetPostApplyChecksum(img.checksum(free))
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close ltx encoder: %w", err)
+	}
+	return nil
+}
+
+// readFreelist returns the freelist leaf pages of the image. See ReadFreelist().
+func (img *Image) readFreelist() (map[uint32]struct{}, error) {
+	return ReadFreelist(img.pageSize, img.commit, func(pgno uint32, data []byte) error {
+		if pgno > img.commit {
+			return io.ErrUnexpectedEOF
+		} else if page := img.pages[pgno]; page != nil {
+			copy(data, page)
+		} else {
+			clear(data)
+		}
+		return nil
+	})
+}
+
+// EncodeDiff writes the pages that differ between base and the image to w as
+// an LTX file which transforms base into the image. The page size, commit &
+// checksums are set from the images while all other header fields are used as
+// given. The header must not be a snapshot.
+func (img *Image) EncodeDiff(w io.Writer, base *Image, hdr Header) error {
+	if hdr.IsSnapshot() {
+		return fmt.Errorf("diff header cannot be a snapshot")
+	} else if base.pageSize != img.pageSize {
+		return fmt.Errorf("page size mismatch: %d != %d", base.pageSize, img.pageSize)
+	}
+	hdr.PageSize, hdr.Commit = img.pageSize, img.commit
+	if !hdr.NoChecksum() {
+		hdr.PreApplyChecksum = base.Checksum()
+	}
+
+	enc, err := NewEncoder(w)
+	if err != nil {
+		return fmt.Errorf("create ltx encoder: %w", err)
+	} else if err := enc.EncodeHeader(hdr); err != nil {
+		return fmt.Errorf("encode header: %w", err)
+	}
+
+	// Unset pages are zero-filled so a page only needs to be written if it
+	// differs from the base page. Base pages beyond the commit are removed
+	// by truncation when the file is applied.
+	zero := make([]byte, img.pageSize)
+	pgnos := make([]uint32, 0, len(img.pages))
+	for pgno, data := range img.pages {
+		if pgno > img.commit {
+			continue // partially applied shard
+		}
+
+		prev := base.pages[pgno]
+		if prev == nil || pgno > base.commit {
+			prev = zero
+		}
+		if !bytes.Equal(data, prev) {
+			pgnos = append(pgnos, pgno)
+		}
+	}
+	for pgno, data := range base.pages {
+		if pgno <= min(base.commit, img.commit) && img.pages[pgno] == nil && !bytes.Equal(data, zero) {
+			pgnos = append(pgnos, pgno)
+		}
+	}
+	slices.Sort(pgnos)
+
+	for _, pgno := range pgnos {
+		if !hdr.ContainsPgno(pgno) {
+			continue
+		}
+
+		data := img.pages[pgno]
+		if data == nil {
+			data = zero
+		}
+		if err := enc.EncodePage(PageHeader{Pgno: pgno}, data); err != nil {
+			return fmt.Errorf("encode page %d: %w", pgno, err)
+		}
+	}
+
+	return img.closeEncoder(enc, hdr)
+}
+
+// closeEncoder sets the post-apply checksum & closes enc.
+func (img *Image) closeEncoder(enc *Encoder, hdr Header) error {
+	if !hdr.NoChecksum() {
+		enc.SetPostApplyChecksum(img.Checksum())
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close ltx encoder: %w", err)
+	}
+	return nil
+}
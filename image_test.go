@@ -0,0 +1,357 @@
+package ltx_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestImage_Checksum(t *testing.T) {
+	img := ltx.NewImage(1024)
+	if err := img.SetPage(1, bytes.Repeat([]byte{1}, 1024)); err != nil {
+		t.Fatal(err)
+	} else if err := img.SetPage(3, bytes.Repeat([]byte{3}, 1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if n, err := img.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if got, want := n, int64(3*1024); got != want {
+		t.Fatalf("n=%d, want %d", got, want)
+	} else if !bytes.Equal(buf.Bytes()[1024:2048], make([]byte, 1024)) {
+		t.Fatal("expected zero-filled page 2")
+	}
+
+	if chksum, err := ltx.ChecksumReader(bytes.NewReader(buf.Bytes()), 1024); err != nil {
+		t.Fatal(err)
+	} else if got, want := img.Checksum(), chksum; got != want {
+		t.Fatalf("Checksum()=%s, want %s", got, want)
+	}
+}
+
+func TestImage_SetPage(t *testing.T) {
+	t.Run("ErrPageSize", func(t *testing.T) {
+		if err := ltx.NewImage(1024).SetPage(1, make([]byte, 512)); err == nil || err.Error() != `invalid page buffer size: 512, expecting 1024` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrLockPage", func(t *testing.T) {
+		if err := ltx.NewImage(65536).SetPage(ltx.LockPgno(65536), make([]byte, 65536)); err == nil || err.Error() != `cannot set lock page: pgno=16385` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestImage_Truncate(t *testing.T) {
+	img := newTestImage(t, 1024, 4)
+	img.Truncate(2)
+	if got, want := img.Commit(), uint32(2); got != want {
+		t.Fatalf("Commit()=%d, want %d", got, want)
+	} else if img.Page(3) != nil {
+		t.Fatal("expected page 3 removed")
+	}
+
+	// Extending the database zero-fills the new pages.
+	img.Truncate(4)
+	if got, want := img.Size(), int64(4*1024); got != want {
+		t.Fatalf("Size()=%d, want %d", got, want)
+	} else if img.Page(4) != nil {
+		t.Fatal("expected page 4 unset")
+	}
+}
+
+func TestImage_EncodeSnapshot(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		img := newTestImage(t, 1024, 5)
+
+		var buf bytes.Buffer
+		if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 3, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+
+		other := ltx.NewImage(0)
+		if err := other.ApplyLTX(ltx.NewDecoder(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		assertImageEqual(t, other, img)
+	})
+
+	t.Run("Shards", func(t *testing.T) {
+		img := newTestImage(t, 1024, 5)
+
+		other := ltx.NewImage(1024)
+		for _, r := range [][2]uint32{{1, 2}, {3, 5}} {
+			var buf bytes.Buffer
+			if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, MinTXID: 1, MaxTXID: 3, Timestamp: 1000, ShardMinPgno: r[0], ShardMaxPgno: r[1]}); err != nil {
+				t.Fatal(err)
+			} else if err := other.ApplyLTX(ltx.NewDecoder(&buf)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assertImageEqual(t, other, img)
+	})
+
+	// Freelist leaf pages are written without data & applied as zeros.
+	t.Run("OmitFreePages", func(t *testing.T) {
+		img, want := newTestFreelistImage(t)
+
+		var full, buf bytes.Buffer
+		hdr := ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 3, Timestamp: 1000}
+		if err := img.EncodeSnapshot(&full, hdr); err != nil {
+			t.Fatal(err)
+		}
+		img.OmitFreePages = true
+		if err := img.EncodeSnapshot(&buf, hdr); err != nil {
+			t.Fatal(err)
+		} else if buf.Len() >= full.Len() {
+			t.Fatalf("expected smaller snapshot: %d >= %d", buf.Len(), full.Len())
+		}
+
+		other := ltx.NewImage(0)
+		if err := other.ApplyLTX(ltx.NewDecoder(bytes.NewReader(buf.Bytes()))); err != nil {
+			t.Fatal(err)
+		}
+		assertImageEqual(t, other, want)
+
+		// The post-apply checksum matches the database with free pages zeroed.
+		dec := ltx.NewDecoder(&buf)
+		if err := dec.Verify(); err != nil {
+			t.Fatal(err)
+		} else if got, want := dec.Trailer().PostApplyChecksum, want.Checksum(); got != want {
+			t.Fatalf("PostApplyChecksum=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("ErrNotSnapshot", func(t *testing.T) {
+		if err := ltx.NewImage(1024).EncodeSnapshot(&bytes.Buffer{}, ltx.Header{Version: ltx.Version, MinTXID: 2, MaxTXID: 2}); err == nil || err.Error() != `snapshot header must have a minimum transaction id of 1` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestImage_EncodeDiff(t *testing.T) {
+	for _, commit := range []uint32{2, 4, 6} {
+		base := newTestImage(t, 1024, 4)
+
+		img := base.Clone()
+		img.Truncate(commit)
+		if err := img.SetPage(2, bytes.Repeat([]byte{0xff}, 1024)); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := img.EncodeDiff(&buf, base, ltx.Header{Version: ltx.Version, MinTXID: 2, MaxTXID: 2, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := base.ApplyLTX(ltx.NewDecoder(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		assertImageEqual(t, base, img)
+	}
+}
+
+func TestImage_ApplyLTX(t *testing.T) {
+	t.Run("ErrPreApplyChecksumMismatch", func(t *testing.T) {
+		img := newTestImage(t, 1024, 2)
+		other := img.Clone()
+		if err := other.SetPage(1, make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, &ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: ltx.ChecksumFlag | 1},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: make([]byte, 1024)}},
+			Trailer: ltx.Trailer{PostApplyChecksum: other.Checksum()},
+		})
+
+		chksum := img.Checksum()
+		if err := img.ApplyLTX(ltx.NewDecoder(&buf)); err == nil || err.Error() != `pre-apply checksum mismatch: `+chksum.String()+` <> 8000000000000001` {
+			t.Fatalf("unexpected error: %v", err)
+		} else if got, want := img.Checksum(), chksum; got != want {
+			t.Fatalf("image modified: checksum=%s, want %s", got, want)
+		}
+	})
+
+	// Incremental files from the source database do not match a snapshot
+	// with free pages omitted unless the source's free pages are zeroed.
+	t.Run("ErrFreePagesOmitted", func(t *testing.T) {
+		img, _ := newTestFreelistImage(t)
+		img.OmitFreePages = true
+
+		var buf bytes.Buffer
+		if err := img.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 3, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+		other := ltx.NewImage(0)
+		if err := other.ApplyLTX(ltx.NewDecoder(&buf)); err != nil {
+			t.Fatal(err)
+		}
+
+		next := img.Clone()
+		if err := next.SetPage(4, bytes.Repeat([]byte{0xff}, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err := next.EncodeDiff(&buf, img, ltx.Header{Version: ltx.Version, MinTXID: 4, MaxTXID: 4, Timestamp: 1000}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.ApplyLTX(ltx.NewDecoder(&buf)); !errors.Is(err, ltx.ErrFreePagesOmitted) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrPostApplyChecksumMismatch", func(t *testing.T) {
+		img := newTestImage(t, 1024, 2)
+
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, &ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 3, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: img.Checksum()},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: make([]byte, 1024)}, {Header: ltx.PageHeader{Pgno: 3}, Data: make([]byte, 1024)}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+		})
+
+		want := img.Clone()
+		if err := img.ApplyLTX(ltx.NewDecoder(&buf)); err == nil {
+			t.Fatal("expected error")
+		}
+		assertImageEqual(t, img, want)
+	})
+
+	// The page size of an empty image is only set once a file is applied.
+	t.Run("ErrPostApplyChecksumMismatchPageSize", func(t *testing.T) {
+		img := ltx.NewImage(0)
+
+		var buf bytes.Buffer
+		writeFileSpec(t, &buf, &ltx.FileSpec{
+			Header:  ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 1, MinTXID: 2, MaxTXID: 2, Timestamp: 1000, PreApplyChecksum: img.Checksum()},
+			Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: make([]byte, 1024)}},
+			Trailer: ltx.Trailer{PostApplyChecksum: ltx.ChecksumFlag | 1},
+		})
+
+		if err := img.ApplyLTX(ltx.NewDecoder(&buf)); err == nil {
+			t.Fatal("expected error")
+		} else if got, want := img.PageSize(), uint32(0); got != want {
+			t.Fatalf("PageSize()=%d, want %d", got, want)
+		}
+	})
+
+	// A failed shard rolls back the shards already applied from its set.
+	t.Run("ErrShardRollback", func(t *testing.T) {
+		src := newTestImage(t, 1024, 5)
+		encodeShard := func(maxTXID ltx.TXID, minPgno, maxPgno uint32) *bytes.Buffer {
+			var buf bytes.Buffer
+			if err := src.EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, MinTXID: 1, MaxTXID: maxTXID, Timestamp: 1000, ShardMinPgno: minPgno, ShardMaxPgno: maxPgno}); err != nil {
+				t.Fatal(err)
+			}
+			return &buf
+		}
+
+		img := newTestImage(t, 1024, 2)
+		if err := img.SetPage(1, make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		want := img.Clone()
+
+		if err := img.ApplyLTX(ltx.NewDecoder(encodeShard(3, 1, 2))); err != nil {
+			t.Fatal(err)
+		} else if err := img.ApplyLTX(ltx.NewDecoder(encodeShard(4, 3, 5))); err == nil || err.Error() != `incomplete shard set: expected shard starting at page 3` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertImageEqual(t, img, want)
+
+		// The set can be applied again from its first shard.
+		if err := img.ApplyLTX(ltx.NewDecoder(encodeShard(3, 1, 2))); err != nil {
+			t.Fatal(err)
+		} else if err := img.ApplyLTX(ltx.NewDecoder(encodeShard(3, 3, 5))); err != nil {
+			t.Fatal(err)
+		}
+		assertImageEqual(t, img, src)
+	})
+
+	t.Run("ErrDatabaseIDMismatch", func(t *testing.T) {
+		var buf, other bytes.Buffer
+		if err := newTestImage(t, 1024, 1).EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 1, DatabaseID: ltx.DatabaseID{1}}); err != nil {
+			t.Fatal(err)
+		} else if err := newTestImage(t, 1024, 1).EncodeSnapshot(&other, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 1, DatabaseID: ltx.DatabaseID{2}}); err != nil {
+			t.Fatal(err)
+		}
+
+		img := ltx.NewImage(1024)
+		if err := img.ApplyLTX(ltx.NewDecoder(&buf)); err != nil {
+			t.Fatal(err)
+		} else if got, want := img.DatabaseID(), (ltx.DatabaseID{1}); got != want {
+			t.Fatalf("DatabaseID()=%s, want %s", got, want)
+		} else if err := img.ApplyLTX(ltx.NewDecoder(&other)); !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrPageSizeMismatch", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := newTestImage(t, 1024, 1).EncodeSnapshot(&buf, ltx.Header{Version: ltx.Version, MinTXID: 1, MaxTXID: 1}); err != nil {
+			t.Fatal(err)
+		} else if err := ltx.NewImage(4096).ApplyLTX(ltx.NewDecoder(&buf)); err == nil || err.Error() != `page size mismatch: 1024 != 4096` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// newTestImage returns an image with commit pages that each contain a
+// different byte value.
+func newTestImage(tb testing.TB, pageSize, commit uint32) *ltx.Image {
+	tb.Helper()
+	img := ltx.NewImage(pageSize)
+	for pgno := uint32(1); pgno <= commit; pgno++ {
+		if err := img.SetPage(pgno, bytes.Repeat([]byte{byte(pgno)}, int(pageSize))); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return img
+}
+
+func assertImageEqual(tb testing.TB, got, want *ltx.Image) {
+	tb.Helper()
+
+	var gotBuf, wantBuf bytes.Buffer
+	if _, err := got.WriteTo(&gotBuf); err != nil {
+		tb.Fatal(err)
+	} else if _, err := want.WriteTo(&wantBuf); err != nil {
+		tb.Fatal(err)
+	} else if !bytes.Equal(gotBuf.Bytes(), wantBuf.Bytes()) {
+		tb.Fatal("image mismatch")
+	}
+}
+
+// newTestFreelistImage returns a SQLite database image with a freelist of
+// trunk page 3 & leaf pages 2 & 5. Also returns the image with its freelist
+// leaf pages zeroed.
+func newTestFreelistImage(tb testing.TB) (img, zeroed *ltx.Image) {
+	tb.Helper()
+	img = newTestImage(tb, 1024, 6)
+
+	page := img.Page(1)
+	page = append(append([]byte("SQLite format 3\x00"), page[16:32]...), 0, 0, 0, 3, 0, 0, 0, 3)
+	page = append(page, img.Page(1)[40:]...)
+	if err := img.SetPage(1, page); err != nil {
+		tb.Fatal(err)
+	}
+
+	trunk := make([]byte, 1024)
+	copy(trunk, []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 5})
+	if err := img.SetPage(3, trunk); err != nil {
+		tb.Fatal(err)
+	}
+
+	zeroed = img.Clone()
+	for _, pgno := range []uint32{2, 5} {
+		if err := zeroed.SetPage(pgno, make([]byte, 1024)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return img, zeroed
+}
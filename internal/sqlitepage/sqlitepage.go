@@ -0,0 +1,462 @@
+// Package sqlitepage parses the structure of individual SQLite database pages.
+// It is intended for debugging so malformed pages are reported as errors along
+// with whatever could be parsed before the error.
+package sqlitepage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// HeaderSize is the size of the database header at the start of page 1.
+const HeaderSize = 100
+
+// Offsets of database header fields which are modified in place.
+const (
+	ChangeCounterOffset   = 24
+	VersionValidForOffset = 92
+)
+
+// headerMagic is the string at the start of a SQLite database file.
+var headerMagic = []byte("SQLite format 3\x00")
+
+// ErrNotBTree is returned when parsing a page which is not a b-tree page.
+var ErrNotBTree = errors.New("not a b-tree page")
+
+// Type represents the type of a database page.
+type Type int
+
+const (
+	TypeUnknown Type = iota
+	TypeIndexInterior
+	TypeTableInterior
+	TypeIndexLeaf
+	TypeTableLeaf
+	TypeOverflow
+	TypeFreelistTrunk
+	TypeFreelistLeaf
+)
+
+// String returns the name of the page type.
+func (t Type) String() string {
+	switch t {
+	case TypeIndexInterior:
+		return "index interior"
+	case TypeTableInterior:
+		return "table interior"
+	case TypeIndexLeaf:
+		return "index leaf"
+	case TypeTableLeaf:
+		return "table leaf"
+	case TypeOverflow:
+		return "overflow"
+	case TypeFreelistTrunk:
+		return "freelist trunk"
+	case TypeFreelistLeaf:
+		return "freelist leaf"
+	default:
+		return "unknown"
+	}
+}
+
+// IsBTree returns true if t is a b-tree page type.
+func (t Type) IsBTree() bool {
+	return t >= TypeIndexInterior && t <= TypeTableLeaf
+}
+
+// IsInterior returns true if t is an interior b-tree page type.
+func (t Type) IsInterior() bool {
+	return t == TypeIndexInterior || t == TypeTableInterior
+}
+
+// BTreeType returns the b-tree page type for the page type flag byte.
+// Returns TypeUnknown if the flag is not a valid b-tree page type.
+func BTreeType(flag byte) Type {
+	switch flag {
+	case 0x02:
+		return TypeIndexInterior
+	case 0x05:
+		return TypeTableInterior
+	case 0x0a:
+		return TypeIndexLeaf
+	case 0x0d:
+		return TypeTableLeaf
+	default:
+		return TypeUnknown
+	}
+}
+
+// DatabaseHeader represents the database header stored at the start of page 1.
+type DatabaseHeader struct {
+	PageSize          uint32
+	WriteVersion      uint8
+	ReadVersion       uint8
+	ReservedSize      uint8
+	MaxPayloadFrac    uint8
+	MinPayloadFrac    uint8
+	LeafPayloadFrac   uint8
+	ChangeCounter     uint32
+	PageN             uint32
+	FreelistTrunk     uint32
+	FreelistN         uint32
+	SchemaCookie      uint32
+	SchemaFormat      uint32
+	DefaultCacheSize  uint32
+	LargestRootPage   uint32
+	TextEncoding      uint32
+	UserVersion       uint32
+	IncrementalVacuum uint32
+	ApplicationID     uint32
+	VersionValidFor   uint32
+	SQLiteVersion     uint32
+}
+
+// IsDatabaseHeader returns true if b starts with a SQLite database header.
+func IsDatabaseHeader(b []byte) bool {
+	return len(b) >= HeaderSize && bytes.HasPrefix(b, headerMagic)
+}
+
+// ParseDatabaseHeader parses the database header from the start of page 1.
+func ParseDatabaseHeader(b []byte) (*DatabaseHeader, error) {
+	if !IsDatabaseHeader(b) {
+		return nil, fmt.Errorf("invalid database header")
+	}
+
+	hdr := &DatabaseHeader{
+		PageSize:          uint32(binary.BigEndian.Uint16(b[16:])),
+		WriteVersion:      b[18],
+		ReadVersion:       b[19],
+		ReservedSize:      b[20],
+		MaxPayloadFrac:    b[21],
+		MinPayloadFrac:    b[22],
+		LeafPayloadFrac:   b[23],
+		ChangeCounter:     binary.BigEndian.Uint32(b[ChangeCounterOffset:]),
+		PageN:             binary.BigEndian.Uint32(b[28:]),
+		FreelistTrunk:     binary.BigEndian.Uint32(b[32:]),
+		FreelistN:         binary.BigEndian.Uint32(b[36:]),
+		SchemaCookie:      binary.BigEndian.Uint32(b[40:]),
+		SchemaFormat:      binary.BigEndian.Uint32(b[44:]),
+		DefaultCacheSize:  binary.BigEndian.Uint32(b[48:]),
+		LargestRootPage:   binary.BigEndian.Uint32(b[52:]),
+		TextEncoding:      binary.BigEndian.Uint32(b[56:]),
+		UserVersion:       binary.BigEndian.Uint32(b[60:]),
+		IncrementalVacuum: binary.BigEndian.Uint32(b[64:]),
+		ApplicationID:     binary.BigEndian.Uint32(b[68:]),
+		VersionValidFor:   binary.BigEndian.Uint32(b[VersionValidForOffset:]),
+		SQLiteVersion:     binary.BigEndian.Uint32(b[96:]),
+	}
+	if hdr.PageSize == 1 {
+		hdr.PageSize = 65536
+	}
+	return hdr, nil
+}
+
+// UsableSize returns the number of bytes of each page available for content.
+func (hdr *DatabaseHeader) UsableSize() uint32 {
+	return hdr.PageSize - uint32(hdr.ReservedSize)
+}
+
+// TextEncodingName returns the name of the database text encoding.
+func (hdr *DatabaseHeader) TextEncodingName() string {
+	switch hdr.TextEncoding {
+	case 1:
+		return "UTF-8"
+	case 2:
+		return "UTF-16le"
+	case 3:
+		return "UTF-16be"
+	default:
+		return "unknown"
+	}
+}
+
+// BTreePage represents a parsed b-tree page.
+type BTreePage struct {
+	Type             Type
+	FirstFreeblock   uint16
+	CellN            uint16
+	CellContentStart uint32
+	FragmentedBytes  uint8
+	RightChild       uint32 // interior pages only
+
+	Cells      []Cell
+	Freeblocks []Freeblock
+}
+
+// Cell represents a single cell on a b-tree page.
+type Cell struct {
+	Offset       int
+	LeftChild    uint32 // interior pages only
+	Rowid        int64  // table pages only
+	PayloadSize  int64  // all pages except table interior pages
+	LocalSize    int64  // bytes of payload stored on the page
+	OverflowPgno uint32 // first overflow page, if any
+}
+
+// Freeblock represents an unused block of space within the cell content area.
+type Freeblock struct {
+	Offset int
+	Size   int
+}
+
+// ParseBTreePage parses the b-tree page pgno. Page 1 begins after the database
+// header. Returns ErrNotBTree if the page type flag is invalid. If the page is
+// malformed then the page is returned with the fields parsed so far along with
+// the error.
+func ParseBTreePage(pgno uint32, data []byte, usableSize uint32) (*BTreePage, error) {
+	if usableSize > uint32(len(data)) {
+		return nil, fmt.Errorf("usable size %d exceeds page size %d", usableSize, len(data))
+	}
+	b := data[:usableSize]
+
+	off := 0
+	if pgno == 1 {
+		off = HeaderSize
+	}
+	if len(b) < off+8 {
+		return nil, fmt.Errorf("page too small for b-tree header")
+	}
+
+	page := &BTreePage{Type: BTreeType(b[off])}
+	if !page.Type.IsBTree() {
+		return nil, ErrNotBTree
+	}
+	page.FirstFreeblock = binary.BigEndian.Uint16(b[off+1:])
+	page.CellN = binary.BigEndian.Uint16(b[off+3:])
+	page.CellContentStart = uint32(binary.BigEndian.Uint16(b[off+5:]))
+	if page.CellContentStart == 0 {
+		page.CellContentStart = 65536
+	}
+	page.FragmentedBytes = b[off+7]
+	off += 8
+
+	if page.Type.IsInterior() {
+		if len(b) < off+4 {
+			return page, fmt.Errorf("page too small for b-tree header")
+		}
+		page.RightChild = binary.BigEndian.Uint32(b[off:])
+		off += 4
+	}
+
+	// The cell pointer array follows the header.
+	if len(b) < off+2*int(page.CellN) {
+		return page, fmt.Errorf("cell count %d exceeds page size", page.CellN)
+	}
+	for i := 0; i < int(page.CellN); i++ {
+		cellOffset := int(binary.BigEndian.Uint16(b[off+2*i:]))
+		cell, err := parseCell(page.Type, b, cellOffset, off+2*int(page.CellN))
+		if err != nil {
+			return page, fmt.Errorf("cell %d: %w", i, err)
+		}
+		page.Cells = append(page.Cells, cell)
+	}
+
+	// Freeblocks form a chain in ascending order of offset.
+	for next := int(page.FirstFreeblock); next != 0; {
+		if next < off+2*int(page.CellN) || next+4 > len(b) {
+			return page, fmt.Errorf("freeblock offset out of range: %d", next)
+		}
+		fb := Freeblock{Offset: next, Size: int(binary.BigEndian.Uint16(b[next+2:]))}
+		if fb.Size < 4 || fb.Offset+fb.Size > len(b) {
+			return page, fmt.Errorf("invalid freeblock size at offset %d: %d", fb.Offset, fb.Size)
+		}
+		page.Freeblocks = append(page.Freeblocks, fb)
+
+		if next = int(binary.BigEndian.Uint16(b[next:])); next != 0 && next < fb.Offset+fb.Size {
+			return page, fmt.Errorf("freeblock at offset %d is not in ascending order", next)
+		}
+	}
+
+	return page, nil
+}
+
+// parseCell parses the cell at offset within the usable space of a page. The
+// minOffset is the end of the cell pointer array.
+func parseCell(typ Type, b []byte, offset, minOffset int) (cell Cell, err error) {
+	if offset < minOffset || offset >= len(b) {
+		return cell, fmt.Errorf("offset out of range: %d", offset)
+	}
+	cell.Offset = offset
+
+	p := offset
+	if typ.IsInterior() {
+		if p+4 > len(b) {
+			return cell, fmt.Errorf("left child pointer exceeds page")
+		}
+		cell.LeftChild = binary.BigEndian.Uint32(b[p:])
+		p += 4
+	}
+
+	if typ != TypeTableInterior {
+		v, n := readVarint(b[p:])
+		if n == 0 {
+			return cell, fmt.Errorf("payload size exceeds page")
+		}
+		cell.PayloadSize, p = int64(v), p+n
+	}
+
+	if typ == TypeTableInterior || typ == TypeTableLeaf {
+		v, n := readVarint(b[p:])
+		if n == 0 {
+			return cell, fmt.Errorf("rowid exceeds page")
+		}
+		cell.Rowid, p = int64(v), p+n
+	}
+
+	if typ == TypeTableInterior {
+		return cell, nil
+	}
+
+	// Payload which does not fit on the page spills onto overflow pages. The
+	// first overflow page number follows the local payload.
+	cell.LocalSize = localPayloadSize(typ, cell.PayloadSize, int64(len(b)))
+	if p+int(cell.LocalSize) > len(b) {
+		return cell, fmt.Errorf("payload exceeds page")
+	}
+	if cell.LocalSize < cell.PayloadSize {
+		p += int(cell.LocalSize)
+		if p+4 > len(b) {
+			return cell, fmt.Errorf("overflow page number exceeds page")
+		}
+		cell.OverflowPgno = binary.BigEndian.Uint32(b[p:])
+	}
+	return cell, nil
+}
+
+// localPayloadSize returns the number of payload bytes stored on a b-tree page
+// of the given usable size. See "B-tree Pages" in the SQLite file format.
+func localPayloadSize(typ Type, payloadSize, usableSize int64) int64 {
+	maxLocal := usableSize - 35
+	if typ != TypeTableLeaf {
+		maxLocal = ((usableSize-12)*64/255 - 23)
+	}
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+
+	minLocal := ((usableSize-12)*32/255 - 23)
+	if k := minLocal + ((payloadSize - minLocal) % (usableSize - 4)); k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// readVarint decodes a SQLite variable-length integer from b. Returns the
+// value & the number of bytes read, or zero bytes if b is too short.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// FreelistTrunk represents a parsed freelist trunk page.
+type FreelistTrunk struct {
+	Next   uint32
+	Leaves []uint32
+}
+
+// ParseFreelistTrunk parses a freelist trunk page.
+func ParseFreelistTrunk(data []byte, usableSize uint32) (*FreelistTrunk, error) {
+	if usableSize > uint32(len(data)) || usableSize < 8 {
+		return nil, fmt.Errorf("invalid usable size: %d", usableSize)
+	}
+
+	trunk := &FreelistTrunk{Next: binary.BigEndian.Uint32(data[0:])}
+	leafN := binary.BigEndian.Uint32(data[4:])
+	if leafN > usableSize/4-2 {
+		return nil, fmt.Errorf("invalid leaf count: %d", leafN)
+	}
+	for i := uint32(0); i < leafN; i++ {
+		trunk.Leaves = append(trunk.Leaves, binary.BigEndian.Uint32(data[8+4*i:]))
+	}
+	return trunk, nil
+}
+
+// Classify determines the type of each page in pgnos by reading page 1, each
+// b-tree page & following the freelist & overflow page chains. Only pages in
+// pgnos are read by readPage so references to other pages are not followed.
+//
+// Pages which are not b-tree pages & are not referenced as a freelist or
+// overflow page are not included in the returned map.
+func Classify(pageSize uint32, pgnos []uint32, readPage func(pgno uint32, data []byte) error) (map[uint32]Type, error) {
+	exists := make(map[uint32]bool, len(pgnos))
+	for _, pgno := range pgnos {
+		exists[pgno] = true
+	}
+
+	data := make([]byte, pageSize)
+	types := make(map[uint32]Type)
+
+	// Read the usable size & freelist head from page 1, if available.
+	usableSize := pageSize
+	var trunk uint32
+	if exists[1] {
+		if err := readPage(1, data); err != nil {
+			return nil, fmt.Errorf("read page 1: %w", err)
+		}
+		if hdr, err := ParseDatabaseHeader(data); err == nil {
+			usableSize, trunk = hdr.UsableSize(), hdr.FreelistTrunk
+		}
+	}
+
+	// Free pages may contain stale b-tree content so mark them first.
+	for trunk != 0 && exists[trunk] && types[trunk] == TypeUnknown {
+		types[trunk] = TypeFreelistTrunk
+		if err := readPage(trunk, data); err != nil {
+			return nil, fmt.Errorf("read freelist trunk page %d: %w", trunk, err)
+		}
+		t, err := ParseFreelistTrunk(data, usableSize)
+		if err != nil {
+			return nil, fmt.Errorf("freelist trunk page %d: %w", trunk, err)
+		}
+		for _, pgno := range t.Leaves {
+			types[pgno] = TypeFreelistLeaf
+		}
+		trunk = t.Next
+	}
+
+	// Identify b-tree pages by their type flag & collect overflow chains.
+	var overflows []uint32
+	for _, pgno := range pgnos {
+		if types[pgno] != TypeUnknown {
+			continue
+		} else if err := readPage(pgno, data); err != nil {
+			return nil, fmt.Errorf("read page %d: %w", pgno, err)
+		}
+
+		// Malformed pages are classified from whatever could be parsed.
+		page, _ := ParseBTreePage(pgno, data, usableSize)
+		if page == nil {
+			continue
+		}
+		types[pgno] = page.Type
+		for _, cell := range page.Cells {
+			if cell.OverflowPgno != 0 {
+				overflows = append(overflows, cell.OverflowPgno)
+			}
+		}
+	}
+
+	// Each overflow page begins with the number of the next overflow page.
+	for _, pgno := range overflows {
+		for pgno != 0 && exists[pgno] && types[pgno] == TypeUnknown {
+			types[pgno] = TypeOverflow
+			if err := readPage(pgno, data); err != nil {
+				return nil, fmt.Errorf("read overflow page %d: %w", pgno, err)
+			}
+			pgno = binary.BigEndian.Uint32(data[0:])
+		}
+	}
+
+	return types, nil
+}
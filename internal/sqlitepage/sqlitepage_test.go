@@ -0,0 +1,214 @@
+package sqlitepage_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/superfly/ltx/internal/sqlitepage"
+)
+
+func TestParseDatabaseHeader(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		hdr, err := sqlitepage.ParseDatabaseHeader(newTestPage1(512, 4, 2))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr.PageSize, uint32(512); got != want {
+			t.Fatalf("PageSize=%d, want %d", got, want)
+		} else if got, want := hdr.PageN, uint32(4); got != want {
+			t.Fatalf("PageN=%d, want %d", got, want)
+		} else if got, want := hdr.FreelistTrunk, uint32(2); got != want {
+			t.Fatalf("FreelistTrunk=%d, want %d", got, want)
+		} else if got, want := hdr.TextEncodingName(), "UTF-8"; got != want {
+			t.Fatalf("TextEncodingName()=%s, want %s", got, want)
+		} else if got, want := hdr.UsableSize(), uint32(512); got != want {
+			t.Fatalf("UsableSize()=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("64KBPageSize", func(t *testing.T) {
+		b := newTestPage1(512, 1, 0)
+		binary.BigEndian.PutUint16(b[16:], 1)
+		if hdr, err := sqlitepage.ParseDatabaseHeader(b); err != nil {
+			t.Fatal(err)
+		} else if got, want := hdr.PageSize, uint32(65536); got != want {
+			t.Fatalf("PageSize=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ErrInvalid", func(t *testing.T) {
+		if _, err := sqlitepage.ParseDatabaseHeader(make([]byte, 512)); err == nil || err.Error() != `invalid database header` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestIsDatabaseHeader(t *testing.T) {
+	if !sqlitepage.IsDatabaseHeader(newTestPage1(512, 1, 0)) {
+		t.Fatal("expected database header")
+	} else if sqlitepage.IsDatabaseHeader(newTestPage1(512, 1, 0)[:99]) {
+		t.Fatal("expected short header to be invalid")
+	} else if sqlitepage.IsDatabaseHeader(make([]byte, 512)) {
+		t.Fatal("expected zero page to be invalid")
+	}
+}
+
+func TestParseBTreePage(t *testing.T) {
+	t.Run("TableLeaf", func(t *testing.T) {
+		page, err := sqlitepage.ParseBTreePage(1, newTestPage1(512, 4, 2), 512)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := page.Type, sqlitepage.TypeTableLeaf; got != want {
+			t.Fatalf("Type=%s, want %s", got, want)
+		}
+
+		// A 1000-byte payload keeps 39 bytes on a 512-byte page.
+		if got, want := page.Cells, []sqlitepage.Cell{
+			{Offset: 400, Rowid: 1, PayloadSize: 1000, LocalSize: 39, OverflowPgno: 4},
+			{Offset: 450, Rowid: 2, PayloadSize: 3, LocalSize: 3},
+		}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Cells=%#v, want %#v", got, want)
+		} else if got, want := page.Freeblocks, []sqlitepage.Freeblock{{Offset: 300, Size: 20}, {Offset: 340, Size: 8}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Freeblocks=%#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("TableInterior", func(t *testing.T) {
+		b := make([]byte, 512)
+		b[0] = 0x05
+		binary.BigEndian.PutUint16(b[3:], 1)
+		binary.BigEndian.PutUint32(b[8:], 7)
+		binary.BigEndian.PutUint16(b[12:], 500)
+		copy(b[500:], []byte{0, 0, 0, 6, 0x81, 0x00})
+
+		page, err := sqlitepage.ParseBTreePage(2, b, 512)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := page.RightChild, uint32(7); got != want {
+			t.Fatalf("RightChild=%d, want %d", got, want)
+		} else if got, want := page.Cells, []sqlitepage.Cell{{Offset: 500, LeftChild: 6, Rowid: 128}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Cells=%#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("ErrNotBTree", func(t *testing.T) {
+		if _, err := sqlitepage.ParseBTreePage(2, make([]byte, 512), 512); !errors.Is(err, sqlitepage.ErrNotBTree) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrCellOffset", func(t *testing.T) {
+		b := newTestPage1(512, 4, 2)
+		binary.BigEndian.PutUint16(b[108:], 600)
+		page, err := sqlitepage.ParseBTreePage(1, b, 512)
+		if err == nil || err.Error() != `cell 0: offset out of range: 600` {
+			t.Fatalf("unexpected error: %v", err)
+		} else if page == nil || page.CellN != 2 {
+			t.Fatal("expected partially parsed page")
+		}
+	})
+
+	t.Run("ErrFreeblockOrder", func(t *testing.T) {
+		b := newTestPage1(512, 4, 2)
+		binary.BigEndian.PutUint16(b[340:], 300)
+		if _, err := sqlitepage.ParseBTreePage(1, b, 512); err == nil || err.Error() != `freeblock at offset 300 is not in ascending order` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestParseFreelistTrunk(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		b := make([]byte, 512)
+		copy(b, []byte{0, 0, 0, 9, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 5})
+		if trunk, err := sqlitepage.ParseFreelistTrunk(b, 512); err != nil {
+			t.Fatal(err)
+		} else if got, want := trunk, (&sqlitepage.FreelistTrunk{Next: 9, Leaves: []uint32{3, 5}}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("trunk=%#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("ErrLeafCount", func(t *testing.T) {
+		b := make([]byte, 512)
+		binary.BigEndian.PutUint32(b[4:], 127)
+		if _, err := sqlitepage.ParseFreelistTrunk(b, 512); err == nil || err.Error() != `invalid leaf count: 127` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestClassify(t *testing.T) {
+	// Page 1 holds a cell which overflows onto pages 4 & 5. Page 2 is a
+	// freelist trunk for page 3 which still holds a stale b-tree page.
+	pages := map[uint32][]byte{1: newTestPage1(512, 5, 2)}
+	pages[2] = make([]byte, 512)
+	copy(pages[2], []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 3})
+	pages[3] = make([]byte, 512)
+	pages[3][0] = 0x0d
+	pages[4] = make([]byte, 512)
+	binary.BigEndian.PutUint32(pages[4], 5)
+	pages[5] = make([]byte, 512)
+
+	readPage := func(pgno uint32, data []byte) error {
+		copy(data, pages[pgno])
+		return nil
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		types, err := sqlitepage.Classify(512, []uint32{1, 2, 3, 4, 5}, readPage)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := types, map[uint32]sqlitepage.Type{
+			1: sqlitepage.TypeTableLeaf,
+			2: sqlitepage.TypeFreelistTrunk,
+			3: sqlitepage.TypeFreelistLeaf,
+			4: sqlitepage.TypeOverflow,
+			5: sqlitepage.TypeOverflow,
+		}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("types=%v, want %v", got, want)
+		}
+	})
+
+	// Pages which are not available are not followed.
+	t.Run("Partial", func(t *testing.T) {
+		types, err := sqlitepage.Classify(512, []uint32{3, 5}, readPage)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := types, map[uint32]sqlitepage.Type{3: sqlitepage.TypeTableLeaf}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("types=%v, want %v", got, want)
+		}
+	})
+}
+
+// newTestPage1 returns page 1 of a database with a table leaf page holding
+// two cells & two freeblocks. The first cell overflows onto page 4.
+func newTestPage1(pageSize, pageN, freelistTrunk uint32) []byte {
+	b := make([]byte, pageSize)
+	copy(b, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(b[16:], uint16(pageSize))
+	b[18], b[19] = 1, 1
+	b[21], b[22], b[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(b[28:], pageN)
+	binary.BigEndian.PutUint32(b[32:], freelistTrunk)
+	binary.BigEndian.PutUint32(b[56:], 1)
+
+	// B-tree header & cell pointer array.
+	b[100] = 0x0d
+	binary.BigEndian.PutUint16(b[101:], 300) // first freeblock
+	binary.BigEndian.PutUint16(b[103:], 2)   // cell count
+	binary.BigEndian.PutUint16(b[105:], 300) // cell content start
+	binary.BigEndian.PutUint16(b[108:], 400)
+	binary.BigEndian.PutUint16(b[110:], 450)
+
+	// Freeblocks.
+	binary.BigEndian.PutUint16(b[300:], 340)
+	binary.BigEndian.PutUint16(b[302:], 20)
+	binary.BigEndian.PutUint16(b[342:], 8)
+
+	// Cells: payload size & rowid varints, local payload, overflow page.
+	copy(b[400:], []byte{0x87, 0x68, 0x01})
+	binary.BigEndian.PutUint32(b[403+39:], 4)
+	copy(b[450:], []byte{0x03, 0x02, 'a', 'b', 'c'})
+	return b
+}
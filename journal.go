@@ -0,0 +1,282 @@
+package ltx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Rollback journal constants. The journal uses the same format as SQLite's
+// rollback journal so SQLite also rolls back a journal left by a crash.
+const (
+	journalHeaderSize = 28
+	journalSectorSize = 512
+)
+
+// journalMagic is the magic number at the start of a SQLite rollback journal.
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// JournalPath returns the path of the rollback journal for a database path.
+func JournalPath(dbPath string) string { return dbPath + "-journal" }
+
+// journal represents a rollback journal which records the original contents
+// of database pages before they are overwritten.
+type journal struct {
+	f        *os.File
+	db       *os.File
+	pageSize uint32
+	nonce    uint32
+	dbSize   uint32 // original database size, in pages
+
+	n       uint32              // number of records written
+	synced  uint32              // number of records synced
+	durable bool                // true once the journal has been synced
+	pgnos   map[uint32]struct{} // pages already journaled
+	buf     []byte
+}
+
+// createJournal creates a rollback journal for db. The journal is not durable
+// until it is synced.
+func createJournal(db *os.File, pageSize uint32) (*journal, error) {
+	fi, err := db.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Never overwrite an existing journal as it may be needed to roll back
+	// the database after a crash.
+	f, err := os.OpenFile(JournalPath(db.Name()), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("hot journal exists, database must be recovered: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+
+	j := &journal{
+		f:        f,
+		db:       db,
+		pageSize: pageSize,
+		dbSize:   uint32((fi.Size() + int64(pageSize) - 1) / int64(pageSize)),
+		pgnos:    make(map[uint32]struct{}),
+		buf:      make([]byte, 4+pageSize+4),
+	}
+
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		_ = j.remove()
+		return nil, err
+	}
+	j.nonce = binary.BigEndian.Uint32(b[:])
+
+	// Write the header, padded to a full sector.
+	hdr := make([]byte, journalSectorSize)
+	copy(hdr, journalMagic)
+	binary.BigEndian.PutUint32(hdr[12:], j.nonce)
+	binary.BigEndian.PutUint32(hdr[16:], j.dbSize)
+	binary.BigEndian.PutUint32(hdr[20:], journalSectorSize)
+	binary.BigEndian.PutUint32(hdr[24:], pageSize)
+	if _, err := f.WriteAt(hdr, 0); err != nil {
+		_ = j.remove()
+		return nil, fmt.Errorf("write journal header: %w", err)
+	}
+	return j, nil
+}
+
+// add records the original contents of pgno if it exists in the original
+// database and has not already been recorded. The lock page is never
+// recorded as SQLite stops playback when it encounters it.
+func (j *journal) add(pgno uint32) error {
+	if pgno > j.dbSize || pgno == LockPgno(j.pageSize) {
+		return nil
+	} else if _, ok := j.pgnos[pgno]; ok {
+		return nil
+	}
+
+	binary.BigEndian.PutUint32(j.buf[0:4], pgno)
+	data := j.buf[4 : 4+j.pageSize]
+	if n, err := j.db.ReadAt(data, int64(pgno-1)*int64(j.pageSize)); err != nil && err != io.EOF {
+		return fmt.Errorf("read original page %d: %w", pgno, err)
+	} else if n < len(data) {
+		clear(data[n:])
+	}
+	binary.BigEndian.PutUint32(j.buf[4+j.pageSize:], journalChecksum(j.nonce, data))
+
+	if _, err := j.f.WriteAt(j.buf, j.recordOffset(j.n)); err != nil {
+		return fmt.Errorf("write journal record: %w", err)
+	}
+	j.pgnos[pgno] = struct{}{}
+	j.n++
+	return nil
+}
+
+// sync updates the record count in the header and makes the journal durable.
+// The database must not be modified until the journal has been synced.
+func (j *journal) sync() error {
+	if j.durable && j.synced == j.n {
+		return nil
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], j.n)
+	if _, err := j.f.WriteAt(b[:], 8); err != nil {
+		return fmt.Errorf("write journal header: %w", err)
+	} else if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+
+	// Ensure the journal itself exists after a crash the first time it is synced.
+	if !j.durable {
+		if err := syncDir(filepath.Dir(j.f.Name())); err != nil {
+			return err
+		}
+	}
+	j.synced, j.durable = j.n, true
+	return nil
+}
+
+// commit removes the journal once the database has been synced.
+func (j *journal) commit() error {
+	if err := j.db.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+	return j.remove()
+}
+
+// rollback restores the original pages to the database and removes the journal.
+func (j *journal) rollback() error {
+	if err := rollbackJournal(j.db, j.f); err != nil {
+		return err
+	}
+	return j.remove()
+}
+
+// remove closes & durably removes the journal file.
+func (j *journal) remove() error {
+	_ = j.f.Close()
+	if err := os.Remove(j.f.Name()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove journal: %w", err)
+	}
+	return syncDir(filepath.Dir(j.f.Name()))
+}
+
+func (j *journal) recordOffset(i uint32) int64 {
+	return journalSectorSize + int64(i)*int64(len(j.buf))
+}
+
+// recoverJournal rolls back the hot journal for db, if one exists, and
+// removes it. Returns true if a journal was rolled back.
+func recoverJournal(db *os.File) (bool, error) {
+	path := JournalPath(db.Name())
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := rollbackJournal(db, f); err != nil {
+		return false, fmt.Errorf("rollback journal: %w", err)
+	}
+
+	_ = f.Close()
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("remove journal: %w", err)
+	} else if err := syncDir(filepath.Dir(path)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rollbackJournal writes the pages recorded in the journal f back to db,
+// truncates db to its original size & syncs it.
+//
+// SQLite may write a journal as several segments, each starting with a header
+// on a sector boundary. Playback stops at the first header which is invalid,
+// such as a header not yet synced, or at the first record with an invalid
+// checksum. A journal without a valid header is ignored as the database is not
+// modified until the header is synced.
+func rollbackJournal(db, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var dbSize, pageSize uint32
+	var off int64
+	var buf []byte
+	hdr := make([]byte, journalHeaderSize)
+	for i := 0; off+journalHeaderSize <= fi.Size(); i++ {
+		if _, err := f.ReadAt(hdr, off); err != nil {
+			return fmt.Errorf("read journal header: %w", err)
+		} else if !bytes.Equal(hdr[0:8], journalMagic) {
+			break
+		}
+
+		n := binary.BigEndian.Uint32(hdr[8:])
+		nonce := binary.BigEndian.Uint32(hdr[12:])
+		sectorSize := binary.BigEndian.Uint32(hdr[20:])
+
+		// The original database size & page size are taken from the first header.
+		if i == 0 {
+			dbSize, pageSize = binary.BigEndian.Uint32(hdr[16:]), binary.BigEndian.Uint32(hdr[24:])
+			if !IsValidPageSize(pageSize) {
+				return fmt.Errorf("invalid journal page size: %d", pageSize)
+			}
+			buf = make([]byte, 4+pageSize+4)
+		}
+		if sectorSize < journalHeaderSize || sectorSize > MaxPageSize {
+			return fmt.Errorf("invalid journal sector size: %d", sectorSize)
+		}
+		off += int64(sectorSize)
+
+		for ; n > 0; n-- {
+			if _, err := f.ReadAt(buf, off); errors.Is(err, io.EOF) {
+				return truncateJournaledDB(db, dbSize, pageSize)
+			} else if err != nil {
+				return fmt.Errorf("read journal record: %w", err)
+			}
+			off += int64(len(buf))
+
+			pgno := binary.BigEndian.Uint32(buf[0:4])
+			data := buf[4 : 4+pageSize]
+			if pgno == 0 || binary.BigEndian.Uint32(buf[4+pageSize:]) != journalChecksum(nonce, data) {
+				return truncateJournaledDB(db, dbSize, pageSize)
+			}
+			if _, err := db.WriteAt(data, int64(pgno-1)*int64(pageSize)); err != nil {
+				return fmt.Errorf("restore page %d: %w", pgno, err)
+			}
+		}
+
+		// The next segment starts on the following sector boundary.
+		off = (off + int64(sectorSize) - 1) / int64(sectorSize) * int64(sectorSize)
+	}
+
+	if pageSize == 0 {
+		return nil // no valid header, never synced
+	}
+	return truncateJournaledDB(db, dbSize, pageSize)
+}
+
+// truncateJournaledDB truncates db to its original size after playback & syncs it.
+func truncateJournaledDB(db *os.File, dbSize, pageSize uint32) error {
+	if err := db.Truncate(int64(dbSize) * int64(pageSize)); err != nil {
+		return fmt.Errorf("truncate database: %w", err)
+	}
+	return db.Sync()
+}
+
+// journalChecksum computes the checksum of a journal record in the same way
+// as SQLite by summing every 200th byte of the page, starting from the end.
+func journalChecksum(nonce uint32, data []byte) uint32 {
+	chksum := nonce
+	for i := len(data) - 200; i > 0; i -= 200 {
+		chksum += uint32(data[i])
+	}
+	return chksum
+}
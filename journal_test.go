@@ -0,0 +1,180 @@
+package ltx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverJournal(t *testing.T) {
+	const pageSize = 512
+
+	t.Run("OK", func(t *testing.T) {
+		db, want := createJournalTestDB(t, 3)
+
+		j, err := createJournal(db, pageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pgno := range []uint32{1, 3, 3} {
+			if err := j.add(pgno); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := j.sync(); err != nil {
+			t.Fatal(err)
+		} else if got, want := j.n, uint32(2); got != want {
+			t.Fatalf("n=%d, want %d", got, want)
+		}
+
+		// Overwrite & extend the database as if the process crashed mid-apply.
+		for _, pgno := range []int64{1, 3, 4} {
+			if _, err := db.WriteAt(bytes.Repeat([]byte{0xff}, pageSize), (pgno-1)*pageSize); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = j.f.Close()
+
+		if ok, err := recoverJournal(db); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected rollback")
+		}
+		assertJournalTestDB(t, db, want)
+	})
+
+	t.Run("NoJournal", func(t *testing.T) {
+		db, want := createJournalTestDB(t, 1)
+		if ok, err := recoverJournal(db); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("unexpected rollback")
+		}
+		assertJournalTestDB(t, db, want)
+	})
+
+	// SQLite writes a new header for each sync of the journal. The header of
+	// the last segment is zeroed until synced so it must be ignored.
+	t.Run("Segments", func(t *testing.T) {
+		db, want := createJournalTestDB(t, 3)
+
+		var buf bytes.Buffer
+		writeJournalTestSegment(&buf, 3, []uint32{1}, want)
+		writeJournalTestSegment(&buf, 3, []uint32{2}, want)
+		off := buf.Len()
+		writeJournalTestSegment(&buf, 3, []uint32{3}, bytes.Repeat([]byte{0xee}, 3*pageSize))
+		clear(buf.Bytes()[off : off+8]) // unsynced header
+		writeJournalTestFile(t, db, buf.Bytes())
+
+		if _, err := db.WriteAt(bytes.Repeat([]byte{0xff}, 2*pageSize), 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := recoverJournal(db); err != nil {
+			t.Fatal(err)
+		}
+		assertJournalTestDB(t, db, want)
+	})
+
+	t.Run("InvalidChecksum", func(t *testing.T) {
+		db, want := createJournalTestDB(t, 2)
+
+		var buf bytes.Buffer
+		writeJournalTestSegment(&buf, 2, []uint32{1, 2}, want)
+		buf.Bytes()[journalSectorSize+2*(4+pageSize+4)-1]++ // corrupt checksum of page 2
+		writeJournalTestFile(t, db, buf.Bytes())
+
+		if _, err := db.WriteAt(bytes.Repeat([]byte{0xff}, 2*pageSize), 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := recoverJournal(db); err != nil {
+			t.Fatal(err)
+		}
+		assertJournalTestDB(t, db, append(want[:pageSize:pageSize], bytes.Repeat([]byte{0xff}, pageSize)...))
+	})
+
+	t.Run("ErrHotJournal", func(t *testing.T) {
+		db, _ := createJournalTestDB(t, 1)
+		writeJournalTestFile(t, db, nil)
+		if _, err := createJournal(db, pageSize); !errors.Is(err, os.ErrExist) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestJournalChecksum(t *testing.T) {
+	data := make([]byte, 1024)
+	data[824], data[624], data[424], data[224], data[24] = 1, 2, 3, 4, 5
+	data[0] = 100 // not included
+	if got, want := journalChecksum(10, data), uint32(25); got != want {
+		t.Fatalf("checksum=%d, want %d", got, want)
+	}
+}
+
+// createJournalTestDB creates a database of n pages with distinct contents.
+func createJournalTestDB(tb testing.TB, n int) (*os.File, []byte) {
+	tb.Helper()
+
+	data := make([]byte, n*512)
+	for i := range data {
+		data[i] = byte(i / 512 * 17)
+	}
+
+	path := filepath.Join(tb.TempDir(), "db")
+	if err := os.WriteFile(path, data, 0o666); err != nil {
+		tb.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = f.Close() })
+	return f, data
+}
+
+// writeJournalTestSegment writes a journal header for a database of dbSize
+// pages followed by records for pgnos using data as the original database.
+func writeJournalTestSegment(buf *bytes.Buffer, dbSize uint32, pgnos []uint32, data []byte) {
+	const pageSize, nonce = 512, 1234
+
+	hdr := make([]byte, journalSectorSize)
+	copy(hdr, journalMagic)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(pgnos)))
+	binary.BigEndian.PutUint32(hdr[12:], nonce)
+	binary.BigEndian.PutUint32(hdr[16:], dbSize)
+	binary.BigEndian.PutUint32(hdr[20:], journalSectorSize)
+	binary.BigEndian.PutUint32(hdr[24:], pageSize)
+	buf.Write(hdr)
+
+	for _, pgno := range pgnos {
+		page := data[(pgno-1)*pageSize : pgno*pageSize]
+		_ = binary.Write(buf, binary.BigEndian, pgno)
+		buf.Write(page)
+		_ = binary.Write(buf, binary.BigEndian, journalChecksum(nonce, page))
+	}
+
+	// Pad to the next sector.
+	if n := buf.Len() % journalSectorSize; n != 0 {
+		buf.Write(make([]byte, journalSectorSize-n))
+	}
+}
+
+func writeJournalTestFile(tb testing.TB, db *os.File, data []byte) {
+	tb.Helper()
+	if err := os.WriteFile(JournalPath(db.Name()), data, 0o666); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func assertJournalTestDB(tb testing.TB, db *os.File, want []byte) {
+	tb.Helper()
+	if buf, err := os.ReadFile(db.Name()); err != nil {
+		tb.Fatal(err)
+	} else if !bytes.Equal(buf, want) {
+		tb.Fatalf("database mismatch: len=%d, want %d", len(buf), len(want))
+	} else if _, err := os.Stat(JournalPath(db.Name())); !os.IsNotExist(err) {
+		tb.Fatalf("expected journal to be removed: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package ltx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/superfly/ltx/internal/sqlitepage"
+)
+
+// SQLite lock byte offsets. The lock bytes are within the lock page, see
+// LockPgno(), so they never contain page data.
+const (
+	reservedByte = PENDING_BYTE + 1
+	sharedFirst  = PENDING_BYTE + 2
+	sharedSize   = 510
+)
+
+// lockRetryInterval is the time between attempts to acquire a busy lock.
+const lockRetryInterval = 10 * time.Millisecond
+
+// DefaultBusyTimeout is the default time to wait for SQLite connections to
+// release their locks on the database.
+const DefaultBusyTimeout = 5 * time.Second
+
+// ErrDatabaseLocked is returned when a SQLite connection holds a lock which
+// prevents the database from being modified.
+var ErrDatabaseLocked = errors.New("database is locked")
+
+// ErrWALMode is returned when applying to a database in WAL mode.
+var ErrWALMode = errors.New("database is in WAL mode")
+
+// WALMode specifies how a database in WAL mode is handled when applying.
+type WALMode int
+
+const (
+	// WALModeError refuses to apply to a database in WAL mode.
+	WALModeError WALMode = iota
+
+	// WALModeRemove removes the -wal & -shm files before applying. Any
+	// transactions in the WAL which have not been checkpointed are lost.
+	WALModeRemove
+)
+
+// ParseWALMode parses the name of a WAL mode.
+func ParseWALMode(s string) (WALMode, error) {
+	switch s {
+	case "error":
+		return WALModeError, nil
+	case "remove":
+		return WALModeRemove, nil
+	default:
+		return 0, fmt.Errorf("invalid wal mode: %q", s)
+	}
+}
+
+// String returns the name of the WAL mode.
+func (m WALMode) String() string {
+	switch m {
+	case WALModeError:
+		return "error"
+	case WALModeRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("WALMode<%d>", int(m))
+	}
+}
+
+// WALPath returns the path of the write-ahead log for a database path.
+func WALPath(dbPath string) string { return dbPath + "-wal" }
+
+// SHMPath returns the path of the WAL shared-memory index for a database path.
+func SHMPath(dbPath string) string { return dbPath + "-shm" }
+
+// lockDatabase acquires SQLite's RESERVED, PENDING & EXCLUSIVE locks on f in
+// the same order as SQLite. Busy locks are retried until timeout elapses.
+func lockDatabase(f *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, r := range []struct{ start, n int64 }{
+		{reservedByte, 1},
+		{PENDING_BYTE, 1},
+		{sharedFirst, sharedSize},
+	} {
+		for {
+			err := lockRange(f, r.start, r.n)
+			if err == nil {
+				break
+			} else if !errors.Is(err, ErrDatabaseLocked) || !time.Now().Before(deadline) {
+				_ = unlockDatabase(f)
+				return err
+			}
+			time.Sleep(lockRetryInterval)
+		}
+	}
+	return nil
+}
+
+// checkWALMode returns an error if the database at path is in WAL mode and
+// mode is WALModeError. Otherwise removes the -wal & -shm files.
+//
+// SQLite uses WAL mode whenever a -wal file exists so the database is treated
+// as being in WAL mode if the file exists, regardless of the database header.
+// The files are only removed while the database is exclusively locked as
+// SQLite holds a shared lock while a WAL-mode database is open.
+func checkWALMode(path string, mode WALMode) error {
+	if _, err := os.Stat(WALPath(path)); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch mode {
+	case WALModeError:
+		return fmt.Errorf("%w: %s exists", ErrWALMode, WALPath(path))
+	case WALModeRemove:
+		for _, filename := range []string{WALPath(path), SHMPath(path)} {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid wal mode: %s", mode)
+	}
+}
+
+// readSQLiteHeader reads the SQLite database header from f. Returns nil if
+// the file does not start with a SQLite database header.
+func readSQLiteHeader(f *os.File) ([]byte, error) {
+	hdr := make([]byte, sqlitepage.HeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read database header: %w", err)
+	} else if !sqlitepage.IsDatabaseHeader(hdr) {
+		return nil, nil
+	}
+	return hdr, nil
+}
+
+// restoreChangeCounter returns a copy of page 1 with the change counter set to
+// the version-valid-for number. SQLite sets both to the same value on each
+// commit so this recovers the page before the counter was incremented by the
+// Applier. Returns nil if the page does not need to be restored, or if the
+// counter is not ahead of the version-valid-for number, in which case it was
+// not incremented by the Applier.
+func restoreChangeCounter(page []byte) []byte {
+	if !sqlitepage.IsDatabaseHeader(page) {
+		return nil
+	}
+
+	counter := binary.BigEndian.Uint32(page[sqlitepage.ChangeCounterOffset:])
+	validFor := binary.BigEndian.Uint32(page[sqlitepage.VersionValidForOffset:])
+	if int32(counter-validFor) <= 0 {
+		return nil
+	}
+
+	other := bytes.Clone(page)
+	binary.BigEndian.PutUint32(other[sqlitepage.ChangeCounterOffset:], validFor)
+	return other
+}
+
+// incrementChangeCounter returns the change counter in hdr plus one.
+func incrementChangeCounter(hdr []byte) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, binary.BigEndian.Uint32(hdr[sqlitepage.ChangeCounterOffset:])+1)
+	return b
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package ltx
+
+import (
+	"errors"
+	"os"
+)
+
+// LockingSupported reports whether SQLite-compatible locks can be held while
+// applying files, see Applier.Locking.
+const LockingSupported = false
+
+// lockRange returns errors.ErrUnsupported as SQLite's POSIX advisory locks
+// are unavailable.
+func lockRange(f *os.File, start, n int64) error { return errors.ErrUnsupported }
+
+// unlockDatabase returns errors.ErrUnsupported as SQLite's POSIX advisory
+// locks are unavailable.
+func unlockDatabase(f *os.File) error { return errors.ErrUnsupported }
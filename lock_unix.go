@@ -0,0 +1,38 @@
+//go:build unix
+
+package ltx
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// LockingSupported reports whether SQLite-compatible locks can be held while
+// applying files, see Applier.Locking.
+const LockingSupported = true
+
+// lockRange acquires a POSIX advisory write lock on n bytes of f starting at
+// start. Returns ErrDatabaseLocked if another process holds a conflicting lock.
+func lockRange(f *os.File, start, n int64) error {
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+		Start:  start,
+		Len:    n,
+	})
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return ErrDatabaseLocked
+	}
+	return err
+}
+
+// unlockDatabase releases all SQLite locks held on f.
+func unlockDatabase(f *os.File) error {
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{
+		Type:   syscall.F_UNLCK,
+		Whence: io.SeekStart,
+		Start:  PENDING_BYTE,
+		Len:    sharedFirst + sharedSize - PENDING_BYTE,
+	})
+}
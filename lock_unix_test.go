@@ -0,0 +1,86 @@
+//go:build unix
+
+package ltx_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestApplier_Apply_Locked(t *testing.T) {
+	a, f := newTestApplier(t)
+	a.Locking, a.BusyTimeout = true, 50*time.Millisecond
+
+	// POSIX locks do not conflict within a process so hold a SQLite reader's
+	// shared lock from another process.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApplierLockHelper$")
+	cmd.Env = append(os.Environ(), "LTX_TEST_LOCK_PATH="+f.Name())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = stdin.Close(); _ = cmd.Wait() })
+
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	} else if line != "locked\n" {
+		t.Fatalf("unexpected helper output: %q", line)
+	}
+
+	if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); !errors.Is(err, ltx.ErrDatabaseLocked) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Apply once the reader has released its lock.
+	_ = stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Apply(bytes.NewReader(ltxtest.EncodeFile(t, 0, 1, 2))); err != nil {
+		t.Fatal(err)
+	}
+	assertApplierDB(t, f, bytes.Repeat([]byte{2}, 512))
+}
+
+// TestApplierLockHelper holds a shared lock on the database, like a SQLite
+// reader, until stdin is closed. Only runs as a subprocess.
+func TestApplierLockHelper(t *testing.T) {
+	path := os.Getenv("LTX_TEST_LOCK_PATH")
+	if path == "" {
+		t.Skip("helper process")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{
+		Type:   syscall.F_RDLCK,
+		Whence: io.SeekStart,
+		Start:  ltx.PENDING_BYTE + 2,
+		Len:    510,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout.WriteString("locked\n")
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
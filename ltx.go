@@ -4,7 +4,9 @@ package ltx
 import (
 	"bytes"
 	"cmp"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +14,7 @@ import (
 	"regexp"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -42,9 +45,14 @@ const (
 
 // Errors
 var (
-	ErrInvalidFile   = errors.New("invalid LTX file")
-	ErrDecoderClosed = errors.New("ltx decoder closed")
-	ErrEncoderClosed = errors.New("ltx encoder closed")
+	ErrInvalidFile    = errors.New("invalid LTX file")
+	ErrDecoderClosed  = errors.New("ltx decoder closed")
+	ErrEncoderClosed  = errors.New("ltx encoder closed")
+	ErrEncoderAborted = errors.New("ltx encoder aborted")
+
+	ErrDatabaseIDMismatch = errors.New("database id mismatch")
+	ErrNoRestorePath      = errors.New("no restore path")
+	ErrFreePagesOmitted   = errors.New("incremental file cannot follow a snapshot with free pages omitted")
 
 	ErrNoChecksum            = errors.New("no file checksum")
 	ErrInvalidChecksumFormat = errors.New("invalid file checksum format")
@@ -56,10 +64,11 @@ const ChecksumFlag Checksum = 1 << 63
 
 // internal reader/writer states
 const (
-	stateHeader = "header"
-	statePage   = "page"
-	stateClose  = "close"
-	stateClosed = "closed"
+	stateHeader  = "header"
+	statePage    = "page"
+	stateClose   = "close"
+	stateClosed  = "closed"
+	stateAborted = "aborted"
 )
 
 // Pos represents the transactional position of a database.
@@ -168,28 +177,123 @@ func (t *TXID) UnmarshalJSON(data []byte) (err error) {
 	return nil
 }
 
+// DatabaseID represents a UUID which identifies the lineage of a database.
+// All LTX files produced for the same database share the same ID.
+type DatabaseID [16]byte
+
+// NewDatabaseID returns a new, randomly generated (version 4) database ID.
+func NewDatabaseID() (DatabaseID, error) {
+	var id DatabaseID
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40 // version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return id, nil
+}
+
+// ParseDatabaseID parses a database ID from its canonical UUID representation.
+func ParseDatabaseID(s string) (DatabaseID, error) {
+	var id DatabaseID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, fmt.Errorf("invalid formatted database id: %q", s)
+	}
+	if _, err := hex.Decode(id[:], []byte(s[0:8]+s[9:13]+s[14:18]+s[19:23]+s[24:])); err != nil {
+		return id, fmt.Errorf("invalid formatted database id: %q", s)
+	}
+	return id, nil
+}
+
+// String returns id in its canonical UUID representation.
+func (id DatabaseID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// IsZero returns true if the ID is unset.
+func (id DatabaseID) IsZero() bool {
+	return id == (DatabaseID{})
+}
+
+func (id DatabaseID) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + id.String() + `"`), nil
+}
+
+func (id *DatabaseID) UnmarshalJSON(data []byte) (err error) {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("cannot unmarshal database id from JSON value")
+	}
+
+	// Set to zero if value is nil.
+	if s == nil {
+		*id = DatabaseID{}
+		return nil
+	}
+
+	other, err := ParseDatabaseID(*s)
+	if err != nil {
+		return fmt.Errorf("cannot parse database id from JSON string: %q", *s)
+	}
+	*id = other
+
+	return nil
+}
+
+// CheckDatabaseID returns ErrDatabaseIDMismatch if a & b belong to different
+// database lineages. A zero ID is treated as unset and matches any other ID so
+// that files written before IDs were introduced can still be used.
+func CheckDatabaseID(a, b DatabaseID) error {
+	if a.IsZero() || b.IsZero() || a == b {
+		return nil
+	}
+	return fmt.Errorf("%w: %s <> %s", ErrDatabaseIDMismatch, a, b)
+}
+
+// preApplyChecksumError returns an error for a database checksum which does
+// not match the pre-apply checksum of the next file. If the database was
+// restored from a snapshot with free pages omitted then the error wraps
+// ErrFreePagesOmitted as its free pages no longer match the source database.
+func preApplyChecksumError(chksum, want Checksum, freePages bool) error {
+	if freePages {
+		return fmt.Errorf("%w: pre-apply checksum mismatch: %s <> %s", ErrFreePagesOmitted, chksum, want)
+	}
+	return fmt.Errorf("pre-apply checksum mismatch: %s <> %s", chksum, want)
+}
+
 // Header flags.
 const (
-	HeaderFlagMask = uint32(HeaderFlagNoChecksum)
+	HeaderFlagMask = uint32(HeaderFlagNoChecksum | HeaderFlagShard | HeaderFlagFreePages)
 
 	HeaderFlagNoChecksum = uint32(1 << 1)
+	HeaderFlagShard      = uint32(1 << 2)
+
+	// HeaderFlagFreePages indicates that a snapshot may contain free page
+	// frames. Its post-apply checksum is of the database with those pages
+	// zeroed so the snapshot is terminal: incremental files from the source
+	// database cannot follow it unless the source's free pages are also
+	// zeroed, and a new snapshot is needed to continue.
+	HeaderFlagFreePages = uint32(1 << 3)
 )
 
 // Header represents the header frame of an LTX file.
 type Header struct {
-	Version          int      // based on magic
-	Flags            uint32   // reserved flags
-	PageSize         uint32   // page size, in bytes
-	Commit           uint32   // db size after transaction, in pages
-	MinTXID          TXID     // minimum transaction ID
-	MaxTXID          TXID     // maximum transaction ID
-	Timestamp        int64    // milliseconds since unix epoch
-	PreApplyChecksum Checksum // rolling checksum of database before applying this LTX file
-	WALOffset        int64    // file offset from original WAL; zero if journal
-	WALSize          int64    // size of original WAL segment; zero if journal
-	WALSalt1         uint32   // header salt-1 from original WAL; zero if journal or compaction
-	WALSalt2         uint32   // header salt-2 from original WAL; zero if journal or compaction
-	NodeID           uint64   // node id where the LTX file was created, zero if unset
+	Version          int        // based on magic
+	Flags            uint32     // reserved flags
+	PageSize         uint32     // page size, in bytes
+	Commit           uint32     // db size after transaction, in pages
+	MinTXID          TXID       // minimum transaction ID
+	MaxTXID          TXID       // maximum transaction ID
+	Timestamp        int64      // milliseconds since unix epoch
+	PreApplyChecksum Checksum   // rolling checksum of database before applying this LTX file
+	WALOffset        int64      // file offset from original WAL; zero if journal
+	WALSize          int64      // size of original WAL segment; zero if journal
+	WALSalt1         uint32     // header salt-1 from original WAL; zero if journal or compaction
+	WALSalt2         uint32     // header salt-2 from original WAL; zero if journal or compaction
+	ShardMinPgno     uint32     // first page number covered by shard; stored in WALSalt1 field
+	ShardMaxPgno     uint32     // last page number covered by shard; stored in WALSalt2 field
+	NodeID           uint64     // node id where the LTX file was created, zero if unset
+	DatabaseID       DatabaseID // database lineage identifier, zero if unset
+	Timeline         uint32     // timeline the file belongs to, zero for the original timeline
 }
 
 // IsSnapshot returns true if header represents a complete database snapshot.
@@ -224,6 +328,9 @@ func (h *Header) Validate() error {
 	if h.MinTXID > h.MaxTXID {
 		return fmt.Errorf("transaction ids out of order: (%d,%d)", h.MinTXID, h.MaxTXID)
 	}
+	if h.HasFreePages() && !h.IsSnapshot() {
+		return fmt.Errorf("free pages only allowed in snapshots")
+	}
 
 	if h.WALOffset < 0 {
 		return fmt.Errorf("wal offset cannot be negative: %d", h.WALOffset)
@@ -242,6 +349,22 @@ func (h *Header) Validate() error {
 		return fmt.Errorf("wal offset required if wal size exists")
 	}
 
+	// Shards store their page range in the WAL salt fields so WAL fields
+	// cannot be used. The ranges of all shards in a set partition [1,commit].
+	if h.IsShard() {
+		if h.WALOffset != 0 || h.WALSize != 0 || h.WALSalt1 != 0 || h.WALSalt2 != 0 {
+			return fmt.Errorf("wal fields not allowed on shards")
+		}
+		if h.ShardMinPgno == 0 || h.ShardMinPgno > h.ShardMaxPgno {
+			return fmt.Errorf("invalid shard page range: (%d,%d)", h.ShardMinPgno, h.ShardMaxPgno)
+		}
+		if h.ShardMaxPgno > h.Commit {
+			return fmt.Errorf("shard page range (%d,%d) exceeds commit size %d", h.ShardMinPgno, h.ShardMaxPgno, h.Commit)
+		}
+	} else if h.ShardMinPgno != 0 || h.ShardMaxPgno != 0 {
+		return fmt.Errorf("shard page range requires shard flag")
+	}
+
 	// Snapshots are LTX files which have a minimum TXID of 1. This means they
 	// must have all database pages included in them and they have no previous checksum.
 	if h.IsSnapshot() {
@@ -271,6 +394,48 @@ func (h Header) NoChecksum() bool {
 	return h.Flags&HeaderFlagNoChecksum != 0
 }
 
+// HasFreePages returns true if the LTX file is a snapshot which may contain
+// free page frames. See HeaderFlagFreePages.
+func (h Header) HasFreePages() bool {
+	return h.Flags&HeaderFlagFreePages != 0
+}
+
+// IsShard returns true if the LTX file only contains the pages within its
+// shard page range. A complete set of shards for the same transaction range
+// is equivalent to a single unsharded file.
+func (h Header) IsShard() bool {
+	return h.Flags&HeaderFlagShard != 0
+}
+
+// IsFirstShard returns true if the file is unsharded or is the first shard in a set.
+func (h Header) IsFirstShard() bool {
+	return !h.IsShard() || h.ShardMinPgno == 1
+}
+
+// IsLastShard returns true if the file is unsharded or is the last shard in a set.
+func (h Header) IsLastShard() bool {
+	return !h.IsShard() || h.ShardMaxPgno == h.Commit
+}
+
+// IsNextShard returns true if hdr is the shard immediately following prev
+// within the same set of shards.
+func IsNextShard(prev, hdr Header) bool {
+	return prev.IsShard() && hdr.IsShard() &&
+		prev.Timeline == hdr.Timeline &&
+		prev.MinTXID == hdr.MinTXID &&
+		prev.MaxTXID == hdr.MaxTXID &&
+		prev.Commit == hdr.Commit &&
+		prev.PreApplyChecksum == hdr.PreApplyChecksum &&
+		prev.HasFreePages() == hdr.HasFreePages() &&
+		prev.ShardMaxPgno+1 == hdr.ShardMinPgno
+}
+
+// ContainsPgno returns true if pgno is within the file's shard page range.
+// Always returns true for unsharded files.
+func (h Header) ContainsPgno(pgno uint32) bool {
+	return !h.IsShard() || (pgno >= h.ShardMinPgno && pgno <= h.ShardMaxPgno)
+}
+
 // PreApplyPos returns the replication position before the LTX file is applies.
 func (h Header) PreApplyPos() Pos {
 	return Pos{
@@ -292,9 +457,16 @@ func (h *Header) MarshalBinary() ([]byte, error) {
 	binary.BigEndian.PutUint64(b[40:], uint64(h.PreApplyChecksum))
 	binary.BigEndian.PutUint64(b[48:], uint64(h.WALOffset))
 	binary.BigEndian.PutUint64(b[56:], uint64(h.WALSize))
-	binary.BigEndian.PutUint32(b[64:], h.WALSalt1)
-	binary.BigEndian.PutUint32(b[68:], h.WALSalt2)
+	if h.IsShard() {
+		binary.BigEndian.PutUint32(b[64:], h.ShardMinPgno)
+		binary.BigEndian.PutUint32(b[68:], h.ShardMaxPgno)
+	} else {
+		binary.BigEndian.PutUint32(b[64:], h.WALSalt1)
+		binary.BigEndian.PutUint32(b[68:], h.WALSalt2)
+	}
 	binary.BigEndian.PutUint64(b[72:], h.NodeID)
+	copy(b[80:96], h.DatabaseID[:])
+	binary.BigEndian.PutUint32(b[96:], h.Timeline)
 	return b, nil
 }
 
@@ -313,9 +485,16 @@ func (h *Header) UnmarshalBinary(b []byte) error {
 	h.PreApplyChecksum = Checksum(binary.BigEndian.Uint64(b[40:]))
 	h.WALOffset = int64(binary.BigEndian.Uint64(b[48:]))
 	h.WALSize = int64(binary.BigEndian.Uint64(b[56:]))
-	h.WALSalt1 = binary.BigEndian.Uint32(b[64:])
-	h.WALSalt2 = binary.BigEndian.Uint32(b[68:])
+	if h.IsShard() {
+		h.ShardMinPgno = binary.BigEndian.Uint32(b[64:])
+		h.ShardMaxPgno = binary.BigEndian.Uint32(b[68:])
+	} else {
+		h.WALSalt1 = binary.BigEndian.Uint32(b[64:])
+		h.WALSalt2 = binary.BigEndian.Uint32(b[68:])
+	}
 	h.NodeID = binary.BigEndian.Uint64(b[72:])
+	copy(h.DatabaseID[:], b[80:96])
+	h.Timeline = binary.BigEndian.Uint32(b[96:])
 
 	if string(b[0:4]) != Magic {
 		return ErrInvalidFile
@@ -410,6 +589,18 @@ const (
 	// PageHeaderFlagSize indicates that a 4-byte size field follows the page
 	// header. When set, data uses LZ4 block format (not frame format).
 	PageHeaderFlagSize = uint16(1 << 0)
+
+	// PageHeaderFlagFree indicates that the page is a SQLite freelist leaf
+	// page. The frame has no size field or data and the page is decoded, and
+	// checksummed, as a page of zeros.
+	PageHeaderFlagFree = uint16(1 << 1)
+
+	// PageHeaderFlagZero indicates that the page is all zeros. The frame has
+	// no size field or data. The encoder sets this flag automatically.
+	PageHeaderFlagZero = uint16(1 << 2)
+
+	// pageHeaderFlagMask is the set of all valid page header flags.
+	pageHeaderFlagMask = PageHeaderFlagSize | PageHeaderFlagFree | PageHeaderFlagZero
 )
 
 // PageHeader represents the header for a single page in an LTX file.
@@ -423,13 +614,28 @@ func (h *PageHeader) IsZero() bool {
 	return *h == (PageHeader{})
 }
 
+// IsFree returns true if the frame holds a free page with no data.
+func (h *PageHeader) IsFree() bool {
+	return h.Flags&PageHeaderFlagFree != 0
+}
+
+// HasData returns false if the frame has no size field or data, such as for
+// free & zero pages. These pages are decoded as zeros.
+func (h *PageHeader) HasData() bool {
+	return h.Flags&(PageHeaderFlagFree|PageHeaderFlagZero) == 0
+}
+
 // Validate returns an error if h is invalid.
 func (h *PageHeader) Validate() error {
 	if h.Pgno == 0 {
 		return fmt.Errorf("page number required")
 	}
-	if h.Flags & ^PageHeaderFlagSize != 0 {
+	if h.Flags & ^pageHeaderFlagMask != 0 {
 		return fmt.Errorf("invalid page header flags: 0x%04x", h.Flags)
+	} else if h.Flags&PageHeaderFlagFree != 0 && h.Flags&PageHeaderFlagSize != 0 {
+		return fmt.Errorf("free page header cannot have size flag: 0x%04x", h.Flags)
+	} else if h.Flags&PageHeaderFlagZero != 0 && h.Flags&(PageHeaderFlagSize|PageHeaderFlagFree) != 0 {
+		return fmt.Errorf("zero page header cannot have other flags: 0x%04x", h.Flags)
 	}
 	return nil
 }
@@ -495,6 +701,68 @@ func FormatFilename(minTXID, maxTXID TXID) string {
 	return fmt.Sprintf("%s-%s.ltx", minTXID.String(), maxTXID.String())
 }
 
+var timelineFilenameRegex = regexp.MustCompile(`^(?:([0-9a-f]{8})-)?([0-9a-f]{16})-([0-9a-f]{16})(?:\.([0-9a-f]{8}))?\.ltx$`)
+
+// FormatTimelineFilename returns an LTX filename representing a range of
+// transactions on a timeline. Files on the original timeline (zero) use the
+// same name as FormatFilename so existing stores remain readable.
+func FormatTimelineFilename(timeline uint32, minTXID, maxTXID TXID) string {
+	if timeline == 0 {
+		return FormatFilename(minTXID, maxTXID)
+	}
+	return fmt.Sprintf("%08x-%s-%s.ltx", timeline, minTXID.String(), maxTXID.String())
+}
+
+// ParseTimelineFilename parses a timeline & transaction range from an LTX file.
+// Filenames without a timeline prefix are reported as timeline zero. Shard
+// filenames are rejected; use ParseShardFilename instead.
+func ParseTimelineFilename(name string) (timeline uint32, minTXID, maxTXID TXID, err error) {
+	timeline, minTXID, maxTXID, shardMinPgno, err := ParseShardFilename(name)
+	if err != nil {
+		return 0, 0, 0, err
+	} else if shardMinPgno != 0 {
+		return 0, 0, 0, fmt.Errorf("invalid ltx filename: %s", name)
+	}
+	return timeline, minTXID, maxTXID, nil
+}
+
+// FormatShardFilename returns an LTX filename for a shard starting at
+// shardMinPgno. The first page number is appended as 8 hex digits so that
+// shards in the same set have unique names. If shardMinPgno is zero then the
+// name is the same as FormatTimelineFilename.
+func FormatShardFilename(timeline uint32, minTXID, maxTXID TXID, shardMinPgno uint32) string {
+	name := FormatTimelineFilename(timeline, minTXID, maxTXID)
+	if shardMinPgno == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%08x.ltx", strings.TrimSuffix(name, ".ltx"), shardMinPgno)
+}
+
+// ParseShardFilename parses a timeline, transaction range & shard starting
+// page number from an LTX filename. The shard page number is zero for
+// unsharded files.
+func ParseShardFilename(name string) (timeline uint32, minTXID, maxTXID TXID, shardMinPgno uint32, err error) {
+	a := timelineFilenameRegex.FindStringSubmatch(name)
+	if a == nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid ltx filename: %s", name)
+	}
+
+	if a[1] != "" {
+		v, _ := strconv.ParseUint(a[1], 16, 32)
+		timeline = uint32(v)
+	}
+	min, _ := strconv.ParseUint(a[2], 16, 64)
+	max, _ := strconv.ParseUint(a[3], 16, 64)
+	if a[4] != "" {
+		v, _ := strconv.ParseUint(a[4], 16, 32)
+		if v == 0 {
+			return 0, 0, 0, 0, fmt.Errorf("invalid ltx filename: %s", name)
+		}
+		shardMinPgno = uint32(v)
+	}
+	return timeline, TXID(min), TXID(max), shardMinPgno, nil
+}
+
 const PENDING_BYTE = 0x40000000
 
 // LockPgno returns the page number where the PENDING_BYTE exists.
@@ -545,7 +813,13 @@ func NewFileInfoSliceIterator(a []*FileInfo) *FileInfoSliceIterator {
 		if v := cmp.Compare(x.MinTXID, y.MinTXID); v != 0 {
 			return v
 		}
-		return cmp.Compare(x.MaxTXID, y.MaxTXID)
+		if v := cmp.Compare(x.MaxTXID, y.MaxTXID); v != 0 {
+			return v
+		}
+		if v := cmp.Compare(x.Timeline, y.Timeline); v != 0 {
+			return v
+		}
+		return cmp.Compare(x.ShardMinPgno, y.ShardMinPgno)
 	})
 
 	return &FileInfoSliceIterator{a: a}
@@ -578,14 +852,108 @@ func (itr *FileInfoSliceIterator) Item() *FileInfo {
 // FileInfo represents file information about an LTX file.
 type FileInfo struct {
 	Level             int
+	Timeline          uint32
+	DatabaseID        DatabaseID
 	MinTXID           TXID
 	MaxTXID           TXID
 	PreApplyChecksum  Checksum
 	PostApplyChecksum Checksum
+	NodeID            uint64
+	Commit            uint32
+	ShardMinPgno      uint32
+	ShardMaxPgno      uint32
 	Size              int64
 	CreatedAt         time.Time
 }
 
+// IsShard returns true if the file is one shard of a set of files covering
+// the same transaction range.
+func (info *FileInfo) IsShard() bool {
+	return info.ShardMaxPgno != 0
+}
+
+// groupShards groups files so that each complete set of shards is a single
+// group. Unsharded files are returned as a group of one. Incomplete shard sets
+// are excluded as they cannot be applied. Groups are returned in the order of
+// their first file in a.
+func groupShards(a []*FileInfo) [][]*FileInfo {
+	type key struct {
+		level    int
+		timeline uint32
+		minTXID  TXID
+		maxTXID  TXID
+	}
+
+	var groups [][]*FileInfo
+	sets := make(map[key]int) // index into groups
+	for _, info := range a {
+		if !info.IsShard() {
+			groups = append(groups, []*FileInfo{info})
+			continue
+		}
+
+		k := key{info.Level, info.Timeline, info.MinTXID, info.MaxTXID}
+		if i, ok := sets[k]; ok {
+			groups[i] = append(groups[i], info)
+			continue
+		}
+		sets[k] = len(groups)
+		groups = append(groups, []*FileInfo{info})
+	}
+
+	other := groups[:0]
+	for _, g := range groups {
+		if len(g) > 1 || g[0].IsShard() {
+			slices.SortFunc(g, func(x, y *FileInfo) int { return cmp.Compare(x.ShardMinPgno, y.ShardMinPgno) })
+			if !isCompleteShardSet(g) {
+				continue
+			}
+		}
+		other = append(other, g)
+	}
+	return other
+}
+
+// isCompleteShardSet returns true if a contains every shard in a set, in order.
+func isCompleteShardSet(a []*FileInfo) bool {
+	if a[0].ShardMinPgno != 1 || a[len(a)-1].ShardMaxPgno != a[len(a)-1].Commit {
+		return false
+	}
+	for i := 1; i < len(a); i++ {
+		if !isNextShardFile(a[i-1], a[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNextShardFile returns true if info is the shard immediately following
+// prev within the same set.
+func isNextShardFile(prev, info *FileInfo) bool {
+	return prev.IsShard() && info.IsShard() &&
+		prev.Level == info.Level &&
+		prev.Timeline == info.Timeline &&
+		prev.MinTXID == info.MinTXID &&
+		prev.MaxTXID == info.MaxTXID &&
+		prev.Commit == info.Commit &&
+		prev.ShardMaxPgno+1 == info.ShardMinPgno
+}
+
+// LatestFileInfo returns the file in a with the highest MaxTXID on the highest
+// timeline. A new timeline is only created when a database is restored so files
+// on lower timelines belong to an abandoned history, even if they end at a
+// higher TXID. Returns nil if a is empty.
+func LatestFileInfo(a []*FileInfo) *FileInfo {
+	var latest *FileInfo
+	for _, info := range a {
+		if latest == nil || info.Timeline > latest.Timeline ||
+			(info.Timeline == latest.Timeline && info.MaxTXID > latest.MaxTXID) {
+			latest = info
+		}
+	}
+	return latest
+}
+
 // PreApplyPos returns the replication position before the LTX file is applied.
 func (info *FileInfo) PreApplyPos() Pos {
 	return Pos{
@@ -630,3 +998,172 @@ func (a FileInfoSlice) MaxTXID() TXID {
 func IsContiguous(prevMaxTXID, minTXID, maxTXID TXID) bool {
 	return minTXID <= prevMaxTXID+1 && maxTXID > prevMaxTXID
 }
+
+// TimelineChain returns the files required to rebuild the end of timeline,
+// ordered by transaction ID. Files on timeline are followed back to their
+// branch point, which is the position of a file on the nearest lower timeline
+// that matches the pre-apply position of the timeline's first file. This
+// continues until a snapshot is reached. Files after a branch point on a
+// parent timeline belong to an abandoned history and are never included.
+//
+// The files in a should be from a single level.
+func TimelineChain(a []*FileInfo, timeline uint32) ([]*FileInfo, error) {
+	byTimeline := groupTimelines(a)
+	if len(byTimeline[timeline]) == 0 {
+		return nil, fmt.Errorf("no files on timeline %d", timeline)
+	}
+
+	var chain []*FileInfo
+	for _, h := range timelineHistory(byTimeline, timeline) {
+		var files []*FileInfo
+		for _, info := range byTimeline[h.timeline] {
+			if h.contains(info) {
+				files = append(files, info)
+			}
+		}
+
+		// The history must start from a snapshot.
+		if first := files[0]; len(chain) == 0 && first.MinTXID != 1 {
+			return nil, fmt.Errorf("cannot find parent of timeline %d at %s", h.timeline, first.PreApplyPos())
+		}
+
+		// Ensure files on the timeline form a chain & that the last file ends
+		// exactly where the child timeline branched off.
+		for i := 1; i < len(files); i++ {
+			if isNextShardFile(files[i-1], files[i]) {
+				continue
+			} else if !posMatches(files[i-1].Pos(), files[i].PreApplyPos()) {
+				return nil, fmt.Errorf("non-contiguous files on timeline %d: %s -> %s",
+					h.timeline, files[i-1].Pos(), files[i].PreApplyPos())
+			}
+		}
+		if last := files[len(files)-1]; h.branch != nil && !posMatches(last.Pos(), *h.branch) {
+			return nil, fmt.Errorf("branch point %s not found on timeline %d", *h.branch, h.timeline)
+		}
+		chain = append(chain, files...)
+	}
+	return chain, nil
+}
+
+// timelineFiles returns the files in a which are part of the history of the
+// highest timeline with a file ending at target. Branch points are resolved in
+// the same way as TimelineChain() but files may be from multiple levels so they
+// are not required to form a single chain. Files on other timelines, and files
+// on parent timelines after their branch point, are excluded.
+//
+// Returns a unchanged if no file ends at target.
+func timelineFiles(a []*FileInfo, target Pos) []*FileInfo {
+	var latest *FileInfo
+	for _, info := range a {
+		if posMatches(info.Pos(), target) && (latest == nil || info.Timeline > latest.Timeline) {
+			latest = info
+		}
+	}
+	if latest == nil {
+		return a
+	}
+	return filterTimelineHistory(a, timelineHistory(groupTimelines(a), latest.Timeline))
+}
+
+// timelineBranch represents a timeline in the history of another timeline.
+type timelineBranch struct {
+	timeline uint32
+	branch   *Pos // last position in the history, nil if unbounded
+}
+
+// contains returns true if info on the branch's timeline is in the history.
+func (b timelineBranch) contains(info *FileInfo) bool {
+	return b.branch == nil || info.MaxTXID <= b.branch.TXID
+}
+
+// groupTimelines groups the files in a by timeline & sorts each group by
+// transaction ID.
+func groupTimelines(a []*FileInfo) map[uint32][]*FileInfo {
+	byTimeline := make(map[uint32][]*FileInfo)
+	for _, info := range a {
+		byTimeline[info.Timeline] = append(byTimeline[info.Timeline], info)
+	}
+	for _, files := range byTimeline {
+		slices.SortFunc(files, func(x, y *FileInfo) int {
+			if v := cmp.Compare(x.MinTXID, y.MinTXID); v != 0 {
+				return v
+			}
+			return cmp.Compare(x.ShardMinPgno, y.ShardMinPgno)
+		})
+	}
+	return byTimeline
+}
+
+// timelineHistory returns the timelines which make up the history of timeline,
+// ordered from the oldest timeline to timeline itself. Each timeline is
+// followed back to its parent from the pre-apply position of its first file
+// until a snapshot is reached or the parent's files no longer exist.
+func timelineHistory(byTimeline map[uint32][]*FileInfo, timeline uint32) []timelineBranch {
+	history := []timelineBranch{{timeline: timeline}}
+	for {
+		var first *FileInfo
+		for _, info := range byTimeline[timeline] {
+			if history[0].contains(info) {
+				first = info
+				break
+			}
+		}
+
+		// Exit once we reach the start of the database history.
+		if first == nil || first.MinTXID == 1 {
+			return history
+		}
+
+		// Find nearest lower timeline which contains the branch point.
+		pos := first.PreApplyPos()
+		parent, ok := findBranchTimeline(byTimeline, timeline, pos)
+		if !ok {
+			return history
+		}
+		timeline = parent
+		history = append([]timelineBranch{{timeline: timeline, branch: &pos}}, history...)
+	}
+}
+
+// filterTimelineHistory returns the files in a which are part of history.
+func filterTimelineHistory(a []*FileInfo, history []timelineBranch) []*FileInfo {
+	other := make([]*FileInfo, 0, len(a))
+	for _, info := range a {
+		for _, h := range history {
+			if h.timeline == info.Timeline && h.contains(info) {
+				other = append(other, info)
+				break
+			}
+		}
+	}
+	return other
+}
+
+// findBranchTimeline returns the highest timeline below timeline which has a
+// file ending at pos.
+func findBranchTimeline(m map[uint32][]*FileInfo, timeline uint32, pos Pos) (parent uint32, ok bool) {
+	for id, files := range m {
+		if id >= timeline || (ok && id < parent) {
+			continue
+		}
+		for _, info := range files {
+			if posMatches(info.Pos(), pos) {
+				parent, ok = id, true
+				break
+			}
+		}
+	}
+	return parent, ok
+}
+
+// posMatches returns true if a & b refer to the same position.
+// Checksums are only compared if both are set.
+func posMatches(a, b Pos) bool {
+	if a.TXID != b.TXID {
+		return false
+	}
+	if a.PostApplyChecksum == 0 || b.PostApplyChecksum == 0 {
+		return true
+	}
+	return a.PostApplyChecksum == b.PostApplyChecksum
+}
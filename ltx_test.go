@@ -3,7 +3,9 @@ package ltx_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -86,8 +88,8 @@ func TestHeader_Validate(t *testing.T) {
 		}
 	})
 	t.Run("ErrFlags", func(t *testing.T) {
-		hdr := ltx.Header{Version: ltx.Version, Flags: 1 << 3}
-		if err := hdr.Validate(); err == nil || err.Error() != `invalid flags: 0x00000008` {
+		hdr := ltx.Header{Version: ltx.Version, Flags: 1 << 4}
+		if err := hdr.Validate(); err == nil || err.Error() != `invalid flags: 0x00000010` {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
@@ -115,6 +117,12 @@ func TestHeader_Validate(t *testing.T) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
+	t.Run("ErrFreePagesNotSnapshot", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagFreePages, PageSize: 1024, Commit: 2, MinTXID: 2, MaxTXID: 2}
+		if err := hdr.Validate(); err == nil || err.Error() != `free pages only allowed in snapshots` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
 	t.Run("ErrNegativeWALOffset", func(t *testing.T) {
 		hdr := ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 2, MinTXID: 1, MaxTXID: 1, WALOffset: -1000}
 		if err := hdr.Validate(); err == nil || err.Error() != `wal offset cannot be negative: -1000` {
@@ -157,6 +165,36 @@ func TestHeader_Validate(t *testing.T) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
+	t.Run("Shard", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, PageSize: 1024, Commit: 4, MinTXID: 1, MaxTXID: 3, ShardMinPgno: 3, ShardMaxPgno: 4}
+		if err := hdr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ErrShardWALFields", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, PageSize: 1024, Commit: 4, MinTXID: 1, MaxTXID: 3, WALOffset: 32, ShardMinPgno: 1, ShardMaxPgno: 4}
+		if err := hdr.Validate(); err == nil || err.Error() != `wal fields not allowed on shards` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrInvalidShardPageRange", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, PageSize: 1024, Commit: 4, MinTXID: 1, MaxTXID: 3, ShardMinPgno: 3, ShardMaxPgno: 2}
+		if err := hdr.Validate(); err == nil || err.Error() != `invalid shard page range: (3,2)` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrShardPageRangeExceedsCommit", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, Flags: ltx.HeaderFlagShard, PageSize: 1024, Commit: 4, MinTXID: 1, MaxTXID: 3, ShardMinPgno: 3, ShardMaxPgno: 5}
+		if err := hdr.Validate(); err == nil || err.Error() != `shard page range (3,5) exceeds commit size 4` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrShardFlagRequired", func(t *testing.T) {
+		hdr := ltx.Header{Version: ltx.Version, PageSize: 1024, Commit: 4, MinTXID: 1, MaxTXID: 3, ShardMinPgno: 1, ShardMaxPgno: 4}
+		if err := hdr.Validate(); err == nil || err.Error() != `shard page range requires shard flag` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
 }
 
 func TestHeader_MarshalBinary(t *testing.T) {
@@ -173,6 +211,9 @@ func TestHeader_MarshalBinary(t *testing.T) {
 		WALSalt2:         1013,
 		WALOffset:        1014,
 		WALSize:          1015,
+		NodeID:           1016,
+		DatabaseID:       ltx.DatabaseID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+		Timeline:         1017,
 	}
 
 	var other ltx.Header
@@ -183,6 +224,30 @@ func TestHeader_MarshalBinary(t *testing.T) {
 	} else if !reflect.DeepEqual(hdr, other) {
 		t.Fatalf("mismatch:\ngot=%#v\nwant=%#v", hdr, other)
 	}
+
+	t.Run("Shard", func(t *testing.T) {
+		hdr := ltx.Header{
+			Version:      ltx.Version,
+			Flags:        ltx.HeaderFlagShard,
+			PageSize:     1024,
+			Commit:       1006,
+			MinTXID:      1,
+			MaxTXID:      1008,
+			ShardMinPgno: 1001,
+			ShardMaxPgno: 1006,
+		}
+
+		var other ltx.Header
+		if b, err := hdr.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		} else if got, want := binary.BigEndian.Uint32(b[64:]), uint32(1001); got != want {
+			t.Fatalf("WALSalt1 field=%d, want %d", got, want)
+		} else if err := other.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(hdr, other) {
+			t.Fatalf("mismatch:\ngot=%#v\nwant=%#v", hdr, other)
+		}
+	})
 }
 
 func TestHeader_UnmarshalBinary(t *testing.T) {
@@ -273,9 +338,33 @@ func TestPageHeader_Validate(t *testing.T) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
+	t.Run("Free", func(t *testing.T) {
+		hdr := ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagFree}
+		if err := hdr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Zero", func(t *testing.T) {
+		hdr := ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagZero}
+		if err := hdr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
 	t.Run("ErrFlagsNotAllowed", func(t *testing.T) {
-		hdr := ltx.PageHeader{Pgno: 1, Flags: 4}
-		if err := hdr.Validate(); err == nil || err.Error() != `invalid page header flags: 0x0004` {
+		hdr := ltx.PageHeader{Pgno: 1, Flags: 8}
+		if err := hdr.Validate(); err == nil || err.Error() != `invalid page header flags: 0x0008` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrFreeWithSize", func(t *testing.T) {
+		hdr := ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagFree | ltx.PageHeaderFlagSize}
+		if err := hdr.Validate(); err == nil || err.Error() != `free page header cannot have size flag: 0x0003` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	t.Run("ErrZeroWithSize", func(t *testing.T) {
+		hdr := ltx.PageHeader{Pgno: 1, Flags: ltx.PageHeaderFlagZero | ltx.PageHeaderFlagSize}
+		if err := hdr.Validate(); err == nil || err.Error() != `zero page header cannot have other flags: 0x0005` {
 			t.Fatalf("unexpected error: %s", err)
 		}
 	})
@@ -402,6 +491,160 @@ func TestParseFilename(t *testing.T) {
 	})
 }
 
+func TestFormatTimelineFilename(t *testing.T) {
+	if got, want := ltx.FormatTimelineFilename(0, 1, 1000), "0000000000000001-00000000000003e8.ltx"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+	if got, want := ltx.FormatTimelineFilename(2, 1, 1000), "00000002-0000000000000001-00000000000003e8.ltx"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+}
+
+func TestParseTimelineFilename(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		if timeline, min, max, err := ltx.ParseTimelineFilename("0000000a-0000000000000001-00000000000003e8.ltx"); err != nil {
+			t.Fatal(err)
+		} else if got, want := timeline, uint32(10); got != want {
+			t.Fatalf("timeline=%d, want %d", got, want)
+		} else if got, want := min, ltx.TXID(1); got != want {
+			t.Fatalf("min=%d, want %d", got, want)
+		} else if got, want := max, ltx.TXID(1000); got != want {
+			t.Fatalf("max=%d, want %d", got, want)
+		}
+	})
+	t.Run("NoTimeline", func(t *testing.T) {
+		if timeline, min, max, err := ltx.ParseTimelineFilename("0000000000000001-00000000000003e8.ltx"); err != nil {
+			t.Fatal(err)
+		} else if timeline != 0 || min != 1 || max != 1000 {
+			t.Fatalf("unexpected result: %d, %d, %d", timeline, min, max)
+		}
+	})
+	t.Run("ErrInvalid", func(t *testing.T) {
+		if _, _, _, err := ltx.ParseTimelineFilename("0000000z-0000000000000001-00000000000003e8.ltx"); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, _, _, err := ltx.ParseTimelineFilename("00000001-0000000000000001.ltx"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestFormatShardFilename(t *testing.T) {
+	if got, want := ltx.FormatShardFilename(0, 1, 1000, 0), "0000000000000001-00000000000003e8.ltx"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+	if got, want := ltx.FormatShardFilename(2, 1, 1000, 0x101), "00000002-0000000000000001-00000000000003e8.00000101.ltx"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+}
+
+func TestParseShardFilename(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		if timeline, min, max, shardMinPgno, err := ltx.ParseShardFilename("0000000a-0000000000000001-00000000000003e8.00000101.ltx"); err != nil {
+			t.Fatal(err)
+		} else if timeline != 10 || min != 1 || max != 1000 || shardMinPgno != 0x101 {
+			t.Fatalf("unexpected result: %d, %d, %d, %d", timeline, min, max, shardMinPgno)
+		}
+	})
+	t.Run("NoShard", func(t *testing.T) {
+		if timeline, min, max, shardMinPgno, err := ltx.ParseShardFilename("0000000000000001-00000000000003e8.ltx"); err != nil {
+			t.Fatal(err)
+		} else if timeline != 0 || min != 1 || max != 1000 || shardMinPgno != 0 {
+			t.Fatalf("unexpected result: %d, %d, %d, %d", timeline, min, max, shardMinPgno)
+		}
+	})
+	t.Run("ErrInvalid", func(t *testing.T) {
+		if _, _, _, _, err := ltx.ParseShardFilename("0000000000000001-00000000000003e8.00000000.ltx"); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, _, _, err := ltx.ParseTimelineFilename("0000000000000001-00000000000003e8.00000001.ltx"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestLatestFileInfo(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		a := []*ltx.FileInfo{{MinTXID: 1, MaxTXID: 2}, {MinTXID: 3, MaxTXID: 5}, {MinTXID: 3, MaxTXID: 4}}
+		if got, want := ltx.LatestFileInfo(a), a[1]; got != want {
+			t.Fatalf("LatestFileInfo()=%+v, want %+v", got, want)
+		}
+	})
+
+	// Timeline 0 is restored to TXID 3 & resumed as timeline 1, which has not
+	// yet caught up with the abandoned history.
+	t.Run("Timeline", func(t *testing.T) {
+		a := []*ltx.FileInfo{
+			{MinTXID: 1, MaxTXID: 3},
+			{MinTXID: 4, MaxTXID: 6},
+			{Timeline: 1, MinTXID: 4, MaxTXID: 4},
+			{Timeline: 1, MinTXID: 5, MaxTXID: 5},
+		}
+		if got, want := ltx.LatestFileInfo(a), a[3]; got != want {
+			t.Fatalf("LatestFileInfo()=%+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if info := ltx.LatestFileInfo(nil); info != nil {
+			t.Fatalf("unexpected file: %+v", info)
+		}
+	})
+}
+
+func TestTimelineChain(t *testing.T) {
+	// Timeline 0 runs from 1 to 6 but is restored to TXID 3 and resumed as
+	// timeline 1. Timeline 1 is later restored to TXID 5 as timeline 2.
+	files := []*ltx.FileInfo{
+		{Timeline: 0, MinTXID: 1, MaxTXID: 2, PostApplyChecksum: ltx.ChecksumFlag | 2},
+		{Timeline: 0, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 2, PostApplyChecksum: ltx.ChecksumFlag | 3},
+		{Timeline: 0, MinTXID: 4, MaxTXID: 6, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 6},
+		{Timeline: 1, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 0x14},
+		{Timeline: 1, MinTXID: 5, MaxTXID: 5, PreApplyChecksum: ltx.ChecksumFlag | 0x14, PostApplyChecksum: ltx.ChecksumFlag | 0x15},
+		{Timeline: 1, MinTXID: 6, MaxTXID: 7, PreApplyChecksum: ltx.ChecksumFlag | 0x15, PostApplyChecksum: ltx.ChecksumFlag | 0x17},
+		{Timeline: 2, MinTXID: 6, MaxTXID: 6, PreApplyChecksum: ltx.ChecksumFlag | 0x15, PostApplyChecksum: ltx.ChecksumFlag | 0x26},
+	}
+
+	t.Run("Root", func(t *testing.T) {
+		chain, err := ltx.TimelineChain(files, 0)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := chain, files[0:3]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("chain=%v, want %v", got, want)
+		}
+	})
+	t.Run("Branch", func(t *testing.T) {
+		chain, err := ltx.TimelineChain(files, 1)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := chain, []*ltx.FileInfo{files[0], files[1], files[3], files[4], files[5]}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("chain=%v, want %v", got, want)
+		}
+	})
+	t.Run("NestedBranch", func(t *testing.T) {
+		chain, err := ltx.TimelineChain(files, 2)
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := chain, []*ltx.FileInfo{files[0], files[1], files[3], files[4], files[6]}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("chain=%v, want %v", got, want)
+		}
+	})
+	t.Run("ErrNoFiles", func(t *testing.T) {
+		if _, err := ltx.TimelineChain(files, 3); err == nil || err.Error() != `no files on timeline 3` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("ErrBranchPointNotFound", func(t *testing.T) {
+		other := []*ltx.FileInfo{
+			files[0],
+			{Timeline: 1, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 9, PostApplyChecksum: ltx.ChecksumFlag | 0x13},
+		}
+		if _, err := ltx.TimelineChain(other, 1); err == nil || err.Error() != `cannot find parent of timeline 1 at 0000000000000002/8000000000000009` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestChecksumReader(t *testing.T) {
 	t.Run("OK", func(t *testing.T) {
 		r := io.MultiReader(
@@ -482,6 +725,85 @@ func TestTXID_UnmarshalJSON(t *testing.T) {
 	})
 }
 
+func TestDatabaseID_String(t *testing.T) {
+	id := ltx.DatabaseID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10}
+	if got, want := id.String(), "01234567-89ab-cdef-fedc-ba9876543210"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+	if got, want := (ltx.DatabaseID{}).String(), "00000000-0000-0000-0000-000000000000"; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+}
+
+func TestParseDatabaseID(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		if v, err := ltx.ParseDatabaseID("01234567-89ab-cdef-fedc-ba9876543210"); err != nil {
+			t.Fatal(err)
+		} else if got, want := v, (ltx.DatabaseID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10}); got != want {
+			t.Fatalf("got=%s, want %s", got, want)
+		}
+	})
+	t.Run("ErrFormat", func(t *testing.T) {
+		if _, err := ltx.ParseDatabaseID("0123456789abcdeffedcba9876543210"); err == nil || err.Error() != `invalid formatted database id: "0123456789abcdeffedcba9876543210"` {
+			t.Fatal(err)
+		}
+	})
+	t.Run("ErrHex", func(t *testing.T) {
+		if _, err := ltx.ParseDatabaseID("0123456x-89ab-cdef-fedc-ba9876543210"); err == nil || err.Error() != `invalid formatted database id: "0123456x-89ab-cdef-fedc-ba9876543210"` {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestNewDatabaseID(t *testing.T) {
+	id, err := ltx.NewDatabaseID()
+	if err != nil {
+		t.Fatal(err)
+	} else if id.IsZero() {
+		t.Fatal("expected non-zero id")
+	}
+
+	if other, err := ltx.ParseDatabaseID(id.String()); err != nil {
+		t.Fatal(err)
+	} else if other != id {
+		t.Fatalf("round trip mismatch: %s <> %s", other, id)
+	}
+}
+
+func TestDatabaseID_JSON(t *testing.T) {
+	id := ltx.DatabaseID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10}
+	buf, err := json.Marshal(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := string(buf), `"01234567-89ab-cdef-fedc-ba9876543210"`; got != want {
+		t.Fatalf("got=%q, want %q", got, want)
+	}
+
+	var other ltx.DatabaseID
+	if err := json.Unmarshal(buf, &other); err != nil {
+		t.Fatal(err)
+	} else if other != id {
+		t.Fatalf("got=%s, want %s", other, id)
+	}
+}
+
+func TestCheckDatabaseID(t *testing.T) {
+	a := ltx.DatabaseID{1}
+	b := ltx.DatabaseID{2}
+	if err := ltx.CheckDatabaseID(a, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := ltx.CheckDatabaseID(a, ltx.DatabaseID{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ltx.CheckDatabaseID(ltx.DatabaseID{}, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ltx.CheckDatabaseID(a, b); !errors.Is(err, ltx.ErrDatabaseIDMismatch) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestTXID_String(t *testing.T) {
 	if got, want := ltx.TXID(0).String(), "0000000000000000"; got != want {
 		t.Fatalf("got=%q, want %q", got, want)
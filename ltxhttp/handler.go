@@ -0,0 +1,319 @@
+// Package ltxhttp provides an HTTP interface to the LTX files in a store.
+package ltxhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/superfly/ltx"
+)
+
+// Header names set on page responses.
+const (
+	HeaderPos      = "Ltx-Pos"
+	HeaderPageSize = "Ltx-Page-Size"
+)
+
+// Handler represents an HTTP handler that serves the LTX files in a store.
+//
+//	GET /pos                              latest position, as JSON
+//	GET /files?level=N&min=TXID&max=TXID  files in the store, as JSON
+//	GET /files/{level}/{filename}         raw LTX file, supports Range
+//	GET /page?pgno=N&txid=TXID            decoded page data at a position
+//
+// All query parameters are optional except pgno. The level defaults to all
+// levels, TXID ranges are unbounded and pages are read from the latest
+// position if no TXID is specified.
+//
+// The database reader for the latest position is reused between page requests
+// until the latest position changes. Call Close() to release it.
+type Handler struct {
+	store ltx.Store
+	mux   *http.ServeMux
+
+	mu     sync.Mutex
+	reader *pageReader // reader at the latest position, if any
+}
+
+// NewHandler returns a new instance of Handler for store.
+func NewHandler(store ltx.Store) *Handler {
+	h := &Handler{
+		store: store,
+		mux:   http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /pos", h.handleGetPos)
+	h.mux.HandleFunc("GET /files", h.handleGetFiles)
+	h.mux.HandleFunc("GET /files/{level}/{filename}", h.handleGetFile)
+	h.mux.HandleFunc("GET /page", h.handleGetPage)
+	return h
+}
+
+// Close releases the cached database reader. Requests which are still reading
+// pages are not affected.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pr := h.reader
+	h.reader = nil
+	if pr == nil {
+		return nil
+	}
+	return pr.releaseLocked()
+}
+
+// ServeHTTP handles an HTTP request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handleGetPos(w http.ResponseWriter, r *http.Request) {
+	infos, err := h.list(r, -1, 0, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	latest := ltx.LatestFileInfo(infos)
+	if latest == nil {
+		writeError(w, fmt.Errorf("no files: %w", fs.ErrNotExist))
+		return
+	}
+	writeJSON(w, latest.Pos())
+}
+
+func (h *Handler) handleGetFiles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	level := -1
+	if s := q.Get("level"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			writeError(w, badRequestError("invalid level: %q", s))
+			return
+		}
+		level = v
+	}
+
+	minTXID, err := parseTXIDParam(q.Get("min"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	maxTXID, err := parseTXIDParam(q.Get("max"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	infos, err := h.list(r, level, minTXID, maxTXID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if infos == nil {
+		infos = []*ltx.FileInfo{}
+	}
+	writeJSON(w, infos)
+}
+
+func (h *Handler) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	name := "ltx/" + r.PathValue("level") + "/" + r.PathValue("filename")
+	f, err := ltx.NewStoreFS(r.Context(), h.store).Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Range requests require seeking so buffer files from stores which
+	// cannot seek.
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		buf, err := io.ReadAll(f)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		rs = bytes.NewReader(buf)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), rs)
+}
+
+func (h *Handler) handleGetPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	pgno, err := strconv.ParseUint(q.Get("pgno"), 10, 32)
+	if err != nil || pgno == 0 {
+		writeError(w, badRequestError("invalid pgno: %q", q.Get("pgno")))
+		return
+	}
+
+	txID, err := parseTXIDParam(q.Get("txid"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Default to the latest position if no TXID is specified.
+	var rd *pageReader
+	if txID != 0 {
+		rd, err = h.openReader(r.Context(), ltx.Pos{TXID: txID})
+	} else {
+		rd, err = h.latestReader(r)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer h.release(rd)
+
+	if uint32(pgno) > rd.Commit() {
+		writeError(w, fmt.Errorf("page %d beyond commit %d: %w", pgno, rd.Commit(), fs.ErrNotExist))
+		return
+	}
+
+	data := make([]byte, rd.PageSize())
+	if err := rd.ReadPage(uint32(pgno), data); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(HeaderPos, rd.Pos().String())
+	w.Header().Set(HeaderPageSize, strconv.FormatUint(uint64(rd.PageSize()), 10))
+	_, _ = w.Write(data)
+}
+
+// latestReader returns a reader at the latest position in the store. The
+// reader is cached & only reopened once the latest position changes.
+func (h *Handler) latestReader(r *http.Request) (*pageReader, error) {
+	infos, err := h.list(r, -1, 0, 0)
+	if err != nil {
+		return nil, err
+	} else if len(infos) == 0 {
+		return nil, fmt.Errorf("no files: %w", fs.ErrNotExist)
+	}
+	pos := ltx.LatestFileInfo(infos).Pos()
+
+	h.mu.Lock()
+	if pr := h.reader; pr != nil && pr.Pos() == pos {
+		pr.refs++
+		h.mu.Unlock()
+		return pr, nil
+	}
+	h.mu.Unlock()
+
+	// The cached reader outlives the request so it must not be bound to the
+	// request's context.
+	pr, err := h.openReader(context.WithoutCancel(r.Context()), pos)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reader != nil {
+		_ = h.reader.releaseLocked()
+	}
+	h.reader = pr
+	pr.refs++
+	return pr, nil
+}
+
+// openReader opens a new reader at pos which is closed once it is released.
+func (h *Handler) openReader(ctx context.Context, pos ltx.Pos) (*pageReader, error) {
+	rd, err := ltx.OpenDatabaseReader(ctx, h.store, pos)
+	if err != nil {
+		return nil, err
+	}
+	return &pageReader{DatabaseReader: rd, refs: 1}, nil
+}
+
+// release releases a reader returned by latestReader() or openReader().
+func (h *Handler) release(pr *pageReader) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_ = pr.releaseLocked()
+}
+
+// pageReader represents a database reader shared by page requests. It is
+// closed once it is no longer cached & no requests are using it.
+type pageReader struct {
+	*ltx.DatabaseReader
+	refs int // protected by Handler.mu
+}
+
+// releaseLocked drops a reference & closes the reader once unreferenced.
+func (pr *pageReader) releaseLocked() error {
+	if pr.refs--; pr.refs > 0 {
+		return nil
+	}
+	return pr.Close()
+}
+
+// list returns the files in the store which match the level & TXID range.
+func (h *Handler) list(r *http.Request, level int, minTXID, maxTXID ltx.TXID) ([]*ltx.FileInfo, error) {
+	itr, err := h.store.List(r.Context(), level, minTXID, maxTXID)
+	if err != nil {
+		return nil, err
+	}
+	return ltx.SliceFileIterator(itr)
+}
+
+// writeError writes an error response with a status code based on err.
+// Errors wrapping fs.ErrNotExist or ltx.ErrNoRestorePath return a 404.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		code = http.StatusBadRequest
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, ltx.ErrNoRestorePath):
+		code = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// requestError represents an error caused by an invalid request.
+type requestError struct {
+	msg string
+}
+
+func badRequestError(format string, a ...any) error {
+	return &requestError{msg: fmt.Sprintf(format, a...)}
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func parseTXIDParam(s string) (ltx.TXID, error) {
+	if s == "" {
+		return 0, nil
+	}
+	txID, err := ltx.ParseTXID(s)
+	if err != nil {
+		return 0, badRequestError("invalid txid: %q", s)
+	}
+	return txID, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
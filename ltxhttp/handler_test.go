@@ -0,0 +1,218 @@
+package ltxhttp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxhttp"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		newStore func(t *testing.T) ltx.Store
+	}{
+		{"FileStore", func(t *testing.T) ltx.Store { return ltx.NewFileStore(t.TempDir()) }},
+		{"MemStore", func(t *testing.T) ltx.Store { return ltx.NewMemStore() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.newStore(t)
+			snapshot := mustWrite(t, s, 1, ltxtest.EncodeFile(t, 0, 1, 2))
+			mustWrite(t, s, 0, ltxtest.EncodeFile(t, 0, 3, 3))
+			mustWrite(t, s, 0, ltxtest.EncodeFile(t, 0, 4, 4))
+
+			srv := httptest.NewServer(ltxhttp.NewHandler(s))
+			defer srv.Close()
+
+			t.Run("Pos", func(t *testing.T) {
+				var pos ltx.Pos
+				getJSON(t, srv.URL+"/pos", &pos)
+				if got, want := pos, ltx.NewPos(4, ltxtest.FileChecksum(4)); got != want {
+					t.Fatalf("pos=%s, want %s", got, want)
+				}
+			})
+
+			t.Run("Files", func(t *testing.T) {
+				var infos []*ltx.FileInfo
+				getJSON(t, srv.URL+"/files", &infos)
+				if got, want := len(infos), 3; got != want {
+					t.Fatalf("len=%d, want %d", got, want)
+				}
+
+				getJSON(t, srv.URL+"/files?level=0&min=0000000000000004", &infos)
+				if got, want := len(infos), 1; got != want {
+					t.Fatalf("len=%d, want %d", got, want)
+				} else if got, want := infos[0].Pos(), ltx.NewPos(4, ltxtest.FileChecksum(4)); got != want {
+					t.Fatalf("pos=%s, want %s", got, want)
+				}
+
+				if resp := get(t, srv.URL+"/files?level=x", nil); resp.StatusCode != http.StatusBadRequest {
+					t.Fatalf("status=%d", resp.StatusCode)
+				}
+			})
+
+			t.Run("File", func(t *testing.T) {
+				want := ltxtest.EncodeFile(t, 0, 1, 2)
+				url := srv.URL + "/files/1/" + ltx.FormatFilename(snapshot.MinTXID, snapshot.MaxTXID)
+				if resp := get(t, url, nil); resp.StatusCode != http.StatusOK {
+					t.Fatalf("status=%d", resp.StatusCode)
+				} else if buf := readBody(t, resp); !bytes.Equal(buf, want) {
+					t.Fatal("file mismatch")
+				}
+
+				resp := get(t, url, http.Header{"Range": {"bytes=10-19"}})
+				if resp.StatusCode != http.StatusPartialContent {
+					t.Fatalf("status=%d", resp.StatusCode)
+				} else if buf := readBody(t, resp); !bytes.Equal(buf, want[10:20]) {
+					t.Fatal("range mismatch")
+				}
+
+				if resp := get(t, srv.URL+"/files/0/"+ltx.FormatFilename(1, 2), nil); resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("status=%d", resp.StatusCode)
+				}
+			})
+
+			t.Run("Page", func(t *testing.T) {
+				for _, txID := range []ltx.TXID{2, 3} {
+					resp := get(t, srv.URL+"/page?pgno=1&txid="+txID.String(), nil)
+					if resp.StatusCode != http.StatusOK {
+						t.Fatalf("status=%d", resp.StatusCode)
+					} else if got, want := resp.Header.Get(ltxhttp.HeaderPos), ltx.NewPos(txID, ltxtest.FileChecksum(txID)).String(); got != want {
+						t.Fatalf("pos=%s, want %s", got, want)
+					}
+					assertPage(t, readBody(t, resp), ltxtest.FileChecksum(txID))
+				}
+
+				// Read latest page if no TXID is specified.
+				resp := get(t, srv.URL+"/page?pgno=1", nil)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status=%d", resp.StatusCode)
+				} else if got, want := resp.Header.Get(ltxhttp.HeaderPageSize), "512"; got != want {
+					t.Fatalf("page size=%s, want %s", got, want)
+				}
+				assertPage(t, readBody(t, resp), ltxtest.FileChecksum(4))
+
+				if resp := get(t, srv.URL+"/page?pgno=2", nil); resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("status=%d", resp.StatusCode)
+				} else if resp := get(t, srv.URL+"/page?pgno=1&txid=0000000000000001", nil); resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("status=%d", resp.StatusCode)
+				} else if resp := get(t, srv.URL+"/page", nil); resp.StatusCode != http.StatusBadRequest {
+					t.Fatalf("status=%d", resp.StatusCode)
+				}
+			})
+		})
+	}
+
+	// The reader for the latest position is reused until a new file is written.
+	t.Run("PageCache", func(t *testing.T) {
+		s := &openCountingStore{Store: ltx.NewMemStore()}
+		mustWrite(t, s, 1, ltxtest.EncodeFile(t, 0, 1, 2))
+		mustWrite(t, s, 0, ltxtest.EncodeFile(t, 0, 3, 3))
+
+		h := ltxhttp.NewHandler(s)
+		defer func() { _ = h.Close() }()
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+
+		for i := 0; i < 3; i++ {
+			assertPage(t, readBody(t, get(t, srv.URL+"/page?pgno=1", nil)), ltxtest.FileChecksum(3))
+		}
+		if got, want := s.opens, 2; got != want {
+			t.Fatalf("opens=%d, want %d", got, want)
+		}
+
+		mustWrite(t, s, 0, ltxtest.EncodeFile(t, 0, 4, 4))
+		assertPage(t, readBody(t, get(t, srv.URL+"/page?pgno=1", nil)), ltxtest.FileChecksum(4))
+		if got, want := s.opens, 5; got != want {
+			t.Fatalf("opens=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		srv := httptest.NewServer(ltxhttp.NewHandler(ltx.NewMemStore()))
+		defer srv.Close()
+
+		if resp := get(t, srv.URL+"/pos", nil); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("status=%d", resp.StatusCode)
+		}
+
+		var infos []*ltx.FileInfo
+		getJSON(t, srv.URL+"/files", &infos)
+		if infos == nil || len(infos) != 0 {
+			t.Fatalf("unexpected files: %v", infos)
+		}
+	})
+}
+
+// openCountingStore counts the number of files opened from a store.
+type openCountingStore struct {
+	ltx.Store
+	mu    sync.Mutex
+	opens int
+}
+
+func (s *openCountingStore) Open(ctx context.Context, info *ltx.FileInfo) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.opens++
+	s.mu.Unlock()
+	return s.Store.Open(ctx, info)
+}
+
+// assertPage verifies that data is the single page of a database with chksum.
+func assertPage(tb testing.TB, data []byte, chksum ltx.Checksum) {
+	tb.Helper()
+	if got := ltx.ChecksumPage(1, data); got != chksum {
+		tb.Fatalf("page checksum=%s, want %s", got, chksum)
+	}
+}
+
+func mustWrite(tb testing.TB, s ltx.Store, level int, data []byte) *ltx.FileInfo {
+	tb.Helper()
+	info, err := s.Write(context.Background(), level, bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return info
+}
+
+func get(tb testing.TB, url string, hdr http.Header) *http.Response {
+	tb.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	req.Header = hdr
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func getJSON(tb testing.TB, url string, v any) {
+	tb.Helper()
+	resp := get(tb, url, nil)
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("status=%d", resp.StatusCode)
+	} else if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func readBody(tb testing.TB, resp *http.Response) []byte {
+	tb.Helper()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf
+}
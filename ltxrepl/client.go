@@ -0,0 +1,154 @@
+package ltxrepl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+// ApplyFunc applies the LTX file read from r to a replica and returns the
+// replica's new position. Shards other than the last shard of a set should
+// return the position from before the set.
+//
+// Returning an error wrapping *ltx.PosMismatchError requests a snapshot from
+// the primary. Any other error stops replication.
+type ApplyFunc func(ctx context.Context, r io.Reader) (ltx.Pos, error)
+
+// Client replicates LTX files from a primary.
+type Client struct {
+	// How often a ping is sent to the primary.
+	KeepaliveInterval time.Duration
+
+	// Maximum time a read or write may block if the connection supports
+	// deadlines. The primary pings at its own keepalive interval so this
+	// should be longer than the primary's interval. Disabled if zero.
+	Timeout time.Duration
+}
+
+// NewClient returns a new instance of Client.
+func NewClient() *Client {
+	return &Client{
+		KeepaliveInterval: DefaultKeepaliveInterval,
+		Timeout:           DefaultTimeout,
+	}
+}
+
+// Replicate announces pos to the primary connected on rw and applies each file
+// it streams with fn until ctx is done, the connection fails or fn returns an
+// error. Files received while waiting for a requested snapshot, and files
+// which end at or before the current position, are skipped.
+//
+// Replicate pings the primary from a separate goroutine. If rw does not
+// support deadlines then the caller must close it to stop that goroutine and
+// to return from Replicate when ctx is done.
+func (c *Client) Replicate(ctx context.Context, rw io.ReadWriter, pos ltx.Pos, fn ApplyFunc) error {
+	cn := newConn(rw, c.Timeout)
+	defer cn.interrupt()
+	w := &frameWriter{w: cn}
+
+	stop := context.AfterFunc(ctx, cn.interrupt)
+	defer stop()
+
+	hello := binary.BigEndian.AppendUint32(make([]byte, 0, helloSize), ProtocolVersion)
+	if err := w.writeFrame(frameHello, appendPos(hello, pos)); err != nil {
+		return c.err(ctx, fmt.Errorf("send hello: %w", err))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.keepalive(ctx, w)
+
+	var awaitingSnapshot bool
+	for {
+		typ, size, err := readFrameHeader(cn)
+		if err != nil {
+			return c.err(ctx, err)
+		}
+
+		switch typ {
+		case framePing:
+			if _, err := readFramePayload(cn, size); err != nil {
+				return c.err(ctx, err)
+			}
+
+		case frameError:
+			payload, err := readFramePayload(cn, size)
+			if err != nil {
+				return c.err(ctx, err)
+			}
+			return fmt.Errorf("primary error: %s", payload)
+
+		case frameFile:
+			r := io.LimitReader(cn, size)
+			hdr, fr, err := ltx.PeekHeader(r)
+			if err != nil {
+				return c.err(ctx, fmt.Errorf("read file header: %w", err))
+			}
+
+			// Skip files until a requested snapshot arrives. Files may also
+			// be sent more than once if a reset crosses a file in flight.
+			if (awaitingSnapshot || hdr.MaxTXID <= pos.TXID) && !hdr.IsSnapshot() {
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					return c.err(ctx, err)
+				}
+				continue
+			}
+
+			next, err := fn(ctx, fr)
+			var pmErr *ltx.PosMismatchError
+			if errors.As(err, &pmErr) {
+				awaitingSnapshot = true
+				if err := w.writeFrame(frameReset, appendPos(nil, pos)); err != nil {
+					return c.err(ctx, fmt.Errorf("send reset: %w", err))
+				}
+			} else if err != nil {
+				return err
+			} else {
+				pos = next
+				awaitingSnapshot = awaitingSnapshot && !hdr.IsSnapshot()
+				if err := w.writeFrame(frameAck, appendPos(nil, pos)); err != nil {
+					return c.err(ctx, fmt.Errorf("send ack: %w", err))
+				}
+			}
+
+			// Discard anything left unread by fn.
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				return c.err(ctx, err)
+			}
+
+		default:
+			return fmt.Errorf("unexpected frame type from primary: %d", typ)
+		}
+	}
+}
+
+// keepalive pings the primary until ctx is done or a write fails.
+func (c *Client) keepalive(ctx context.Context, w *frameWriter) {
+	ticker := time.NewTicker(c.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.writeFrame(framePing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// err returns the context error in place of err if ctx is done as the
+// connection is interrupted when the context is canceled.
+func (c *Client) err(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+// Package ltxrepl implements a streaming replication protocol for LTX files.
+//
+// A replica connects to a primary and announces its current position. The
+// primary streams the LTX files which move the replica from that position to
+// the latest position in its store, followed by new files as they are written.
+// The replica acknowledges each file after applying it. If the replica's
+// position is not part of the primary's history, or the replica rejects a file
+// with a PosMismatchError, the primary resends a snapshot.
+//
+// Each message is framed by a 1-byte type and an 8-byte big-endian payload
+// size. Positions are encoded as an 8-byte TXID & an 8-byte checksum.
+//
+//	hello  replica -> primary  protocol version (4 bytes) & position
+//	file   primary -> replica  raw LTX file
+//	ack    replica -> primary  position after applying a file
+//	reset  replica -> primary  position; requests a snapshot
+//	ping   either direction    keepalive, empty payload
+//	error  primary -> replica  error message; the primary stops streaming
+package ltxrepl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+// ProtocolVersion is the version of the protocol sent in the hello message.
+const ProtocolVersion = 1
+
+// Default settings for clients & servers.
+const (
+	DefaultPollInterval      = 1 * time.Second
+	DefaultKeepaliveInterval = 10 * time.Second
+	DefaultTimeout           = 30 * time.Second
+)
+
+// Frame types.
+const (
+	frameHello byte = iota + 1
+	frameFile
+	frameAck
+	frameReset
+	framePing
+	frameError
+)
+
+const (
+	frameHeaderSize = 9
+	posSize         = 16
+	helloSize       = 4 + posSize
+
+	// maxMessageSize is the maximum payload size of frames other than files.
+	maxMessageSize = 1 << 16
+)
+
+// errInterrupted is returned by reads & writes after a connection is interrupted.
+var errInterrupted = errors.New("connection interrupted")
+
+// readFrameHeader reads the type & payload size of the next frame. Returns
+// io.EOF if the connection is closed before the next frame.
+func readFrameHeader(r io.Reader) (typ byte, size int64, err error) {
+	var b [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, 0, err
+	}
+
+	size = int64(binary.BigEndian.Uint64(b[1:]))
+	if size < 0 {
+		return 0, 0, fmt.Errorf("invalid frame size: %d", uint64(size))
+	}
+	return b[0], size, nil
+}
+
+// readFramePayload reads the payload of a non-file frame.
+func readFramePayload(r io.Reader, size int64) ([]byte, error) {
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read frame payload: %w", err)
+	}
+	return payload, nil
+}
+
+// readPos reads the payload of a frame containing a single position.
+func readPos(r io.Reader, size int64) (ltx.Pos, error) {
+	if size != posSize {
+		return ltx.Pos{}, fmt.Errorf("invalid position size: %d", size)
+	}
+	payload, err := readFramePayload(r, size)
+	if err != nil {
+		return ltx.Pos{}, err
+	}
+	return decodePos(payload), nil
+}
+
+func appendPos(b []byte, pos ltx.Pos) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(pos.TXID))
+	return binary.BigEndian.AppendUint64(b, uint64(pos.PostApplyChecksum))
+}
+
+func decodePos(b []byte) ltx.Pos {
+	return ltx.Pos{
+		TXID:              ltx.TXID(binary.BigEndian.Uint64(b[0:8])),
+		PostApplyChecksum: ltx.Checksum(binary.BigEndian.Uint64(b[8:16])),
+	}
+}
+
+// frameWriter writes frames to a connection. Safe for concurrent use.
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// writeFrame writes a frame with the entire payload in a single write.
+func (fw *frameWriter) writeFrame(typ byte, payload []byte) error {
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint64(buf[1:], uint64(len(payload)))
+	buf = append(buf, payload...)
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, err := fw.w.Write(buf)
+	return err
+}
+
+// writeFile writes a file frame of size bytes copied from r.
+func (fw *frameWriter) writeFile(r io.Reader, size int64) error {
+	var hdr [frameHeaderSize]byte
+	hdr[0] = frameFile
+	binary.BigEndian.PutUint64(hdr[1:], uint64(size))
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if _, err := fw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := io.CopyN(fw.w, r, size)
+	return err
+}
+
+// conn wraps a connection to apply a timeout to each read & write if the
+// connection supports deadlines, such as a net.Conn.
+type conn struct {
+	rw      io.ReadWriter
+	timeout time.Duration
+
+	mu          sync.Mutex
+	interrupted bool
+}
+
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func newConn(rw io.ReadWriter, timeout time.Duration) *conn {
+	return &conn{rw: rw, timeout: timeout}
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if err := c.extend(deadliner.SetReadDeadline); err != nil {
+		return 0, err
+	}
+	return c.rw.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if err := c.extend(deadliner.SetWriteDeadline); err != nil {
+		return 0, err
+	}
+	return c.rw.Write(p)
+}
+
+// extend moves a deadline forward by the timeout before a read or write.
+// Errors setting the deadline are ignored as some connections reject
+// deadlines once closed, which the read or write itself will report.
+func (c *conn) extend(set func(deadliner, time.Time) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.interrupted {
+		return errInterrupted
+	}
+	if d, ok := c.rw.(deadliner); ok && c.timeout > 0 {
+		_ = set(d, time.Now().Add(c.timeout))
+	}
+	return nil
+}
+
+// interrupt fails all future reads & writes. Blocked reads & writes are
+// unblocked if the connection supports deadlines.
+func (c *conn) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interrupted = true
+	if d, ok := c.rw.(deadliner); ok {
+		_ = d.SetReadDeadline(time.Unix(1, 0))
+		_ = d.SetWriteDeadline(time.Unix(1, 0))
+	}
+}
@@ -0,0 +1,259 @@
+package ltxrepl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxrepl"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestReplicate(t *testing.T) {
+	t.Run("FromScratch", func(t *testing.T) {
+		s := newTestStore(t)
+		r := newTestReplica(ltx.Pos{})
+		acks := make(chan ltx.Pos, 10)
+		cancel, done := replicate(t, s, r, func(pos ltx.Pos) { acks <- pos })
+
+		// The snapshot & each file after it are applied in order.
+		r.wait(t, ltx.NewPos(2, ltxtest.FileChecksum(2)), ltx.NewPos(3, ltxtest.FileChecksum(3)), ltx.NewPos(4, ltxtest.FileChecksum(4)))
+		if got, want := r.snapshots, 1; got != want {
+			t.Fatalf("snapshots=%d, want %d", got, want)
+		}
+
+		// New files are streamed as they are written.
+		mustWrite(t, s, 0, ltxtest.EncodeFile(t, 0, 5, 5))
+		r.wait(t, ltx.NewPos(5, ltxtest.FileChecksum(5)))
+
+		for _, want := range []ltx.TXID{2, 3, 4, 5} {
+			select {
+			case pos := <-acks:
+				if got, want := pos, ltx.NewPos(want, ltxtest.FileChecksum(want)); got != want {
+					t.Fatalf("ack=%s, want %s", got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for ack")
+			}
+		}
+
+		cancel()
+		if serveErr, replErr := done(); !errors.Is(serveErr, context.Canceled) {
+			t.Fatalf("unexpected serve error: %v", serveErr)
+		} else if !errors.Is(replErr, context.Canceled) {
+			t.Fatalf("unexpected replicate error: %v", replErr)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		s := newTestStore(t)
+		r := newTestReplica(ltx.NewPos(3, ltxtest.FileChecksum(3)))
+		cancel, done := replicate(t, s, r, nil)
+		defer func() { cancel(); done() }()
+
+		r.wait(t, ltx.NewPos(4, ltxtest.FileChecksum(4)))
+		if got, want := r.snapshots, 0; got != want {
+			t.Fatalf("snapshots=%d, want %d", got, want)
+		}
+	})
+
+	// A replica at a position outside the primary's history receives a snapshot.
+	t.Run("UnknownPos", func(t *testing.T) {
+		s := newTestStore(t)
+		r := newTestReplica(ltx.NewPos(3, ltx.ChecksumFlag|1))
+		cancel, done := replicate(t, s, r, nil)
+		defer func() { cancel(); done() }()
+
+		r.wait(t, ltx.NewPos(2, ltxtest.FileChecksum(2)), ltx.NewPos(3, ltxtest.FileChecksum(3)), ltx.NewPos(4, ltxtest.FileChecksum(4)))
+		if got, want := r.snapshots, 1; got != want {
+			t.Fatalf("snapshots=%d, want %d", got, want)
+		}
+	})
+
+	// A replica which rejects a file with a PosMismatchError receives a snapshot.
+	t.Run("Reset", func(t *testing.T) {
+		s := newTestStore(t)
+		r := newTestReplica(ltx.NewPos(2, ltxtest.FileChecksum(2)))
+		r.rejectN = 1
+		cancel, done := replicate(t, s, r, nil)
+		defer func() { cancel(); done() }()
+
+		r.wait(t, ltx.NewPos(2, ltxtest.FileChecksum(2)), ltx.NewPos(3, ltxtest.FileChecksum(3)), ltx.NewPos(4, ltxtest.FileChecksum(4)))
+		if got, want := r.snapshots, 1; got != want {
+			t.Fatalf("snapshots=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("ReplicaClose", func(t *testing.T) {
+		s := newTestStore(t)
+		client, server := net.Pipe()
+		errc := make(chan error, 1)
+		go func() { errc <- newTestServer(s).Serve(context.Background(), server) }()
+
+		if _, err := client.Write(helloFrame(ltxrepl.ProtocolVersion, ltx.NewPos(4, ltxtest.FileChecksum(4)))); err != nil {
+			t.Fatal(err)
+		} else if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrUnsupportedVersion", func(t *testing.T) {
+		s := newTestStore(t)
+		client, server := net.Pipe()
+		defer func() { _ = client.Close() }()
+
+		errc := make(chan error, 1)
+		go func() { errc <- newTestServer(s).Serve(context.Background(), server) }()
+
+		if _, err := client.Write(helloFrame(2, ltx.Pos{})); err != nil {
+			t.Fatal(err)
+		}
+
+		// The error is reported to the replica before the server returns.
+		buf := make([]byte, 9)
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint64(buf[1:]))
+		if _, err := io.ReadFull(client, msg); err != nil {
+			t.Fatal(err)
+		} else if got, want := string(msg), "unsupported protocol version: 2"; got != want {
+			t.Fatalf("message=%q, want %q", got, want)
+		}
+
+		if err := <-errc; err == nil || err.Error() != "unsupported protocol version: 2" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrApply", func(t *testing.T) {
+		s := newTestStore(t)
+		client, server := net.Pipe()
+		defer func() { _ = client.Close(); _ = server.Close() }()
+
+		go func() { _ = newTestServer(s).Serve(context.Background(), server) }()
+
+		err := newTestClient().Replicate(context.Background(), client, ltx.Pos{}, func(ctx context.Context, r io.Reader) (ltx.Pos, error) {
+			return ltx.Pos{}, errors.New("marker")
+		})
+		if err == nil || err.Error() != "marker" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// replicate runs a server for s & a client for r over an in-process pipe.
+// Returns a function to cancel both & a function to wait for their errors.
+func replicate(tb testing.TB, s ltx.Store, r *testReplica, onAck func(ltx.Pos)) (context.CancelFunc, func() (serveErr, replErr error)) {
+	tb.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, server := net.Pipe()
+	tb.Cleanup(func() { _ = client.Close(); _ = server.Close() })
+
+	srv := newTestServer(s)
+	srv.OnAck = onAck
+
+	serveErrc, replErrc := make(chan error, 1), make(chan error, 1)
+	go func() { serveErrc <- srv.Serve(ctx, server) }()
+	go func() { replErrc <- newTestClient().Replicate(ctx, client, r.pos, r.apply) }()
+
+	return cancel, func() (error, error) { return <-serveErrc, <-replErrc }
+}
+
+func newTestServer(s ltx.Store) *ltxrepl.Server {
+	srv := ltxrepl.NewServer(s)
+	srv.PollInterval = 10 * time.Millisecond
+	srv.KeepaliveInterval = 20 * time.Millisecond
+	return srv
+}
+
+func newTestClient() *ltxrepl.Client {
+	c := ltxrepl.NewClient()
+	c.KeepaliveInterval = 20 * time.Millisecond
+	return c
+}
+
+// newTestStore returns a store containing a snapshot through TXID 2 & single
+// transaction files for TXIDs 3 & 4.
+func newTestStore(tb testing.TB) ltx.Store {
+	s := ltx.NewMemStore()
+	mustWrite(tb, s, 1, ltxtest.EncodeFile(tb, 0, 1, 2))
+	mustWrite(tb, s, 0, ltxtest.EncodeFile(tb, 0, 3, 3))
+	mustWrite(tb, s, 0, ltxtest.EncodeFile(tb, 0, 4, 4))
+	return s
+}
+
+// testReplica tracks the position of a replica & reports each new position.
+// Its fields are only accessed by the replicating goroutine until a position
+// is received from the applied channel.
+type testReplica struct {
+	pos       ltx.Pos
+	snapshots int
+	rejectN   int // number of non-snapshot files to reject
+	applied   chan ltx.Pos
+}
+
+func newTestReplica(pos ltx.Pos) *testReplica {
+	return &testReplica{pos: pos, applied: make(chan ltx.Pos, 10)}
+}
+
+func (r *testReplica) apply(ctx context.Context, rd io.Reader) (ltx.Pos, error) {
+	dec := ltx.NewDecoder(rd)
+	if err := dec.Verify(); err != nil {
+		return r.pos, err
+	}
+
+	hdr := dec.Header()
+	if hdr.IsSnapshot() {
+		r.snapshots++
+	} else if r.rejectN > 0 || hdr.PreApplyPos() != r.pos {
+		r.rejectN--
+		return r.pos, ltx.NewPosMismatchError(r.pos)
+	}
+
+	r.pos = dec.PostApplyPos()
+	r.applied <- r.pos
+	return r.pos, nil
+}
+
+// wait waits for the replica to apply files ending at each position in order.
+func (r *testReplica) wait(tb testing.TB, a ...ltx.Pos) {
+	tb.Helper()
+	for _, want := range a {
+		select {
+		case got := <-r.applied:
+			if got != want {
+				tb.Fatalf("applied %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			tb.Fatalf("timeout waiting for %s", want)
+		}
+	}
+}
+
+func helloFrame(version uint32, pos ltx.Pos) []byte {
+	b := []byte{1}
+	b = binary.BigEndian.AppendUint64(b, 20)
+	b = binary.BigEndian.AppendUint32(b, version)
+	b = binary.BigEndian.AppendUint64(b, uint64(pos.TXID))
+	return binary.BigEndian.AppendUint64(b, uint64(pos.PostApplyChecksum))
+}
+
+func mustWrite(tb testing.TB, s ltx.Store, level int, data []byte) *ltx.FileInfo {
+	tb.Helper()
+	info, err := s.Write(context.Background(), level, bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return info
+}
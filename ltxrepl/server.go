@@ -0,0 +1,269 @@
+package ltxrepl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+// Server streams the LTX files in a store to replicas.
+type Server struct {
+	store ltx.Store
+
+	// How often the store is checked for new files.
+	PollInterval time.Duration
+
+	// How often a ping is sent while no files are being sent.
+	KeepaliveInterval time.Duration
+
+	// Maximum time a read or write may block if the connection supports
+	// deadlines. Replicas ping at their own keepalive interval so this
+	// should be longer than the replica's interval. Disabled if zero.
+	Timeout time.Duration
+
+	// If set, called with the position of each acknowledgement received from
+	// a replica. May be called concurrently for different connections.
+	OnAck func(pos ltx.Pos)
+}
+
+// NewServer returns a new instance of Server which streams files from store.
+func NewServer(store ltx.Store) *Server {
+	return &Server{
+		store:             store,
+		PollInterval:      DefaultPollInterval,
+		KeepaliveInterval: DefaultKeepaliveInterval,
+		Timeout:           DefaultTimeout,
+	}
+}
+
+// Serve streams files to the replica connected on rw until ctx is done, the
+// replica disconnects or the connection fails. Returns nil if the replica
+// closes the connection between messages.
+//
+// Serve reads from rw on a separate goroutine. If rw does not support
+// deadlines then the caller must close it to stop that goroutine.
+func (s *Server) Serve(ctx context.Context, rw io.ReadWriter) error {
+	c := newConn(rw, s.Timeout)
+	defer c.interrupt()
+	w := &frameWriter{w: c}
+
+	pos, err := s.readHello(c)
+	if err != nil {
+		_ = w.writeFrame(frameError, []byte(err.Error()))
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, c.interrupt)
+	defer stop()
+
+	st := &serverConnState{
+		notify: make(chan struct{}, 1),
+		errc:   make(chan error, 1),
+	}
+	go s.readLoop(c, st)
+
+	poll := time.NewTicker(s.PollInterval)
+	defer poll.Stop()
+	keepalive := time.NewTicker(s.KeepaliveInterval)
+	defer keepalive.Stop()
+
+	var snapshot bool
+	for {
+		if reset, ok := st.takeReset(); ok {
+			pos, snapshot = reset, true
+		}
+
+		next, n, err := s.sendFiles(ctx, w, pos, snapshot)
+		if errors.Is(err, ltx.ErrNoRestorePath) {
+			// The latest files may be an incomplete shard set so retry
+			// on the next poll.
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		} else {
+			pos, snapshot = next, false
+		}
+		if n > 0 {
+			keepalive.Reset(s.KeepaliveInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-st.errc:
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		case <-st.notify:
+		case <-poll.C:
+		case <-keepalive.C:
+			if err := w.writeFrame(framePing, nil); err != nil {
+				return fmt.Errorf("send ping: %w", err)
+			}
+		}
+	}
+}
+
+// readHello reads the hello message from the replica & returns its position.
+func (s *Server) readHello(r io.Reader) (ltx.Pos, error) {
+	typ, size, err := readFrameHeader(r)
+	if err != nil {
+		return ltx.Pos{}, fmt.Errorf("read hello: %w", err)
+	} else if typ != frameHello {
+		return ltx.Pos{}, fmt.Errorf("expected hello, got frame type %d", typ)
+	} else if size != helloSize {
+		return ltx.Pos{}, fmt.Errorf("invalid hello size: %d", size)
+	}
+
+	payload, err := readFramePayload(r, size)
+	if err != nil {
+		return ltx.Pos{}, err
+	} else if version := binary.BigEndian.Uint32(payload[0:4]); version != ProtocolVersion {
+		return ltx.Pos{}, fmt.Errorf("unsupported protocol version: %d", version)
+	}
+	return decodePos(payload[4:]), nil
+}
+
+// readLoop reads acknowledgements, resets & pings from the replica. It never
+// blocks on the main loop so the replica's writes are always consumed.
+func (s *Server) readLoop(r io.Reader, st *serverConnState) {
+	for {
+		typ, size, err := readFrameHeader(r)
+		if err != nil {
+			st.fail(err)
+			return
+		}
+
+		switch typ {
+		case framePing:
+			if _, err := readFramePayload(r, size); err != nil {
+				st.fail(err)
+				return
+			}
+
+		case frameAck:
+			pos, err := readPos(r, size)
+			if err != nil {
+				st.fail(fmt.Errorf("read ack: %w", err))
+				return
+			}
+			if s.OnAck != nil {
+				s.OnAck(pos)
+			}
+
+		case frameReset:
+			pos, err := readPos(r, size)
+			if err != nil {
+				st.fail(fmt.Errorf("read reset: %w", err))
+				return
+			}
+			st.reset(pos)
+
+		default:
+			st.fail(fmt.Errorf("unexpected frame type from replica: %d", typ))
+			return
+		}
+	}
+}
+
+// sendFiles sends the files which move a replica from pos to the latest
+// position in the store. A snapshot is sent if snapshot is true or if pos is
+// not part of the store's history. Returns the new position of the replica &
+// the number of files sent.
+func (s *Server) sendFiles(ctx context.Context, w *frameWriter, pos ltx.Pos, snapshot bool) (ltx.Pos, int, error) {
+	itr, err := s.store.List(ctx, -1, 0, 0)
+	if err != nil {
+		return pos, 0, err
+	}
+	infos, err := ltx.SliceFileIterator(itr)
+	if err != nil {
+		return pos, 0, err
+	}
+
+	latest := ltx.LatestFileInfo(infos)
+	if latest == nil {
+		return pos, 0, nil // nothing written yet
+	}
+
+	var path []*ltx.FileInfo
+	if !snapshot {
+		if path, err = ltx.ApplyPath(infos, pos, latest.Pos()); errors.Is(err, ltx.ErrNoRestorePath) {
+			snapshot = true
+		} else if err != nil {
+			return pos, 0, err
+		}
+	}
+	if snapshot {
+		if path, err = ltx.RestorePath(infos, latest.Pos()); err != nil {
+			return pos, 0, err
+		}
+	}
+
+	for _, info := range path {
+		if err := s.sendFile(ctx, w, info); err != nil {
+			return pos, 0, err
+		}
+	}
+	return latest.Pos(), len(path), nil
+}
+
+func (s *Server) sendFile(ctx context.Context, w *frameWriter, info *ltx.FileInfo) error {
+	rc, err := s.store.Open(ctx, info)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	if err := w.writeFile(rc, info.Size); err != nil {
+		return fmt.Errorf("send %s: %w", ltx.FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), err)
+	}
+	return nil
+}
+
+// serverConnState holds the state shared between Serve() and its read loop.
+type serverConnState struct {
+	mu       sync.Mutex
+	resetPos *ltx.Pos
+
+	notify chan struct{} // signals a pending reset
+	errc   chan error    // receives the read loop's final error
+}
+
+func (st *serverConnState) reset(pos ltx.Pos) {
+	st.mu.Lock()
+	st.resetPos = &pos
+	st.mu.Unlock()
+
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+// takeReset returns the position of the latest pending reset, if any.
+func (st *serverConnState) takeReset() (ltx.Pos, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.resetPos == nil {
+		return ltx.Pos{}, false
+	}
+	pos := *st.resetPos
+	st.resetPos = nil
+	return pos, true
+}
+
+func (st *serverConnState) fail(err error) {
+	st.errc <- err
+}
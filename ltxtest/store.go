@@ -0,0 +1,226 @@
+// Package ltxtest provides helpers for testing code that works with LTX files.
+package ltxtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+// TestStore runs a conformance test suite against a Store implementation.
+// The newStore function must return a new, empty store for each call.
+func TestStore(t *testing.T, newStore func(t *testing.T) ltx.Store) {
+	t.Run("WriteOpen", func(t *testing.T) {
+		s := newStore(t)
+		data := EncodeFile(t, 1, 1, 2)
+
+		info, err := s.Write(context.Background(), 1, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := info.Level, 1; got != want {
+			t.Fatalf("Level=%d, want %d", got, want)
+		} else if got, want := info.MinTXID, ltx.TXID(1); got != want {
+			t.Fatalf("MinTXID=%s, want %s", got, want)
+		} else if got, want := info.MaxTXID, ltx.TXID(2); got != want {
+			t.Fatalf("MaxTXID=%s, want %s", got, want)
+		} else if got, want := info.Size, int64(len(data)); got != want {
+			t.Fatalf("Size=%d, want %d", got, want)
+		}
+
+		rc, err := s.Open(context.Background(), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = rc.Close() }()
+
+		if buf, err := io.ReadAll(rc); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, data) {
+			t.Fatal("data mismatch")
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		s := newStore(t)
+		mustWrite(t, s, 0, EncodeFile(t, 0, 1, 1))
+		info := mustWrite(t, s, 0, EncodeFile(t, 0, 1, 1))
+
+		if infos := mustList(t, s, -1, 0, 0); len(infos) != 1 {
+			t.Fatalf("len=%d, want 1", len(infos))
+		} else if got, want := infos[0].Pos(), info.Pos(); got != want {
+			t.Fatalf("Pos=%s, want %s", got, want)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := newStore(t)
+		mustWrite(t, s, 0, EncodeFile(t, 0, 3, 3))
+		mustWrite(t, s, 0, EncodeFile(t, 0, 1, 1))
+		mustWrite(t, s, 0, EncodeFile(t, 0, 2, 2))
+		mustWrite(t, s, 1, EncodeFile(t, 0, 1, 3))
+		mustWrite(t, s, 2, EncodeFile(t, 0, 1, 3))
+		mustWrite(t, s, 0, EncodeFile(t, 1, 3, 3))
+
+		t.Run("All", func(t *testing.T) {
+			assertFileInfos(t, mustList(t, s, -1, 0, 0), []fileKey{
+				{0, 0, 1, 1}, {0, 0, 2, 2}, {0, 0, 3, 3}, {0, 1, 3, 3}, {1, 0, 1, 3}, {2, 0, 1, 3},
+			})
+		})
+		t.Run("Level", func(t *testing.T) {
+			assertFileInfos(t, mustList(t, s, 1, 0, 0), []fileKey{{1, 0, 1, 3}})
+		})
+		t.Run("TXIDRange", func(t *testing.T) {
+			assertFileInfos(t, mustList(t, s, 0, 2, 2), []fileKey{{0, 0, 2, 2}})
+			assertFileInfos(t, mustList(t, s, 0, 2, 0), []fileKey{{0, 0, 2, 2}, {0, 0, 3, 3}, {0, 1, 3, 3}})
+			assertFileInfos(t, mustList(t, s, -1, 0, 1), []fileKey{{0, 0, 1, 1}, {1, 0, 1, 3}, {2, 0, 1, 3}})
+		})
+		t.Run("Empty", func(t *testing.T) {
+			assertFileInfos(t, mustList(t, s, 3, 0, 0), nil)
+			assertFileInfos(t, mustList(t, s, 0, 4, 0), nil)
+		})
+		t.Run("Metadata", func(t *testing.T) {
+			infos := mustList(t, s, 0, 2, 2)
+			if got, want := infos[0].PreApplyPos(), ltx.NewPos(1, FileChecksum(1)); got != want {
+				t.Fatalf("PreApplyPos=%s, want %s", got, want)
+			} else if got, want := infos[0].Pos(), ltx.NewPos(2, FileChecksum(2)); got != want {
+				t.Fatalf("Pos=%s, want %s", got, want)
+			} else if infos[0].Size == 0 {
+				t.Fatal("expected size")
+			}
+		})
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		info := mustWrite(t, s, 0, EncodeFile(t, 0, 1, 1))
+		mustWrite(t, s, 0, EncodeFile(t, 0, 2, 2))
+
+		if err := s.Delete(context.Background(), info); err != nil {
+			t.Fatal(err)
+		}
+		assertFileInfos(t, mustList(t, s, -1, 0, 0), []fileKey{{0, 0, 2, 2}})
+
+		if _, err := s.Open(context.Background(), info); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Deleting a missing file is not an error.
+		if err := s.Delete(context.Background(), info); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrNotExist", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.Open(context.Background(), &ltx.FileInfo{MinTXID: 1, MaxTXID: 1}); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrInvalidFile", func(t *testing.T) {
+		s := newStore(t)
+		data := EncodeFile(t, 0, 1, 1)
+
+		// Truncated files must be rejected without leaving a partial file behind.
+		if _, err := s.Write(context.Background(), 0, bytes.NewReader(data[:len(data)-1])); err == nil {
+			t.Fatal("expected error")
+		}
+		assertFileInfos(t, mustList(t, s, -1, 0, 0), nil)
+
+		// Corrupted files must also be rejected.
+		data[len(data)-20] ^= 0xff
+		if _, err := s.Write(context.Background(), 0, bytes.NewReader(data)); err == nil {
+			t.Fatal("expected error")
+		}
+		assertFileInfos(t, mustList(t, s, -1, 0, 0), nil)
+	})
+}
+
+// EncodeFile returns a valid, encoded LTX file containing a single page for
+// the given timeline & TXID range. The page is filled with the low byte of
+// maxTXID so files with adjoining ranges form a contiguous chain that can be
+// compacted & applied.
+func EncodeFile(tb testing.TB, timeline uint32, minTXID, maxTXID ltx.TXID) []byte {
+	tb.Helper()
+
+	var preApplyChecksum ltx.Checksum
+	if minTXID > 1 {
+		preApplyChecksum = FileChecksum(minTXID - 1)
+	}
+
+	var buf bytes.Buffer
+	spec := &ltx.FileSpec{
+		Header: ltx.Header{
+			Version:          ltx.Version,
+			PageSize:         512,
+			Commit:           1,
+			MinTXID:          minTXID,
+			MaxTXID:          maxTXID,
+			Timestamp:        int64(maxTXID) * 1000,
+			PreApplyChecksum: preApplyChecksum,
+			Timeline:         timeline,
+		},
+		Pages:   []ltx.PageSpec{{Header: ltx.PageHeader{Pgno: 1}, Data: filePage(maxTXID)}},
+		Trailer: ltx.Trailer{PostApplyChecksum: FileChecksum(maxTXID)},
+	}
+	if _, err := spec.WriteTo(&buf); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// FileChecksum returns the database checksum after applying files created by
+// EncodeFile up to txID.
+func FileChecksum(txID ltx.TXID) ltx.Checksum {
+	return ltx.ChecksumPage(1, filePage(txID))
+}
+
+func filePage(txID ltx.TXID) []byte {
+	return bytes.Repeat([]byte{byte(txID)}, 512)
+}
+
+type fileKey struct {
+	level    int
+	timeline uint32
+	minTXID  ltx.TXID
+	maxTXID  ltx.TXID
+}
+
+func mustWrite(tb testing.TB, s ltx.Store, level int, data []byte) *ltx.FileInfo {
+	tb.Helper()
+	info, err := s.Write(context.Background(), level, bytes.NewReader(data))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return info
+}
+
+func mustList(tb testing.TB, s ltx.Store, level int, minTXID, maxTXID ltx.TXID) []*ltx.FileInfo {
+	tb.Helper()
+	itr, err := s.List(context.Background(), level, minTXID, maxTXID)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	infos, err := ltx.SliceFileIterator(itr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return infos
+}
+
+func assertFileInfos(tb testing.TB, infos []*ltx.FileInfo, want []fileKey) {
+	tb.Helper()
+	if len(infos) != len(want) {
+		tb.Fatalf("len=%d, want %d", len(infos), len(want))
+	}
+	for i, info := range infos {
+		if got := (fileKey{info.Level, info.Timeline, info.MinTXID, info.MaxTXID}); got != want[i] {
+			tb.Fatalf("%d. file=%+v, want %+v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package ltx
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RetentionPolicy represents a set of rules for deciding which LTX files can
+// be deleted. Each rule produces restore points, which are positions that must
+// remain restorable, and files are only deleted if they are not needed to
+// restore any of those points. The latest position is always a restore point.
+type RetentionPolicy struct {
+	// Duration to retain level-zero files, based on their timestamp. Each
+	// retained file's position remains restorable. If zero, level-zero files
+	// are only retained when needed by another rule.
+	L0Retention time.Duration
+
+	// Retains the latest snapshot within each time window of this size.
+	// If zero, snapshots are only retained when needed by another rule.
+	SnapshotInterval time.Duration
+
+	// Duration to retain snapshots selected by SnapshotInterval. If zero,
+	// those snapshots are retained indefinitely.
+	SnapshotRetention time.Duration
+
+	// If true, every position after the latest snapshot remains restorable.
+	KeepSinceLatestSnapshot bool
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// RetentionPlan represents the result of applying a RetentionPolicy.
+type RetentionPlan struct {
+	// Files which must be kept, in iterator order.
+	Retain []*FileInfo
+
+	// Files which can be safely deleted, in iterator order.
+	Delete []*FileInfo
+
+	// Positions which remain restorable using only the retained files.
+	RestorePoints []Pos
+}
+
+// Plan computes which files in itr can be deleted under the policy. Every
+// restore point is proven to have a complete chain of retained files, from a
+// snapshot to the restore point, before the plan is returned. If a restore
+// point cannot be restored from the existing files then an error wrapping
+// ErrNoRestorePath is returned and nothing should be deleted.
+//
+// The iterator is closed before returning.
+func (p *RetentionPolicy) Plan(itr FileIterator) (*RetentionPlan, error) {
+	infos, err := SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	} else if len(infos) == 0 {
+		return &RetentionPlan{}, nil
+	}
+
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+
+	retain := make(map[*FileInfo]struct{})
+	points := make(map[Pos]struct{})
+	keep := func(info *FileInfo) {
+		retain[info] = struct{}{}
+		points[info.Pos()] = struct{}{}
+	}
+
+	// Always keep the latest position restorable.
+	points[LatestFileInfo(infos).Pos()] = struct{}{}
+
+	// Keep level-zero files within the retention window.
+	if p.L0Retention > 0 {
+		for _, info := range infos {
+			if info.Level == 0 && !info.CreatedAt.Before(now.Add(-p.L0Retention)) {
+				keep(info)
+			}
+		}
+	}
+
+	// Keep the latest snapshot within each time window.
+	if p.SnapshotInterval > 0 {
+		windows := make(map[time.Time]*FileInfo)
+		for _, info := range infos {
+			if info.MinTXID != 1 {
+				continue
+			} else if p.SnapshotRetention > 0 && info.CreatedAt.Before(now.Add(-p.SnapshotRetention)) {
+				continue
+			}
+
+			window := info.CreatedAt.Truncate(p.SnapshotInterval)
+			if other := windows[window]; other == nil || info.MaxTXID > other.MaxTXID ||
+				(info.MaxTXID == other.MaxTXID && info.Level > other.Level) {
+				windows[window] = info
+			}
+		}
+		for _, info := range windows {
+			keep(info)
+		}
+	}
+
+	// Keep every position after the latest snapshot.
+	if p.KeepSinceLatestSnapshot {
+		var snapshot *FileInfo
+		for _, info := range infos {
+			if info.MinTXID == 1 && (snapshot == nil || info.MaxTXID > snapshot.MaxTXID) {
+				snapshot = info
+			}
+		}
+		for _, info := range infos {
+			if snapshot == nil || info.MaxTXID >= snapshot.MaxTXID {
+				points[info.Pos()] = struct{}{}
+			}
+		}
+	}
+
+	// Sort restore points so errors & output are deterministic.
+	plan := &RetentionPlan{}
+	for pos := range points {
+		plan.RestorePoints = append(plan.RestorePoints, pos)
+	}
+	slices.SortFunc(plan.RestorePoints, func(a, b Pos) int {
+		if v := cmp.Compare(a.TXID, b.TXID); v != 0 {
+			return v
+		}
+		return cmp.Compare(a.PostApplyChecksum, b.PostApplyChecksum)
+	})
+
+	// Retain the files in the shortest restore path to each restore point.
+	for _, pos := range plan.RestorePoints {
+		path, err := RestorePath(infos, pos)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range path {
+			retain[info] = struct{}{}
+		}
+	}
+
+	for _, info := range infos {
+		if _, ok := retain[info]; ok {
+			plan.Retain = append(plan.Retain, info)
+		} else {
+			plan.Delete = append(plan.Delete, info)
+		}
+	}
+
+	// Prove that each restore point is still restorable once files are deleted.
+	for _, pos := range plan.RestorePoints {
+		if _, err := RestorePath(plan.Retain, pos); err != nil {
+			return nil, fmt.Errorf("retained files: %w", err)
+		}
+	}
+
+	return plan, nil
+}
+
+// RestorePath returns the shortest chain of files in a which restores a
+// database from a snapshot to the target position. The chain is ordered by
+// transaction ID and each file starts at the position where the previous file
+// ended. If multiple chains have the same number of files, the chain with the
+// smallest total size is used. A zero checksum in the target matches any
+// checksum. Shard sets are only used if every shard in the set is in a and the
+// entire set is included in the chain. Files in a chain must belong to the
+// same database, see CheckDatabaseID().
+//
+// Only files in the history of the highest timeline with a file ending at the
+// target are used, so files from an abandoned history are never chained
+// together. Branch points are resolved in the same way as TimelineChain().
+//
+// Returns an error wrapping ErrNoRestorePath if no chain exists.
+func RestorePath(a []*FileInfo, target Pos) ([]*FileInfo, error) {
+	return ApplyPath(a, Pos{}, target)
+}
+
+// ApplyPath returns the shortest chain of files in a which moves a database
+// from the position from to the target position. The first file in the chain
+// starts at from, or is a snapshot if from is zero. Chains are chosen in the
+// same way as RestorePath(). An empty chain is returned if from is the target.
+//
+// Returns an error wrapping ErrNoRestorePath if no chain exists.
+func ApplyPath(a []*FileInfo, from, target Pos) ([]*FileInfo, error) {
+	if !from.IsZero() && posMatches(from, target) {
+		return nil, nil
+	}
+
+	a = timelineFiles(a, target)
+	groups := groupShards(a)
+	slices.SortStableFunc(groups, func(x, y []*FileInfo) int {
+		return cmp.Compare(x[0].MaxTXID, y[0].MaxTXID)
+	})
+
+	type node struct {
+		group []*FileInfo
+		prev  *node
+		id    DatabaseID // first non-zero database ID in the chain
+		n     int
+		size  int64
+	}
+
+	// Compute the best path to each group in order so every possible
+	// predecessor of a group has been visited before the group itself.
+	byMaxTXID := make(map[TXID][]*node)
+	for _, g := range groups {
+		info := g[0]
+
+		var size int64
+		for _, shard := range g {
+			size += shard.Size
+		}
+
+		var prev *node
+		if info.MinTXID == from.TXID+1 {
+			if !posMatches(from, info.PreApplyPos()) {
+				continue
+			}
+		} else if info.MinTXID > from.TXID+1 {
+			for _, other := range byMaxTXID[info.MinTXID-1] {
+				if !IsContiguous(other.group[0].MaxTXID, info.MinTXID, info.MaxTXID) || !posMatches(other.group[0].Pos(), info.PreApplyPos()) {
+					continue
+				} else if CheckDatabaseID(other.id, info.DatabaseID) != nil {
+					continue
+				} else if prev == nil || nodeLess(other.n, other.size, prev.n, prev.size) {
+					prev = other
+				}
+			}
+			if prev == nil {
+				continue // unreachable from the starting position
+			}
+		} else {
+			continue // starts before the starting position
+		}
+
+		nd := &node{group: g, prev: prev, id: info.DatabaseID, n: len(g), size: size}
+		if prev != nil {
+			nd.n, nd.size = prev.n+len(g), prev.size+size
+			if !prev.id.IsZero() {
+				nd.id = prev.id
+			}
+		}
+		byMaxTXID[info.MaxTXID] = append(byMaxTXID[info.MaxTXID], nd)
+	}
+
+	var last *node
+	for _, nd := range byMaxTXID[target.TXID] {
+		if !posMatches(nd.group[0].Pos(), target) {
+			continue
+		} else if last == nil || nodeLess(nd.n, nd.size, last.n, last.size) {
+			last = nd
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("%w to position %s", ErrNoRestorePath, target)
+	}
+
+	path := make([]*FileInfo, last.n)
+	for i, nd := len(path), last; nd != nil; nd = nd.prev {
+		i -= len(nd.group)
+		copy(path[i:], nd.group)
+	}
+	return path, nil
+}
+
+// nodeLess returns true if a path of n files & size bytes is shorter than another.
+func nodeLess(n int, size int64, otherN int, otherSize int64) bool {
+	if n != otherN {
+		return n < otherN
+	}
+	return size < otherSize
+}
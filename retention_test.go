@@ -0,0 +1,243 @@
+package ltx_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/superfly/ltx"
+)
+
+func TestRestorePath(t *testing.T) {
+	infos := []*ltx.FileInfo{
+		{Level: 0, MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1, Size: 10},
+		{Level: 0, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 2, Size: 10},
+		{Level: 0, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 2, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 10},
+		{Level: 0, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 4, Size: 10},
+		{Level: 1, MinTXID: 1, MaxTXID: 3, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 20},
+		{Level: 2, MinTXID: 1, MaxTXID: 3, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 15},
+	}
+
+	t.Run("Shortest", func(t *testing.T) {
+		path, err := ltx.RestorePath(infos, ltx.NewPos(4, ltx.ChecksumFlag|4))
+		if err != nil {
+			t.Fatal(err)
+		} else if got, want := len(path), 2; got != want {
+			t.Fatalf("len=%d, want %d", got, want)
+		} else if path[0] != infos[5] || path[1] != infos[3] {
+			t.Fatalf("unexpected path: %+v, %+v", path[0], path[1])
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		path, err := ltx.RestorePath(infos, ltx.NewPos(1, ltx.ChecksumFlag|1))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(path) != 1 || path[0] != infos[0] {
+			t.Fatalf("unexpected path: %+v", path)
+		}
+	})
+
+	t.Run("ErrChecksumMismatch", func(t *testing.T) {
+		if _, err := ltx.RestorePath(infos, ltx.NewPos(4, ltx.ChecksumFlag|5)); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrBrokenChain", func(t *testing.T) {
+		a := []*ltx.FileInfo{
+			{MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1},
+			{MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 9, PostApplyChecksum: ltx.ChecksumFlag | 2},
+		}
+		if _, err := ltx.RestorePath(a, ltx.NewPos(2, ltx.ChecksumFlag|2)); err == nil || err.Error() != `no restore path to position 0000000000000002/8000000000000002` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Timeline 0 is restored to TXID 3 & resumed as timeline 1. The abandoned
+	// file has no checksums so it would otherwise continue the chain.
+	t.Run("Timeline", func(t *testing.T) {
+		a := []*ltx.FileInfo{
+			{MinTXID: 1, MaxTXID: 3, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 10},
+			{MinTXID: 4, MaxTXID: 4, Size: 10},
+			{Timeline: 1, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 14, Size: 20},
+			{Timeline: 1, MinTXID: 5, MaxTXID: 5, PostApplyChecksum: ltx.ChecksumFlag | 15, Size: 10},
+		}
+		path, err := ltx.RestorePath(a, ltx.NewPos(5, ltx.ChecksumFlag|15))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertFileInfoPtrs(t, path, a[0], a[2], a[3])
+	})
+
+	// Files from another database cannot continue a chain.
+	t.Run("ErrDatabaseIDMismatch", func(t *testing.T) {
+		a := []*ltx.FileInfo{
+			{MinTXID: 1, MaxTXID: 1, DatabaseID: ltx.DatabaseID{1}, PostApplyChecksum: ltx.ChecksumFlag | 1},
+			{MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 2},
+			{MinTXID: 3, MaxTXID: 3, DatabaseID: ltx.DatabaseID{2}, PreApplyChecksum: ltx.ChecksumFlag | 2, PostApplyChecksum: ltx.ChecksumFlag | 3},
+		}
+		if path, err := ltx.RestorePath(a, ltx.NewPos(2, ltx.ChecksumFlag|2)); err != nil {
+			t.Fatal(err)
+		} else if len(path) != 2 {
+			t.Fatalf("unexpected path: %+v", path)
+		}
+		if _, err := ltx.RestorePath(a, ltx.NewPos(3, ltx.ChecksumFlag|3)); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestApplyPath(t *testing.T) {
+	infos := []*ltx.FileInfo{
+		{Level: 0, MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1, Size: 10},
+		{Level: 0, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 2, Size: 10},
+		{Level: 0, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 2, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 10},
+		{Level: 0, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 4, Size: 10},
+		{Level: 1, MinTXID: 2, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 15},
+		{Level: 2, MinTXID: 1, MaxTXID: 3, PostApplyChecksum: ltx.ChecksumFlag | 3, Size: 15},
+	}
+
+	t.Run("Shortest", func(t *testing.T) {
+		path, err := ltx.ApplyPath(infos, ltx.NewPos(1, ltx.ChecksumFlag|1), ltx.NewPos(4, ltx.ChecksumFlag|4))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(path) != 2 || path[0] != infos[4] || path[1] != infos[3] {
+			t.Fatalf("unexpected path: %+v", path)
+		}
+	})
+
+	t.Run("SkipSnapshots", func(t *testing.T) {
+		path, err := ltx.ApplyPath(infos, ltx.NewPos(3, ltx.ChecksumFlag|3), ltx.NewPos(4, ltx.ChecksumFlag|4))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(path) != 1 || path[0] != infos[3] {
+			t.Fatalf("unexpected path: %+v", path)
+		}
+	})
+
+	// Files before the starting position are not required.
+	t.Run("PrunedHistory", func(t *testing.T) {
+		path, err := ltx.ApplyPath(infos[2:4], ltx.NewPos(2, ltx.ChecksumFlag|2), ltx.NewPos(4, ltx.ChecksumFlag|4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertFileInfoPtrs(t, path, infos[2], infos[3])
+	})
+
+	t.Run("AtTarget", func(t *testing.T) {
+		if path, err := ltx.ApplyPath(infos, ltx.NewPos(4, ltx.ChecksumFlag|4), ltx.NewPos(4, ltx.ChecksumFlag|4)); err != nil {
+			t.Fatal(err)
+		} else if len(path) != 0 {
+			t.Fatalf("unexpected path: %+v", path)
+		}
+	})
+
+	t.Run("ErrChecksumMismatch", func(t *testing.T) {
+		if _, err := ltx.ApplyPath(infos, ltx.NewPos(2, ltx.ChecksumFlag|9), ltx.NewPos(4, ltx.ChecksumFlag|4)); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrBehindStart", func(t *testing.T) {
+		if _, err := ltx.ApplyPath(infos, ltx.NewPos(4, ltx.ChecksumFlag|4), ltx.NewPos(3, ltx.ChecksumFlag|3)); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRetentionPolicy_Plan(t *testing.T) {
+	now := time.Date(2000, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	// Daily snapshots at level 2 plus level-zero files for each transaction.
+	newInfos := func() []*ltx.FileInfo {
+		return []*ltx.FileInfo{
+			{Level: 0, MinTXID: 1, MaxTXID: 1, PostApplyChecksum: ltx.ChecksumFlag | 1, CreatedAt: now.Add(-3 * day)},
+			{Level: 0, MinTXID: 2, MaxTXID: 2, PreApplyChecksum: ltx.ChecksumFlag | 1, PostApplyChecksum: ltx.ChecksumFlag | 2, CreatedAt: now.Add(-2 * day)},
+			{Level: 0, MinTXID: 3, MaxTXID: 3, PreApplyChecksum: ltx.ChecksumFlag | 2, PostApplyChecksum: ltx.ChecksumFlag | 3, CreatedAt: now.Add(-1 * day)},
+			{Level: 0, MinTXID: 4, MaxTXID: 4, PreApplyChecksum: ltx.ChecksumFlag | 3, PostApplyChecksum: ltx.ChecksumFlag | 4, CreatedAt: now.Add(-time.Hour)},
+			{Level: 2, MinTXID: 1, MaxTXID: 2, PostApplyChecksum: ltx.ChecksumFlag | 2, CreatedAt: now.Add(-2 * day)},
+			{Level: 2, MinTXID: 1, MaxTXID: 3, PostApplyChecksum: ltx.ChecksumFlag | 3, CreatedAt: now.Add(-1 * day)},
+		}
+	}
+
+	plan := func(tb testing.TB, policy ltx.RetentionPolicy, infos []*ltx.FileInfo) *ltx.RetentionPlan {
+		tb.Helper()
+		policy.Now = func() time.Time { return now }
+		plan, err := policy.Plan(ltx.NewFileInfoSliceIterator(infos))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return plan
+	}
+
+	t.Run("LatestOnly", func(t *testing.T) {
+		infos := newInfos()
+		p := plan(t, ltx.RetentionPolicy{}, infos)
+		assertFileInfoPtrs(t, p.Retain, infos[3], infos[5])
+		assertFileInfoPtrs(t, p.Delete, infos[0], infos[1], infos[2], infos[4])
+		if got, want := len(p.RestorePoints), 1; got != want {
+			t.Fatalf("len(RestorePoints)=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("L0Retention", func(t *testing.T) {
+		infos := newInfos()
+		p := plan(t, ltx.RetentionPolicy{L0Retention: 36 * time.Hour}, infos)
+		assertFileInfoPtrs(t, p.Retain, infos[2], infos[3], infos[5])
+		assertFileInfoPtrs(t, p.Delete, infos[0], infos[1], infos[4])
+	})
+
+	t.Run("SnapshotInterval", func(t *testing.T) {
+		infos := newInfos()
+		p := plan(t, ltx.RetentionPolicy{SnapshotInterval: day}, infos)
+		assertFileInfoPtrs(t, p.Retain, infos[0], infos[3], infos[4], infos[5])
+		assertFileInfoPtrs(t, p.Delete, infos[1], infos[2])
+	})
+
+	t.Run("SnapshotRetention", func(t *testing.T) {
+		infos := newInfos()
+		p := plan(t, ltx.RetentionPolicy{SnapshotInterval: day, SnapshotRetention: 36 * time.Hour}, infos)
+		assertFileInfoPtrs(t, p.Retain, infos[3], infos[5])
+	})
+
+	t.Run("KeepSinceLatestSnapshot", func(t *testing.T) {
+		infos := newInfos()
+		p := plan(t, ltx.RetentionPolicy{KeepSinceLatestSnapshot: true}, infos)
+		assertFileInfoPtrs(t, p.Retain, infos[3], infos[5])
+		if got, want := len(p.RestorePoints), 2; got != want {
+			t.Fatalf("len(RestorePoints)=%d, want %d", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		p := plan(t, ltx.RetentionPolicy{}, nil)
+		if len(p.Retain) != 0 || len(p.Delete) != 0 {
+			t.Fatalf("unexpected plan: %+v", p)
+		}
+	})
+
+	t.Run("ErrNoRestorePath", func(t *testing.T) {
+		infos := newInfos()
+		infos[3].PreApplyChecksum = ltx.ChecksumFlag | 9
+
+		policy := ltx.RetentionPolicy{Now: func() time.Time { return now }}
+		if _, err := policy.Plan(ltx.NewFileInfoSliceIterator(infos)); !errors.Is(err, ltx.ErrNoRestorePath) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// assertFileInfoPtrs fails if a does not contain exactly the given files in order.
+func assertFileInfoPtrs(tb testing.TB, a []*ltx.FileInfo, want ...*ltx.FileInfo) {
+	tb.Helper()
+	if len(a) != len(want) {
+		tb.Fatalf("len=%d, want %d", len(a), len(want))
+	}
+	for i := range a {
+		if a[i] != want[i] {
+			tb.Fatalf("%d. file=%+v, want %+v", i, a[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package ltx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// isZeroPage returns true if data contains only zero bytes.
+func isZeroPage(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// PageReader reads pages from a database file. Pages within holes of a sparse
+// file are returned as zeros without being read, on systems which support
+// finding holes.
+type PageReader struct {
+	f        *os.File
+	pageSize uint32
+	size     int64
+
+	// Last data & hole regions found by seeking, as [start, end) offsets.
+	dataStart, dataEnd int64
+	holeStart, holeEnd int64
+	noHoles            bool
+}
+
+// NewPageReader returns a new instance of PageReader. The size of the file
+// is read once so pages appended afterward are not read.
+func NewPageReader(f *os.File, pageSize uint32) (*PageReader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &PageReader{
+		f:        f,
+		pageSize: pageSize,
+		size:     fi.Size(),
+	}, nil
+}
+
+// ReadPage reads page pgno into data. Returns io.EOF if the page starts at or
+// after the end of the file, or io.ErrUnexpectedEOF if the file ends partway
+// through the page.
+//
+// Finding holes moves the file's offset so the file should only be read with
+// ReadAt() while in use by the reader.
+func (r *PageReader) ReadPage(pgno uint32, data []byte) error {
+	off := int64(pgno-1) * int64(r.pageSize)
+	if off >= r.size {
+		return io.EOF
+	} else if off+int64(r.pageSize) > r.size {
+		return io.ErrUnexpectedEOF
+	}
+
+	if hole, err := r.isHole(off); err != nil {
+		return err
+	} else if hole {
+		clear(data)
+		return nil
+	}
+
+	if _, err := r.f.ReadAt(data, off); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// isHole returns true if the page at off is entirely within a hole.
+func (r *PageReader) isHole(off int64) (bool, error) {
+	if r.noHoles || (off >= r.dataStart && off < r.dataEnd) {
+		return false, nil
+	} else if off >= r.holeStart && off+int64(r.pageSize) <= r.holeEnd {
+		return true, nil
+	}
+
+	// Find the next data region & the hole which follows it.
+	start, err := seekData(r.f, off)
+	if errors.Is(err, errors.ErrUnsupported) {
+		r.noHoles = true
+		return false, nil
+	} else if errors.Is(err, io.EOF) {
+		r.holeStart, r.holeEnd = off, r.size // trailing hole
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("seek data: %w", err)
+	} else if start >= off+int64(r.pageSize) {
+		r.holeStart, r.holeEnd = off, start
+		return true, nil
+	}
+
+	end, err := seekHole(r.f, start)
+	if err != nil {
+		return false, fmt.Errorf("seek hole: %w", err)
+	}
+	r.dataStart, r.dataEnd = min(start, off), end
+	return false, nil
+}
+
+// sparseFile is implemented by files which can be written sparsely.
+type sparseFile interface {
+	io.WriteSeeker
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// sparseWriter writes pages to a file, seeking past zero pages rather than
+// writing them so they become holes.
+type sparseWriter struct {
+	f    sparseFile
+	skip int64 // bytes of zeros not yet written
+}
+
+// newSparseWriter returns a sparseWriter if w is a file positioned at or after
+// its end, so skipped pages are known to read as zeros. Otherwise returns nil.
+func newSparseWriter(w io.Writer) (*sparseWriter, error) {
+	f, ok := w.(sparseFile)
+	if !ok {
+		return nil, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	if off, err := f.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	} else if off < fi.Size() {
+		return nil, nil
+	}
+	return &sparseWriter{f: f}, nil
+}
+
+// Write writes a page to the file unless it only contains zeros.
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	if isZeroPage(p) {
+		w.skip += int64(len(p))
+		return len(p), nil
+	}
+
+	if w.skip > 0 {
+		if _, err := w.f.Seek(w.skip, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		w.skip = 0
+	}
+	return w.f.Write(p)
+}
+
+// Flush extends the file to include trailing zero pages.
+func (w *sparseWriter) Flush() error {
+	if w.skip == 0 {
+		return nil
+	}
+
+	off, err := w.f.Seek(w.skip, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	w.skip = 0
+	return w.f.Truncate(off)
+}
@@ -0,0 +1,51 @@
+package ltx
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Whence values for finding data & holes in sparse files.
+const (
+	seekWhenceData = 3 // SEEK_DATA
+	seekWhenceHole = 4 // SEEK_HOLE
+)
+
+// Fallocate mode which deallocates a range without changing the file size.
+const fallocPunchHole = 0x01 | 0x02 // FALLOC_FL_KEEP_SIZE | FALLOC_FL_PUNCH_HOLE
+
+// punchHole deallocates n bytes of f starting at off so they read as zeros.
+func punchHole(f *os.File, off, n int64) error {
+	if err := syscall.Fallocate(int(f.Fd()), fallocPunchHole, off, n); errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return errors.ErrUnsupported
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// seekData returns the offset of the first data at or after off. Returns
+// io.EOF if there is no data after off.
+func seekData(f *os.File, off int64) (int64, error) {
+	return seekSparse(f, off, seekWhenceData)
+}
+
+// seekHole returns the offset of the first hole at or after off. The end of
+// the file is treated as a hole.
+func seekHole(f *os.File, off int64) (int64, error) {
+	return seekSparse(f, off, seekWhenceHole)
+}
+
+func seekSparse(f *os.File, off int64, whence int) (int64, error) {
+	ret, err := syscall.Seek(int(f.Fd()), off, whence)
+	if errors.Is(err, syscall.ENXIO) {
+		return 0, io.EOF
+	} else if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+		return 0, errors.ErrUnsupported
+	} else if err != nil {
+		return 0, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package ltx
+
+import (
+	"errors"
+	"os"
+)
+
+// punchHole is unsupported so zero pages are written instead.
+func punchHole(f *os.File, off, n int64) error { return errors.ErrUnsupported }
+
+// seekData is unsupported so holes are read as data.
+func seekData(f *os.File, off int64) (int64, error) { return 0, errors.ErrUnsupported }
+
+// seekHole is unsupported so holes are read as data.
+func seekHole(f *os.File, off int64) (int64, error) { return 0, errors.ErrUnsupported }
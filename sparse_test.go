@@ -0,0 +1,52 @@
+package ltx_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/superfly/ltx"
+)
+
+func TestPageReader_ReadPage(t *testing.T) {
+	// Create a file with data in page 3 & holes before & after it.
+	f, err := os.Create(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	data := bytes.Repeat([]byte{1}, 4096)
+	if _, err := f.WriteAt(data, 2*4096); err != nil {
+		t.Fatal(err)
+	} else if err := f.Truncate(5*4096 + 100); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ltx.NewPageReader(f, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Repeat([]byte{0xff}, 4096)
+	for pgno := uint32(1); pgno <= 5; pgno++ {
+		want := make([]byte, 4096)
+		if pgno == 3 {
+			want = data
+		}
+		if err := r.ReadPage(pgno, buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, want) {
+			t.Fatalf("page %d mismatch", pgno)
+		}
+	}
+
+	if err := r.ReadPage(6, buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := r.ReadPage(7, buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package ltx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Store represents a storage backend for LTX files organized by level.
+type Store interface {
+	// Open returns a reader for the LTX file identified by the level, timeline
+	// & TXID range of info. Returns an error wrapping fs.ErrNotExist if the
+	// file does not exist.
+	Open(ctx context.Context, info *FileInfo) (io.ReadCloser, error)
+
+	// Write atomically stores the LTX file read from r at the given level and
+	// returns its metadata. The file is named by the TXID range in its header.
+	// On error, no partial file is visible to readers.
+	Write(ctx context.Context, level int, r io.Reader) (*FileInfo, error)
+
+	// Delete removes the LTX file identified by info. Deleting a file that
+	// does not exist is not an error.
+	Delete(ctx context.Context, info *FileInfo) error
+
+	// List returns an iterator over files at level which overlap the TXID
+	// range, sorted by level & TXID. A negative level includes all levels and
+	// zero TXIDs are unbounded.
+	List(ctx context.Context, level int, minTXID, maxTXID TXID) (FileIterator, error)
+}
+
+// verifyFile decodes & verifies the LTX file read from r while copying it to w.
+func verifyFile(w io.Writer, r io.Reader) (*FileInfo, error) {
+	cw := &countingWriter{w: w}
+	dec := NewDecoder(io.TeeReader(r, cw))
+	if err := dec.Verify(); err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(dec.Header(), dec.Trailer(), cw.n), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+var _ Store = (*MemStore)(nil)
+
+// MemStore represents an in-memory implementation of Store.
+type MemStore struct {
+	mu    sync.Mutex
+	files map[memStoreKey]*memStoreFile
+}
+
+type memStoreKey struct {
+	level    int
+	timeline uint32
+	minTXID  TXID
+	maxTXID  TXID
+	shardMin uint32
+}
+
+type memStoreFile struct {
+	info *FileInfo
+	data []byte
+}
+
+// NewMemStore returns a new instance of MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		files: make(map[memStoreKey]*memStoreFile),
+	}
+}
+
+// Open returns a reader for the LTX file identified by info.
+func (s *MemStore) Open(ctx context.Context, info *FileInfo) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.files[newMemStoreKey(info)]
+	if f == nil {
+		return nil, fmt.Errorf("open %s: %w", FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno), fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Write stores the LTX file read from r at level.
+func (s *MemStore) Write(ctx context.Context, level int, r io.Reader) (*FileInfo, error) {
+	var buf bytes.Buffer
+	info, err := verifyFile(&buf, r)
+	if err != nil {
+		return nil, err
+	}
+	info.Level = level
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[newMemStoreKey(info)] = &memStoreFile{info: info, data: buf.Bytes()}
+
+	other := *info
+	return &other, nil
+}
+
+// Delete removes the LTX file identified by info.
+func (s *MemStore) Delete(ctx context.Context, info *FileInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, newMemStoreKey(info))
+	return nil
+}
+
+// List returns an iterator over files at level which overlap the TXID range.
+func (s *MemStore) List(ctx context.Context, level int, minTXID, maxTXID TXID) (FileIterator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var a []*FileInfo
+	for _, f := range s.files {
+		if level >= 0 && f.info.Level != level {
+			continue
+		} else if minTXID != 0 && f.info.MaxTXID < minTXID {
+			continue
+		} else if maxTXID != 0 && f.info.MinTXID > maxTXID {
+			continue
+		}
+
+		other := *f.info
+		a = append(a, &other)
+	}
+	return NewFileInfoSliceIterator(a), nil
+}
+
+func newMemStoreKey(info *FileInfo) memStoreKey {
+	return memStoreKey{
+		level:    info.Level,
+		timeline: info.Timeline,
+		minTXID:  info.MinTXID,
+		maxTXID:  info.MaxTXID,
+		shardMin: info.ShardMinPgno,
+	}
+}
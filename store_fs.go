@@ -0,0 +1,308 @@
+package ltx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ fs.FS = (*StoreFS)(nil)
+
+// StoreFS represents a read-only fs.FS view of the LTX files in a Store. The
+// file system contains the following paths:
+//
+//	pos                     latest position, formatted by Pos.String()
+//	ltx/<level>/<filename>  LTX file stored at level
+//	db@<txid>.sqlite        SQLite database at the position of txid
+//
+// Database files are composed on demand from the files in the store using
+// RestorePath() & DatabaseReader. They are not included in directory listings.
+type StoreFS struct {
+	ctx   context.Context
+	store Store
+}
+
+// NewStoreFS returns a new instance of StoreFS. The context is used for all
+// calls to the underlying store.
+func NewStoreFS(ctx context.Context, store Store) *StoreFS {
+	return &StoreFS{ctx: ctx, store: store}
+}
+
+// Open opens the named file or directory.
+func (fsys *StoreFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+func (fsys *StoreFS) open(name string) (fs.File, error) {
+	switch {
+	case name == ".":
+		return fsys.openRoot()
+	case name == "pos":
+		return fsys.openPos()
+	case name == "ltx":
+		return fsys.openLevels()
+	case strings.HasPrefix(name, "ltx/"):
+		level, filename, _ := strings.Cut(strings.TrimPrefix(name, "ltx/"), "/")
+		lvl, err := strconv.Atoi(level)
+		if err != nil || lvl < 0 || strconv.Itoa(lvl) != level {
+			return nil, fs.ErrNotExist
+		} else if filename == "" {
+			return fsys.openLevel(lvl)
+		}
+		return fsys.openLTXFile(lvl, filename)
+	case strings.HasPrefix(name, "db@") && strings.HasSuffix(name, ".sqlite"):
+		txID, err := ParseTXID(strings.TrimSuffix(strings.TrimPrefix(name, "db@"), ".sqlite"))
+		if err != nil {
+			return nil, fs.ErrNotExist
+		}
+		return fsys.openDatabase(name, txID)
+	default:
+		return nil, fs.ErrNotExist
+	}
+}
+
+// list returns all files in the store at level, or all levels if negative.
+func (fsys *StoreFS) list(level int) ([]*FileInfo, error) {
+	itr, err := fsys.store.List(fsys.ctx, level, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return SliceFileIterator(itr)
+}
+
+func (fsys *StoreFS) openRoot() (fs.File, error) {
+	ents := []fs.DirEntry{fs.FileInfoToDirEntry(newStoreFSDirInfo("ltx"))}
+
+	info, _, err := fsys.readPos()
+	if err == nil {
+		ents = append(ents, fs.FileInfoToDirEntry(info))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return newStoreFSDir(".", ents), nil
+}
+
+func (fsys *StoreFS) openPos() (fs.File, error) {
+	info, data, err := fsys.readPos()
+	if err != nil {
+		return nil, err
+	}
+	return newStoreFSFile(info, bytes.NewReader(data), nil), nil
+}
+
+// readPos returns the file info & contents of the pos file.
+func (fsys *StoreFS) readPos() (*storeFSFileInfo, []byte, error) {
+	infos, err := fsys.list(-1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	latest := LatestFileInfo(infos)
+	if latest == nil {
+		return nil, nil, fs.ErrNotExist
+	}
+
+	data := []byte(latest.Pos().String())
+	return &storeFSFileInfo{name: "pos", size: int64(len(data)), modTime: latest.CreatedAt}, data, nil
+}
+
+func (fsys *StoreFS) openLevels() (fs.File, error) {
+	infos, err := fsys.list(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ents []fs.DirEntry
+	for i, info := range infos {
+		if i == 0 || info.Level != infos[i-1].Level {
+			ents = append(ents, fs.FileInfoToDirEntry(newStoreFSDirInfo(strconv.Itoa(info.Level))))
+		}
+	}
+	return newStoreFSDir("ltx", ents), nil
+}
+
+func (fsys *StoreFS) openLevel(level int) (fs.File, error) {
+	infos, err := fsys.list(level)
+	if err != nil {
+		return nil, err
+	} else if len(infos) == 0 {
+		return nil, fs.ErrNotExist
+	}
+
+	ents := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		ents[i] = fs.FileInfoToDirEntry(newStoreFSLTXFileInfo(info))
+	}
+	return newStoreFSDir(strconv.Itoa(level), ents), nil
+}
+
+func (fsys *StoreFS) openLTXFile(level int, filename string) (fs.File, error) {
+	timeline, minTXID, maxTXID, shardMinPgno, err := ParseShardFilename(filename)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	itr, err := fsys.store.List(fsys.ctx, level, minTXID, maxTXID)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := SliceFileIterator(itr)
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(infos, func(info *FileInfo) bool {
+		return info.Timeline == timeline && info.MinTXID == minTXID && info.MaxTXID == maxTXID && info.ShardMinPgno == shardMinPgno
+	})
+	if i == -1 {
+		return nil, fs.ErrNotExist
+	}
+
+	rc, err := fsys.store.Open(fsys.ctx, infos[i])
+	if err != nil {
+		return nil, err
+	}
+	return newStoreFSFile(newStoreFSLTXFileInfo(infos[i]), rc, []io.Closer{rc}), nil
+}
+
+func (fsys *StoreFS) openDatabase(name string, txID TXID) (fs.File, error) {
+	r, err := OpenDatabaseReader(fsys.ctx, fsys.store, Pos{TXID: txID})
+	if errors.Is(err, ErrNoRestorePath) {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := &storeFSFileInfo{name: name, size: r.Size(), modTime: r.Timestamp()}
+	return newStoreFSFile(info, io.NewSectionReader(r, 0, r.Size()), []io.Closer{r}), nil
+}
+
+// storeFSFile represents a regular file in StoreFS.
+type storeFSFile struct {
+	info    *storeFSFileInfo
+	r       io.Reader
+	closers []io.Closer
+}
+
+// newStoreFSFile returns a file reading from r. The file also implements
+// io.Seeker & io.ReaderAt if r implements both.
+func newStoreFSFile(info *storeFSFileInfo, r io.Reader, closers []io.Closer) fs.File {
+	f := &storeFSFile{info: info, r: r, closers: closers}
+	if _, ok := r.(storeFSSeekReader); ok {
+		return &storeFSSeekFile{f}
+	}
+	return f
+}
+
+func (f *storeFSFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *storeFSFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+
+func (f *storeFSFile) Close() (err error) {
+	for _, c := range f.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	f.closers = nil
+	return err
+}
+
+type storeFSSeekReader interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+}
+
+// storeFSSeekFile represents a regular file in StoreFS with random access.
+type storeFSSeekFile struct {
+	*storeFSFile
+}
+
+func (f *storeFSSeekFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.(storeFSSeekReader).Seek(offset, whence)
+}
+
+func (f *storeFSSeekFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.(storeFSSeekReader).ReadAt(p, off)
+}
+
+// storeFSDir represents a directory in StoreFS.
+type storeFSDir struct {
+	info *storeFSFileInfo
+	ents []fs.DirEntry
+}
+
+func newStoreFSDir(name string, ents []fs.DirEntry) *storeFSDir {
+	return &storeFSDir{info: newStoreFSDirInfo(name), ents: ents}
+}
+
+func (d *storeFSDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *storeFSDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *storeFSDir) Close() error { return nil }
+
+// ReadDir returns the next n entries, or all remaining entries if n <= 0.
+func (d *storeFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		ents := d.ents
+		d.ents = nil
+		return ents, nil
+	} else if len(d.ents) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.ents))
+	ents := d.ents[:n]
+	d.ents = d.ents[n:]
+	return ents, nil
+}
+
+// storeFSFileInfo implements fs.FileInfo for files & directories in StoreFS.
+type storeFSFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newStoreFSDirInfo(name string) *storeFSFileInfo {
+	return &storeFSFileInfo{name: name, mode: fs.ModeDir | 0o555}
+}
+
+func newStoreFSLTXFileInfo(info *FileInfo) *storeFSFileInfo {
+	return &storeFSFileInfo{
+		name:    FormatShardFilename(info.Timeline, info.MinTXID, info.MaxTXID, info.ShardMinPgno),
+		size:    info.Size,
+		modTime: info.CreatedAt,
+	}
+}
+
+func (fi *storeFSFileInfo) Name() string { return fi.name }
+func (fi *storeFSFileInfo) Size() int64  { return fi.size }
+func (fi *storeFSFileInfo) Mode() fs.FileMode {
+	if fi.mode == 0 {
+		return 0o444
+	}
+	return fi.mode
+}
+func (fi *storeFSFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *storeFSFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *storeFSFileInfo) Sys() any           { return nil }
@@ -0,0 +1,85 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/superfly/ltx"
+)
+
+func TestStoreFS(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		newStore func(t *testing.T) ltx.Store
+	}{
+		{"FileStore", func(t *testing.T) ltx.Store { return ltx.NewFileStore(t.TempDir()) }},
+		{"MemStore", func(t *testing.T) ltx.Store { return ltx.NewMemStore() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.newStore(t)
+
+			img0 := newTestImage(t, 512, 3)
+			img1 := img0.Clone()
+			if err := img1.SetPage(4, bytes.Repeat([]byte{0xf4}, 512)); err != nil {
+				t.Fatal(err)
+			}
+			writeStoreFile(t, s, 1, encodeImageSnapshot(t, img0, 2))
+			writeStoreFile(t, s, 0, encodeImageDiff(t, img1, img0, 3, 3))
+
+			fsys := ltx.NewStoreFS(context.Background(), s)
+			if err := fstest.TestFS(fsys,
+				"pos",
+				"ltx/0/0000000000000003-0000000000000003.ltx",
+				"ltx/1/0000000000000001-0000000000000002.ltx",
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			if buf, err := fs.ReadFile(fsys, "pos"); err != nil {
+				t.Fatal(err)
+			} else if got, want := string(buf), ltx.NewPos(3, img1.Checksum()).String(); got != want {
+				t.Fatalf("pos=%q, want %q", got, want)
+			}
+
+			for txID, img := range map[ltx.TXID]*ltx.Image{2: img0, 3: img1} {
+				var want bytes.Buffer
+				if _, err := img.WriteTo(&want); err != nil {
+					t.Fatal(err)
+				}
+
+				if buf, err := fs.ReadFile(fsys, "db@"+txID.String()+".sqlite"); err != nil {
+					t.Fatal(err)
+				} else if !bytes.Equal(buf, want.Bytes()) {
+					t.Fatalf("database mismatch at txid %s", txID)
+				}
+			}
+
+			if _, err := fsys.Open("db@0000000000000001.sqlite"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("unexpected error: %v", err)
+			} else if _, err := fsys.Open("ltx/2"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		fsys := ltx.NewStoreFS(context.Background(), ltx.NewMemStore())
+		if err := fstest.TestFS(fsys, "ltx"); err != nil {
+			t.Fatal(err)
+		} else if _, err := fsys.Open("pos"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func writeStoreFile(tb testing.TB, s ltx.Store, level int, r io.Reader) {
+	tb.Helper()
+	if _, err := s.Write(context.Background(), level, r); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,46 @@
+package ltx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/superfly/ltx"
+	"github.com/superfly/ltx/ltxtest"
+)
+
+func TestFileStore(t *testing.T) {
+	ltxtest.TestStore(t, func(t *testing.T) ltx.Store {
+		return ltx.NewFileStore(t.TempDir())
+	})
+
+	t.Run("MissingDir", func(t *testing.T) {
+		ltxtest.TestStore(t, func(t *testing.T) ltx.Store {
+			return ltx.NewFileStore(filepath.Join(t.TempDir(), "db"))
+		})
+	})
+
+	// A file which fails verification is not stored & leaves no temporary file.
+	t.Run("ErrCorrupt", func(t *testing.T) {
+		s := ltx.NewFileStore(t.TempDir())
+		data := ltxtest.EncodeFile(t, 0, 1, 1)
+		data[len(data)-1] ^= 0xff
+
+		if _, err := s.Write(context.Background(), 0, bytes.NewReader(data)); !errors.Is(err, ltx.ErrChecksumMismatch) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ents, err := os.ReadDir(s.Path()); err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 0 {
+			t.Fatalf("unexpected files: %v", ents)
+		}
+	})
+}
+
+func TestMemStore(t *testing.T) {
+	ltxtest.TestStore(t, func(t *testing.T) ltx.Store {
+		return ltx.NewMemStore()
+	})
+}